@@ -24,32 +24,57 @@ import (
 type Accum struct {
 	value      float64
 	midnight   float64       // Value at the start of the day.
+	month      float64       // Value at the start of the month.
+	year       float64       // Value at the start of the year.
+	billing    float64       // Value at the start of the billing period.
+	offset     float64       // Offset added to the device values, set when the accumulator is rebased.
+	rebase     bool          // If set, rebase on the next update.
 	resettable bool          // If set, the value can be reset to a lower value.
 	ts         time.Time     // Timestamp of last update.
 	stale      time.Duration // Duration until stale
+	clock      Clock         // Source of the current time, or nil for the system clock
 }
 
 func NewAccum(cp string, resettable bool, shelfLife time.Duration) *Accum {
 	a := new(Accum)
 	a.stale = shelfLife
 	if len(cp) != 0 {
-		var sec int64
-		n, err := fmt.Sscanf(cp, "%f %f %d", &a.midnight, &a.value, &sec)
-		if sec != 0 {
-			a.ts = time.Unix(sec, 0)
+		if err := a.parse(cp); err != nil {
+			fmt.Printf("accum err: %v\n", err)
 		}
-		if err != nil {
-			fmt.Printf("%d parsed, accum err: %v\n", n, err)
-		}
-	}
-	if a.midnight > a.value {
-		a.midnight = a.value
 	}
+	a.clamp()
 	a.resettable = resettable
 	return a
 }
 
+// parse restores the accumulator from the checkpoint string.
+func (a *Accum) parse(cp string) error {
+	var sec int64
+	n, err := fmt.Sscanf(cp, "%f %f %d %f %f %f %f", &a.midnight, &a.value, &sec, &a.month, &a.year, &a.billing, &a.offset)
+	if sec != 0 {
+		a.ts = time.Unix(sec, 0)
+	}
+	if n == 3 {
+		// Older checkpoints do not have the period values.
+		a.month, a.year, a.billing = a.midnight, a.midnight, a.midnight
+		return nil
+	}
+	if n == 6 {
+		// The offset is only saved if the accumulator has been rebased.
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%d parsed: %v", n, err)
+	}
+	return nil
+}
+
 func (a *Accum) Update(v float64, ts time.Time) {
+	if a.rebase {
+		a.Rebase(v)
+	}
+	v += a.offset
 	// Check whether the accumulator has been reset.
 	if v < a.value {
 		if !a.resettable {
@@ -59,6 +84,9 @@ func (a *Accum) Update(v float64, ts time.Time) {
 		if v < a.midnight {
 			log.Printf("Accumulator reset, new value = %g, current = %g, midnight = %g\n", v, a.value, a.midnight)
 			a.midnight = v
+			a.month = min(a.month, v)
+			a.year = min(a.year, v)
+			a.billing = min(a.billing, v)
 		} else {
 			log.Printf("Accumulator going backwards, ignored, value = %g, current = %g, midnight = %g\n", v, a.value, a.midnight)
 		}
@@ -80,14 +108,68 @@ func (a *Accum) Timestamp() time.Time {
 }
 
 func (a *Accum) Fresh() bool {
-	return !a.Timestamp().Before(time.Now().Add(-a.stale))
+	return !a.Timestamp().Before(clockNow(a.clock).Add(-a.stale))
+}
+
+func (a *Accum) Rollover(p Period) {
+	switch p {
+	case P_MONTH:
+		a.month = a.value
+	case P_YEAR:
+		a.year = a.value
+	case P_BILLING:
+		a.billing = a.value
+	}
+}
+
+// clamp ensures that the starting values are not greater than the current value.
+func (a *Accum) clamp() {
+	a.midnight = min(a.midnight, a.value)
+	a.month = min(a.month, a.value)
+	a.year = min(a.year, a.value)
+	a.billing = min(a.billing, a.value)
 }
 
 // Create a checkpoint string.
 func (a *Accum) Checkpoint() string {
-	return fmt.Sprintf("%g %g %d", a.midnight, a.value, a.ts.Unix())
+	s := fmt.Sprintf("%g %g %d %g %g %g", a.midnight, a.value, a.ts.Unix(), a.month, a.year, a.billing)
+	if a.offset != 0 {
+		s = fmt.Sprintf("%s %g", s, a.offset)
+	}
+	return s
+}
+
+// Rebase sets the offset added to the values from the device so that
+// the accumulator continues from its current value, e.g when a meter is
+// replaced or a counter wraps. v is the current value of the device.
+func (a *Accum) Rebase(v float64) {
+	a.offset = a.value - v
+	a.rebase = false
+}
+
+// RebaseNext rebases the accumulator using the next value from the device.
+// The pending rebase is not saved in the checkpoint.
+func (a *Accum) RebaseNext() {
+	a.rebase = true
+}
+
+// Offset returns the offset added to the values from the device.
+func (a *Accum) Offset() float64 {
+	return a.offset
 }
 
 func (a *Accum) Daily() float64 {
 	return a.value - a.midnight
 }
+
+func (a *Accum) Monthly() float64 {
+	return a.value - a.month
+}
+
+func (a *Accum) Yearly() float64 {
+	return a.value - a.year
+}
+
+func (a *Accum) Billing() float64 {
+	return a.value - a.billing
+}
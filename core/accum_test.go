@@ -0,0 +1,109 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"time"
+
+	"testing"
+)
+
+func TestAccumPeriods(t *testing.T) {
+	// Older checkpoints do not have the period values.
+	a := NewAccum("90 100 10", false, time.Minute*10)
+	if !cmp(a.Daily(), 10) || !cmp(a.Monthly(), 10) || !cmp(a.Yearly(), 10) || !cmp(a.Billing(), 10) {
+		t.Errorf("NewAccum: got %v %v %v %v want 10\n", a.Daily(), a.Monthly(), a.Yearly(), a.Billing())
+	}
+	a = NewAccum("90 100 10 80 50 70", false, time.Minute*10)
+	if !cmp(a.Monthly(), 20) || !cmp(a.Yearly(), 50) || !cmp(a.Billing(), 30) {
+		t.Errorf("NewAccum: got %v %v %v want 20 50 30\n", a.Monthly(), a.Yearly(), a.Billing())
+	}
+	if cp := a.Checkpoint(); cp != "90 100 10 80 50 70" {
+		t.Errorf("Checkpoint: got %s\n", cp)
+	}
+	a.Update(110, time.Unix(20, 0))
+	a.Midnight()
+	a.Rollover(P_MONTH)
+	a.Update(115, time.Unix(30, 0))
+	if !cmp(a.Daily(), 5) || !cmp(a.Monthly(), 5) || !cmp(a.Yearly(), 65) || !cmp(a.Billing(), 45) {
+		t.Errorf("Rollover: got %v %v %v %v want 5 5 65 45\n", a.Daily(), a.Monthly(), a.Yearly(), a.Billing())
+	}
+}
+
+func TestBillingStart(t *testing.T) {
+	d := &DB{billingDay: 31}
+	for _, v := range []struct {
+		t, want string
+	}{
+		{"2026-03-15", "2026-02-28"},
+		{"2026-03-31", "2026-03-31"},
+		{"2026-04-30", "2026-04-30"},
+		{"2026-01-10", "2025-12-31"},
+	} {
+		tm, _ := time.Parse("2006-01-02", v.t)
+		if got := d.billingStart(tm).Format("2006-01-02"); got != v.want {
+			t.Errorf("billingStart(%s): got %s want %s\n", v.t, got, v.want)
+		}
+	}
+}
+
+// TestNewDayPeriods checks that the periods are rolled over when the
+// last date is a year earlier, or in a different location.
+func TestNewDayPeriods(t *testing.T) {
+	loc := time.FixedZone("AEDT", 11*3600)
+	d := NewDatabase(nil)
+	d.AddAccum("A", false)
+	a := d.GetAccum("A")
+	a.Update(100, time.Now())
+	// The same day of the year, a year later.
+	d.lastDate = time.Date(2023, 1, 1, 10, 0, 0, 0, loc)
+	d.newDay(time.Date(2024, 1, 1, 10, 0, 0, 0, loc))
+	if a.Daily() != 0 || a.Monthly() != 0 || a.Yearly() != 0 {
+		t.Errorf("year later: daily %g, monthly %g, yearly %g", a.Daily(), a.Monthly(), a.Yearly())
+	}
+	// The same date in UTC (e.g restored from the checkpoint) is not a new day.
+	a.Update(101, time.Now())
+	d.lastDate = time.Date(2024, 1, 2, 1, 0, 0, 0, loc).UTC()
+	d.newDay(time.Date(2024, 1, 2, 23, 0, 0, 0, loc))
+	if a.Daily() != 1 {
+		t.Errorf("same date in UTC: daily %g, want 1", a.Daily())
+	}
+}
+
+func TestAccumRebase(t *testing.T) {
+	a := NewAccum("90 100 10 80 50 70", false, time.Minute*10)
+	// The replacement meter starts from 2.
+	a.Rebase(2)
+	a.Update(5, time.Unix(20, 0))
+	if !cmp(a.Get(), 103) || !cmp(a.Daily(), 13) || !cmp(a.Offset(), 98) {
+		t.Errorf("Rebase: got %v %v %v want 103 13 98\n", a.Get(), a.Daily(), a.Offset())
+	}
+	cp := a.Checkpoint()
+	if cp != "90 103 20 80 50 70 98" {
+		t.Errorf("Checkpoint: got %s\n", cp)
+	}
+	a = NewAccum(cp, false, time.Minute*10)
+	a.Update(6, time.Unix(30, 0))
+	if !cmp(a.Get(), 104) || !cmp(a.Offset(), 98) {
+		t.Errorf("Restore: got %v %v want 104 98\n", a.Get(), a.Offset())
+	}
+	// A counter wrap, rebasing on the next update.
+	a.RebaseNext()
+	a.Update(1, time.Unix(40, 0))
+	a.Update(3, time.Unix(50, 0))
+	if !cmp(a.Get(), 106) || !cmp(a.Daily(), 16) || !cmp(a.Offset(), 103) {
+		t.Errorf("RebaseNext: got %v %v %v want 106 16 103\n", a.Get(), a.Daily(), a.Offset())
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2026 Andrew McRae
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"context"
+	"time"
+)
+
+// Backfill records are historical values (e.g from an inverter archive)
+// covering a period when MeterMan was not running. Features that
+// can retrieve historical data pass the records to the registered
+// backfill handlers (e.g the CSV writer and PVOutput uploader).
+
+// BackfillRecord holds the values for a single interval.
+type BackfillRecord struct {
+	Time   time.Time          // Time of interval
+	Values map[string]float64 // Values keyed by tag (the total for accumulators)
+	Daily  map[string]float64 // Daily values of accumulators, keyed by tag
+}
+
+// LastCheckpoint returns the time the checkpoint was last saved,
+// or the zero time if there is no checkpoint.
+func (d *DB) LastCheckpoint() time.Time {
+	return d.lastSaved
+}
+
+// AddBackfillHandler adds a handler that is called with the backfill records.
+func (d *DB) AddBackfillHandler(f func([]BackfillRecord)) {
+	d.backfillHandlers = append(d.backfillHandlers, hook[func([]BackfillRecord)]{d.current, f})
+}
+
+// Backfill passes the records (in time order) to the backfill handlers.
+// The handlers are called in the caller's goroutine, so this
+// must not be called from the main thread. If ctx is cancelled
+// (e.g the caller is being stopped by the main thread) before the
+// handlers are retrieved, the records are discarded.
+func (d *DB) Backfill(ctx context.Context, recs []BackfillRecord) {
+	hc := make(chan []func([]BackfillRecord), 1)
+	// Wait until the main thread is running, so that all the handlers are registered.
+	f := func() {
+		var hl []func([]BackfillRecord)
+		for _, h := range d.backfillHandlers {
+			hl = append(hl, h.f)
+		}
+		hc <- hl
+	}
+	var hl []func([]BackfillRecord)
+	select {
+	case d.run <- f:
+	case <-ctx.Done():
+		return
+	}
+	select {
+	case hl = <-hc:
+	case <-ctx.Done():
+		return
+	}
+	for _, h := range hl {
+		h(recs)
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright 2026 Andrew McRae
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"context"
+	"time"
+
+	"testing"
+)
+
+func TestBackfill(t *testing.T) {
+	d := NewDatabase(nil)
+	var got []BackfillRecord
+	d.AddBackfillHandler(func(recs []BackfillRecord) {
+		got = append(got, recs...)
+	})
+	recs := []BackfillRecord{{Time: time.Unix(1715659200, 0), Values: map[string]float64{D_GEN_P: 1.5}}}
+	// The records are discarded if the context is cancelled
+	// while waiting for the main thread.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	d.Backfill(ctx, recs)
+	if len(got) != 0 {
+		t.Errorf("cancelled backfill: got %d records", len(got))
+	}
+	// Discard any queued function.
+	select {
+	case <-d.run:
+	default:
+	}
+	runMain(t, d)
+	d.Backfill(context.Background(), recs)
+	if len(got) != 1 || got[0].Values[D_GEN_P] != 1.5 {
+		t.Errorf("backfill: got %v", got)
+	}
+}
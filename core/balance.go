@@ -0,0 +1,122 @@
+// Copyright 2026 Andrew McRae
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"log"
+	"time"
+)
+
+// The energy balance is derived from the grid, PV and battery values once per tick,
+// before the export functions are called, so that all exports use the same values.
+//
+// Household consumption is the PV generation plus the grid import, less the
+// grid export, less the battery charging (or plus the battery discharging).
+// The PV power exported to the grid is assumed to be the lesser of the export
+// power and the PV power, and the battery power used by the household is the
+// discharge power that is not exported to the grid.
+
+// balance holds the state of the energy balance calculation.
+type balance struct {
+	last time.Time // Time of last update
+}
+
+// initBalance adds the derived elements, if the grid power and energy
+// values are present.
+func (d *DB) initBalance() {
+	if d.GetElement(G_IN_POWER) == nil || d.GetElement(G_OUT_POWER) == nil {
+		return
+	}
+	if d.GetAccum(A_IN_TOTAL) == nil || d.GetAccum(A_OUT_TOTAL) == nil {
+		log.Printf("Grid power present without %s and %s, energy balance not calculated", A_IN_TOTAL, A_OUT_TOTAL)
+		return
+	}
+	d.balance = new(balance)
+	d.AddGauge(G_CONS_POWER)
+	d.AddGauge(G_SELF_POWER)
+	d.AddGauge(G_PV_GRID_POWER)
+	d.AddGauge(G_BATT_LOAD_POWER)
+	d.AddGauge(G_SELF_CONSUMPTION)
+	d.AddGauge(G_SELF_SUFFICIENCY)
+	d.AddAccum(A_PV_GRID, false)
+	d.AddAccum(A_BATT_LOAD, false)
+	// Household consumption energy.
+	cons := NewSumAccum(A_CONS_TOTAL)
+	cons.Add(d.GetAccum(A_IN_TOTAL), false, true)
+	cons.Add(d.GetAccum(A_OUT_TOTAL), true, true)
+	cons.Add(d.GetAccum(A_GEN_TOTAL), false, false)
+	cons.Add(d.GetAccum(A_DISCHARGE_TOTAL), false, false)
+	cons.Add(d.GetAccum(A_CHARGE_TOTAL), true, false)
+	d.AddElement(A_CONS_TOTAL, cons)
+}
+
+// updateBalance calculates the derived values.
+// This is called in the main thread before the export functions are run.
+func (d *DB) updateBalance(now time.Time) {
+	b := d.balance
+	if b == nil {
+		return
+	}
+	in := d.GetElement(G_IN_POWER)
+	out := d.GetElement(G_OUT_POWER)
+	if !in.Fresh() || !out.Fresh() {
+		b.last = time.Time{}
+		return
+	}
+	var gen, batt float64
+	if e := d.GetElement(D_GEN_P); e != nil && e.Fresh() {
+		gen = e.Get()
+	}
+	if e := d.GetElement(G_BATT_POWER); e != nil && e.Fresh() {
+		batt = e.Get()
+	}
+	cons := gen + in.Get() - out.Get() - batt
+	if cons < 0 {
+		if d.Trace {
+			log.Printf("Negative power consumption (%g), set to 0, gen = %g, in = %g, out = %g, battery = %g",
+				cons, gen, in.Get(), out.Get(), batt)
+		}
+		cons = 0
+	}
+	pvGrid := min(out.Get(), gen)
+	battLoad := max(0, -batt-max(0, out.Get()-gen))
+	d.GetElement(G_CONS_POWER).Update(cons, now)
+	d.GetElement(G_SELF_POWER).Update(gen-pvGrid, now)
+	d.GetElement(G_PV_GRID_POWER).Update(pvGrid, now)
+	d.GetElement(G_BATT_LOAD_POWER).Update(battLoad, now)
+	// Integrate the power values to derive the energy, if the previous
+	// update is recent enough.
+	if !b.last.IsZero() && now.Sub(b.last) <= d.freshness {
+		h := now.Sub(b.last).Hours()
+		d.addEnergy(A_PV_GRID, pvGrid*h, now)
+		d.addEnergy(A_BATT_LOAD, battLoad*h, now)
+	}
+	b.last = now
+	// Daily ratios, as percentages.
+	if g := d.GetAccum(A_GEN_TOTAL); g != nil && g.Daily() > 0 {
+		pct := (g.Daily() - d.GetAccum(A_PV_GRID).Daily()) / g.Daily() * 100
+		d.GetElement(G_SELF_CONSUMPTION).Update(min(max(pct, 0), 100), now)
+	}
+	if c := d.GetAccum(A_CONS_TOTAL); c.Daily() > 0 {
+		pct := (c.Daily() - d.GetAccum(A_IN_TOTAL).Daily()) / c.Daily() * 100
+		d.GetElement(G_SELF_SUFFICIENCY).Update(min(max(pct, 0), 100), now)
+	}
+}
+
+// addEnergy adds energy to a derived accumulator.
+func (d *DB) addEnergy(tag string, v float64, now time.Time) {
+	e := d.GetElement(tag)
+	e.Update(e.Get()+v, now)
+}
@@ -0,0 +1,98 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"time"
+
+	"testing"
+)
+
+func TestBalance(t *testing.T) {
+	d := NewDatabase(nil)
+	d.AddGauge(G_IN_POWER)
+	d.AddGauge(G_OUT_POWER)
+	d.AddAccum(A_IN_TOTAL, false)
+	d.AddAccum(A_OUT_TOTAL, false)
+	d.AddGauge(D_GEN_P)
+	d.AddAccum(A_GEN_TOTAL, false)
+	d.AddGauge(G_BATT_POWER)
+	d.initBalance()
+	now := time.Now()
+	set := func(tag string, v float64) {
+		d.GetElement(tag).Update(v, now)
+	}
+	set(A_IN_TOTAL, 100)
+	set(A_OUT_TOTAL, 50)
+	set(A_GEN_TOTAL, 200)
+	for _, tag := range []string{A_IN_TOTAL, A_OUT_TOTAL, A_GEN_TOTAL} {
+		d.GetAccum(tag).Midnight()
+	}
+	// Generating 5Kw, exporting 1Kw and charging the battery at 2Kw.
+	set(G_IN_POWER, 0)
+	set(G_OUT_POWER, 1)
+	set(D_GEN_P, 5)
+	set(G_BATT_POWER, 2)
+	set(A_IN_TOTAL, 102)
+	set(A_OUT_TOTAL, 53)
+	set(A_GEN_TOTAL, 210)
+	d.updateBalance(now)
+	for _, v := range []struct {
+		tag  string
+		want float64
+	}{
+		{G_CONS_POWER, 2},
+		{G_PV_GRID_POWER, 1},
+		{G_SELF_POWER, 4},
+		{G_BATT_LOAD_POWER, 0},
+		{G_SELF_CONSUMPTION, 100},
+		{G_SELF_SUFFICIENCY, 77.777},
+	} {
+		if got := d.GetElement(v.tag).Get(); !cmp(got, v.want) {
+			t.Errorf("%s: got %v want %v\n", v.tag, got, v.want)
+		}
+	}
+	// Consumption is 10 + 2 - 3 KwH
+	if got := d.GetAccum(A_CONS_TOTAL).Daily(); !cmp(got, 9) {
+		t.Errorf("%s: got %v want %v\n", A_CONS_TOTAL, got, 9.0)
+	}
+	// Updating the derived accumulator is ignored.
+	d.GetElement(A_CONS_TOTAL).Update(100, now)
+	if got := d.GetAccum(A_CONS_TOTAL).Daily(); !cmp(got, 9) {
+		t.Errorf("%s update: got %v want %v\n", A_CONS_TOTAL, got, 9.0)
+	}
+	// Discharging the battery at 3Kw, exporting 1Kw with no PV.
+	set(D_GEN_P, 0)
+	set(G_OUT_POWER, 1)
+	set(G_BATT_POWER, -3)
+	d.updateBalance(now.Add(time.Minute))
+	if got := d.GetElement(G_BATT_LOAD_POWER).Get(); !cmp(got, 2) {
+		t.Errorf("%s: got %v want %v\n", G_BATT_LOAD_POWER, got, 2.0)
+	}
+}
+
+func TestBalanceNoAccum(t *testing.T) {
+	d := NewDatabase(nil)
+	d.AddGauge(G_IN_POWER)
+	d.AddGauge(G_OUT_POWER)
+	d.initBalance()
+	if d.GetElement(G_CONS_POWER) != nil {
+		t.Errorf("%s: added without grid accumulators", G_CONS_POWER)
+	}
+	now := time.Now()
+	d.GetElement(G_IN_POWER).Update(1, now)
+	d.GetElement(G_OUT_POWER).Update(0, now)
+	d.updateBalance(now)
+}
@@ -16,10 +16,17 @@ package core
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
+	"io/fs"
 	"log"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -28,64 +35,352 @@ type Checkpoint interface {
 	Checkpoint() string
 }
 
-// readCheckpoint reads the checkpoint data into a map.
-// The checkpoint file contains lines of the form:
+// Current checkpoint file format version.
+const CheckpointVersion = 2
+
+// Prefix of the header line and the checksum line.
+const (
+	cpHeader = "#MeterMan-checkpoint"
+	cpCRC    = "#crc32="
+)
+
+// Element type markers in the checkpoint.
+const (
+	CP_GAUGE = "gauge"
+	CP_DIFF  = "diff"
+	CP_ACCUM = "accum"
+	CP_STATE = "state" // State saved by a feature, rather than an element
+)
+
+// CheckpointEntry is the saved state of a single element.
+type CheckpointEntry struct {
+	Type  string // Element type marker, empty in version 1 checkpoints
+	Value string // Element checkpoint string
+}
+
+// CheckpointData is the contents of a checkpoint file.
+type CheckpointData struct {
+	Version int                        // Format version
+	Host    string                     // Host that wrote the checkpoint
+	Time    time.Time                  // Time the checkpoint was written
+	Entries map[string]CheckpointEntry // Entries, keyed by tag
+}
+
+// The checkpoint file (version 2) is of the form:
+//
+//	#MeterMan-checkpoint version=2 host=<hostname> time=<unix seconds>
+//	<tag>:<type>:<checkpoint string>
+//	...
+//	#crc32=<checksum of the preceding lines>
+//
+// Version 1 checkpoint files have no header or checksum, and contain lines of the form:
 //
 //	<tag>:<checkpoint string>
 //
+// with the time saved under the tag "time".
+//
 // When a new element is created, the tag is used to find the checkpoint string
 // to be passed to the element's init function so that the element's value can be restored.
-func (d *DB) readCheckpoint(file string) error {
-	f, err := os.Open(file)
+// The checkpoint is written to a temporary file that is renamed once it is complete, and
+// the previous generations are kept as <file>.1, <file>.2 etc.
+
+// checkpointType returns the type marker for the element.
+func checkpointType(e Element) string {
+	switch e.(type) {
+	case *Gauge:
+		return CP_GAUGE
+	case *Diff:
+		return CP_DIFF
+	case *Accum:
+		return CP_ACCUM
+	}
+	return ""
+}
+
+// RestoreElement creates an element of the type given from the checkpoint string,
+// using the same parsing as when the element is added to the database.
+// An error is returned if the checkpoint string is invalid.
+func RestoreElement(typ, cp string) (Element, error) {
+	var err error
+	switch typ {
+	case CP_GAUGE:
+		g := new(Gauge)
+		err = g.parse(cp)
+		return g, err
+	case CP_DIFF:
+		d := new(Diff)
+		err = d.parse(cp)
+		return d, err
+	case CP_ACCUM:
+		a := new(Accum)
+		if err = a.parse(cp); err == nil {
+			a.clamp()
+		}
+		return a, err
+	}
+	return nil, fmt.Errorf("unknown element type '%s'", typ)
+}
+
+// CheckpointFile returns the name of a generation of the checkpoint file,
+// with 0 being the most recent.
+func CheckpointFile(file string, gen int) string {
+	if gen == 0 {
+		return file
+	}
+	return fmt.Sprintf("%s.%d", file, gen)
+}
+
+// ParseCheckpoint decodes the checkpoint data.
+func ParseCheckpoint(b []byte) (*CheckpointData, error) {
+	c := &CheckpointData{Entries: make(map[string]CheckpointEntry)}
+	if !bytes.HasPrefix(b, []byte(cpHeader)) {
+		return c, c.parseV1(b)
+	}
+	// The checksum is the last line, and covers all the preceding lines.
+	body := bytes.TrimSuffix(b, []byte("\n"))
+	i := bytes.LastIndexByte(body, '\n')
+	if i < 0 || !bytes.HasPrefix(body[i+1:], []byte(cpCRC)) {
+		return nil, fmt.Errorf("missing checksum (truncated file?)")
+	}
+	sum, err := strconv.ParseUint(string(body[i+1+len(cpCRC):]), 16, 32)
 	if err != nil {
-		// If the checkpoint file doesn't exist, skip trying to read it.
-		log.Printf("Unable to read %s (%v), no checkpoint data", file, err)
-		return nil
+		return nil, fmt.Errorf("bad checksum line: %v", err)
+	}
+	body = body[:i+1]
+	if crc := crc32.ChecksumIEEE(body); crc != uint32(sum) {
+		return nil, fmt.Errorf("checksum mismatch (%08x, expected %08x)", crc, sum)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(body), "\n"), "\n")
+	for _, f := range strings.Fields(lines[0])[1:] {
+		k, v, _ := strings.Cut(f, "=")
+		switch k {
+		case "version":
+			c.Version, err = strconv.Atoi(v)
+		case "host":
+			c.Host = v
+		case "time":
+			var sec int64
+			sec, err = strconv.ParseInt(v, 10, 64)
+			c.Time = time.Unix(sec, 0)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("header %s: %v", k, err)
+		}
+	}
+	if c.Version < 2 || c.Version > CheckpointVersion {
+		return nil, fmt.Errorf("unsupported version %d", c.Version)
 	}
-	defer f.Close()
-	log.Printf("Reading checkpoint data from %s", file)
-	r := bufio.NewReader(f)
-	lineno := 0
+	for n, l := range lines[1:] {
+		tag, rest, ok1 := strings.Cut(l, ":")
+		typ, v, ok2 := strings.Cut(rest, ":")
+		if !ok1 || !ok2 || len(tag) == 0 {
+			return nil, fmt.Errorf("line %d: bad entry", n+2)
+		}
+		c.Entries[tag] = CheckpointEntry{Type: typ, Value: v}
+	}
+	return c, nil
+}
+
+// parseV1 decodes a version 1 checkpoint.
+func (c *CheckpointData) parseV1(b []byte) error {
+	c.Version = 1
+	r := bufio.NewReader(bytes.NewReader(b))
 	for {
-		lineno++
 		s, err := r.ReadString('\n')
 		if err != nil {
-			if err != io.EOF {
-				return fmt.Errorf("checkpoint read %s: line %d: %v", file, lineno, err)
-			}
+			// An incomplete last line is ignored.
 			return nil
 		}
 		s = strings.TrimSuffix(s, "\n")
 		i := strings.IndexRune(s, ':')
-		if i > 0 {
-			d.checkpoint[s[:i]] = s[i+1:]
-			if d.Trace {
-				log.Printf("Checkpoint entry %s = %s\n", s[:i], s[i+1:])
+		if i <= 0 {
+			continue
+		}
+		if s[:i] == C_TIME {
+			sec, err := strconv.ParseInt(s[i+1:], 10, 64)
+			if err != nil {
+				return fmt.Errorf("time: %v", err)
 			}
+			c.Time = time.Unix(sec, 0)
+		} else {
+			c.Entries[s[:i]] = CheckpointEntry{Value: s[i+1:]}
 		}
 	}
 }
 
-// writeCheckpoint saves the values of the elements in the database to a checkpoint file.
-func (d *DB) writeCheckpoint(file string, now time.Time) {
-	if d.Trace {
-		log.Printf("Writing checkpoint data to %s", file)
+// Encode writes the checkpoint data in the current format, with the entries in tag order.
+func (c *CheckpointData) Encode(w io.Writer) error {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s version=%d host=%s time=%d\n", cpHeader, CheckpointVersion, c.Host, c.Time.Unix())
+	tags := make([]string, 0, len(c.Entries))
+	for t := range c.Entries {
+		tags = append(tags, t)
 	}
-	f, err := os.Create(file)
+	sort.Strings(tags)
+	for _, t := range tags {
+		e := c.Entries[t]
+		fmt.Fprintf(&b, "%s:%s:%s\n", t, e.Type, e.Value)
+	}
+	fmt.Fprintf(&b, "%s%08x\n", cpCRC, crc32.ChecksumIEEE(b.Bytes()))
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// ReadCheckpoint reads and decodes a single checkpoint file.
+func ReadCheckpoint(file string) (*CheckpointData, error) {
+	b, err := os.ReadFile(file)
 	if err != nil {
-		log.Printf("Checkpoint file create: %s %v", file, err)
+		return nil, err
+	}
+	return ParseCheckpoint(b)
+}
+
+// LoadCheckpoint reads the most recent generation of the checkpoint file
+// that can be decoded, returning the name of the file used. If none of
+// the generations exist, nil is returned.
+func LoadCheckpoint(file string, generations int) (*CheckpointData, string, error) {
+	var errs []error
+	for gen := 0; gen <= generations; gen++ {
+		fn := CheckpointFile(file, gen)
+		c, err := ReadCheckpoint(fn)
+		if err == nil {
+			return c, fn, nil
+		}
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		log.Printf("Checkpoint %s: %v", fn, err)
+		errs = append(errs, fmt.Errorf("%s: %w", fn, err))
+	}
+	return nil, "", errors.Join(errs...)
+}
+
+// WriteCheckpoint writes the checkpoint data to a temporary file, which
+// replaces the checkpoint file once it has been synced. The previous
+// generations of the checkpoint file are renamed, keeping the number requested.
+func WriteCheckpoint(file string, c *CheckpointData, generations int) error {
+	tmp := file + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	err = c.Encode(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	for gen := generations; gen > 0; gen-- {
+		err := os.Rename(CheckpointFile(file, gen-1), CheckpointFile(file, gen))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("Checkpoint rotate: %v", err)
+		}
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		return err
+	}
+	// Sync the directory so that the rename is durable.
+	if dir, err := os.Open(filepath.Dir(file)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
+
+// readCheckpoint reads the most recent valid generation of the checkpoint file
+// into the checkpoint map. If no generation is valid, the checkpoint file
+// is renamed to <file>.invalid (so that it is not rotated away, and can
+// be repaired), and no saved state is used.
+func (d *DB) readCheckpoint(file string) {
+	c, fn, err := LoadCheckpoint(file, d.generations)
+	if err != nil {
+		log.Printf("Checkpoint read %s: %v", file, err)
+		if err := os.Rename(file, file+".invalid"); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("Checkpoint: %v", err)
+		}
+		log.Printf("No valid checkpoint, starting without saved state")
+		return
+	}
+	if c == nil {
+		// If the checkpoint file doesn't exist, skip trying to read it.
+		log.Printf("Unable to read %s, no checkpoint data", file)
 		return
 	}
-	defer f.Close()
-	wr := bufio.NewWriter(f)
-	defer wr.Flush()
+	log.Printf("Read checkpoint data (version %d, %d entries) from %s", c.Version, len(c.Entries), fn)
+	if fn != file {
+		log.Printf("Warning: %s is invalid, using older checkpoint %s", file, fn)
+	}
+	if host, _ := os.Hostname(); len(c.Host) != 0 && c.Host != host {
+		log.Printf("Warning: checkpoint was written by host %s", c.Host)
+	}
+	for tag, e := range c.Entries {
+		if e.Type == CP_STATE {
+			d.state[tag] = e.Value
+		} else {
+			d.checkpoint[tag] = e
+		}
+		if d.Trace {
+			log.Printf("Checkpoint entry %s = %s %s\n", tag, e.Type, e.Value)
+		}
+	}
+	d.lastSaved = c.Time
+}
+
+// GetState returns a value saved in the checkpoint by a feature
+// (e.g to allocate the same tags to devices when restarted).
+// Must be called from the main thread.
+func (d *DB) GetState(key string) (string, bool) {
+	v, ok := d.state[key]
+	return v, ok
+}
+
+// SetState sets a value that is saved in the checkpoint. The key
+// must not be the tag of an element. Must be called from the main thread.
+func (d *DB) SetState(key, value string) {
+	d.state[key] = value
+}
+
+// restore returns the checkpoint string for the element, or
+// an empty string if the checkpoint is for a different type of element.
+func (d *DB) restore(tag, typ string) string {
+	e, ok := d.checkpoint[tag]
+	if !ok {
+		return ""
+	}
+	if len(e.Type) != 0 && e.Type != typ {
+		log.Printf("Checkpoint %s is a %s, not a %s - ignored", tag, e.Type, typ)
+		return ""
+	}
+	return e.Value
+}
+
+// writeCheckpoint saves the values of the elements in the database to the checkpoint file.
+func (d *DB) writeCheckpoint(file string, now time.Time) {
+	if d.Trace {
+		log.Printf("Writing checkpoint data to %s", file)
+	}
+	host, _ := os.Hostname()
+	c := &CheckpointData{Version: CheckpointVersion, Host: host, Time: now, Entries: make(map[string]CheckpointEntry)}
 	for n, e := range d.elements {
 		if ch, ok := e.(Checkpoint); ok {
 			s := ch.Checkpoint()
 			if len(s) != 0 {
-				fmt.Fprintf(wr, "%s:%s\n", n, s)
+				c.Entries[n] = CheckpointEntry{Type: checkpointType(e), Value: s}
 			}
 		}
 	}
-	fmt.Fprintf(wr, "%s:%d\n", C_TIME, now.Unix())
+	for k, v := range d.state {
+		c.Entries[k] = CheckpointEntry{Type: CP_STATE, Value: v}
+	}
+	if err := WriteCheckpoint(file, c, d.generations); err != nil {
+		log.Printf("Checkpoint write: %s %v", file, err)
+		return
+	}
+	// A feature restarted on reload only backfills the period since this checkpoint.
+	d.lastSaved = now
 }
@@ -0,0 +1,136 @@
+// Copyright 2026 Andrew McRae
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCheckpoint(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "checkpoint")
+	d := NewDatabase(nil)
+	d.generations = 2
+	d.AddGauge("G")
+	d.AddAccum("A", false)
+	d.AddDiff("D")
+	now := time.Unix(1715659200, 0)
+	d.GetElement("G").Update(3, now)
+	d.GetElement("A").Update(100, now)
+	d.SetState("S", "12 34")
+	for i := range 3 {
+		d.writeCheckpoint(fn, now.Add(time.Duration(i)*time.Minute))
+	}
+	for gen := range 3 {
+		if _, err := os.Stat(CheckpointFile(fn, gen)); err != nil {
+			t.Errorf("generation %d: %v", gen, err)
+		}
+	}
+	if _, err := os.Stat(fn + ".3"); err == nil {
+		t.Errorf("too many generations kept")
+	}
+	// No previous generations are kept if generations is 0.
+	fn0 := filepath.Join(t.TempDir(), "checkpoint")
+	d.generations = 0
+	d.writeCheckpoint(fn0, now)
+	d.writeCheckpoint(fn0, now.Add(time.Minute))
+	if _, err := os.Stat(CheckpointFile(fn0, 1)); err == nil {
+		t.Errorf("generation kept with generations 0")
+	}
+	d.generations = 2
+	c, err := ReadCheckpoint(fn)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if c.Version != CheckpointVersion || !c.Time.Equal(now.Add(2*time.Minute)) {
+		t.Errorf("header: version %d, time %s", c.Version, c.Time)
+	}
+	if e := c.Entries["A"]; e.Type != CP_ACCUM || e.Value != "0 100 1715659200 0 0 0" {
+		t.Errorf("accum entry: %+v", e)
+	}
+	if e := c.Entries["G"]; e.Type != CP_GAUGE || e.Value != "3 1715659200" {
+		t.Errorf("gauge entry: %+v", e)
+	}
+	if e := c.Entries["S"]; e.Type != CP_STATE || e.Value != "12 34" {
+		t.Errorf("state entry: %+v", e)
+	}
+	// Corrupt the newest generation, which falls back to the previous one.
+	b, _ := os.ReadFile(fn)
+	b[len(b)/2] ^= 1
+	os.WriteFile(fn, b, 0644)
+	c, used, err := LoadCheckpoint(fn, 2)
+	if err != nil || used != CheckpointFile(fn, 1) || !c.Time.Equal(now.Add(time.Minute)) {
+		t.Errorf("fallback: used %s, err %v", used, err)
+	}
+	// A truncated file is rejected.
+	os.WriteFile(fn, b[:len(b)-20], 0644)
+	if _, err := ReadCheckpoint(fn); err == nil {
+		t.Errorf("truncated file accepted")
+	}
+	// An element of a different type does not use the checkpoint.
+	d2 := NewDatabase(nil)
+	d2.generations = 2
+	d2.readCheckpoint(fn)
+	d2.AddGauge("A")
+	d2.AddAccum("G", false)
+	if v := d2.GetElement("A").Get(); v != 0 {
+		t.Errorf("gauge restored from accum: %g", v)
+	}
+	if v := d2.GetElement("G").Get(); v != 0 {
+		t.Errorf("accum restored from gauge: %g", v)
+	}
+	if s, ok := d2.GetState("S"); !ok || s != "12 34" {
+		t.Errorf("state: got %q want %q", s, "12 34")
+	}
+	if !d2.LastCheckpoint().Equal(now.Add(time.Minute)) {
+		t.Errorf("last checkpoint %s", d2.LastCheckpoint())
+	}
+	// No valid generations is an error.
+	for gen := 1; gen <= 2; gen++ {
+		os.WriteFile(CheckpointFile(fn, gen), []byte("#MeterMan-checkpoint version=2\n"), 0644)
+	}
+	if _, _, err := LoadCheckpoint(fn, 2); err == nil {
+		t.Errorf("invalid checkpoints accepted")
+	}
+	// The database starts without saved state, and keeps the invalid file.
+	d3 := NewDatabase(nil)
+	d3.generations = 2
+	d3.readCheckpoint(fn)
+	if len(d3.checkpoint) != 0 || len(d3.state) != 0 {
+		t.Errorf("invalid checkpoints used: %v %v", d3.checkpoint, d3.state)
+	}
+	if _, err := os.Stat(fn + ".invalid"); err != nil {
+		t.Errorf("invalid checkpoint not kept: %v", err)
+	}
+	// No checkpoint files is not an error.
+	if c, _, err := LoadCheckpoint(filepath.Join(t.TempDir(), "none"), 2); c != nil || err != nil {
+		t.Errorf("missing checkpoint: %v", err)
+	}
+}
+
+func TestCheckpointV1(t *testing.T) {
+	c, err := ParseCheckpoint([]byte("A:90 100 10\nG:5 20\ntime:1715659200\npartial"))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if c.Version != 1 || c.Time.Unix() != 1715659200 || len(c.Entries) != 2 {
+		t.Errorf("got version %d, time %d, %d entries", c.Version, c.Time.Unix(), len(c.Entries))
+	}
+	if e := c.Entries["A"]; e.Type != "" || e.Value != "90 100 10" {
+		t.Errorf("entry: %+v", e)
+	}
+}
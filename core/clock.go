@@ -0,0 +1,141 @@
+// Copyright 2026 Andrew McRae
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock is the source of the current time and of the timers used by the tickers,
+// so that the core timing can be simulated, e.g when recorded inputs are replayed.
+type Clock interface {
+	Now() time.Time
+	// AfterFunc calls f in its own goroutine after the duration has elapsed.
+	// The returned function stops the timer, returning false if it has already expired.
+	AfterFunc(d time.Duration, f func()) func() bool
+}
+
+// systemClock is the wall clock.
+type systemClock struct{}
+
+// SystemClock is the default clock, using the system time.
+var SystemClock Clock = systemClock{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) AfterFunc(d time.Duration, f func()) func() bool {
+	return time.AfterFunc(d, f).Stop
+}
+
+// clockNow returns the current time from the clock, or the system time if there is no clock.
+func clockNow(c Clock) time.Time {
+	if c == nil {
+		return time.Now()
+	}
+	return c.Now()
+}
+
+// SimClock is a simulated clock, which only advances when it is set.
+// The timer functions are called from the goroutine that sets the clock,
+// so that the timers expire in a deterministic order.
+// SimClock is also used as a fake clock in tests.
+type SimClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	seq    int // Sequence number of timers, so timers with the same expiry are ordered
+	timers []*simTimer
+}
+
+type simTimer struct {
+	when time.Time
+	seq  int
+	f    func()
+}
+
+// NewSimClock creates a simulated clock starting at the time given.
+func NewSimClock(t time.Time) *SimClock {
+	return &SimClock{now: t}
+}
+
+func (c *SimClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *SimClock) AfterFunc(d time.Duration, f func()) func() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seq++
+	t := &simTimer{when: c.now.Add(d), seq: c.seq, f: f}
+	c.timers = append(c.timers, t)
+	sort.Slice(c.timers, func(i, j int) bool {
+		a, b := c.timers[i], c.timers[j]
+		return a.when.Before(b.when) || (a.when.Equal(b.when) && a.seq < b.seq)
+	})
+	return func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		for i, pt := range c.timers {
+			if pt == t {
+				c.timers = append(c.timers[:i], c.timers[i+1:]...)
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Next returns the expiry time of the next timer, if any.
+func (c *SimClock) Next() (time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.timers) == 0 {
+		return time.Time{}, false
+	}
+	return c.timers[0].when, true
+}
+
+// Set advances the clock to the time given, calling the functions of
+// the timers that expire (in expiry order) with the clock set to the
+// expiry time of each timer. The clock is never moved backwards.
+func (c *SimClock) Set(t time.Time) {
+	for {
+		c.mu.Lock()
+		if len(c.timers) == 0 || c.timers[0].when.After(t) {
+			if t.After(c.now) {
+				c.now = t
+			}
+			c.mu.Unlock()
+			return
+		}
+		tm := c.timers[0]
+		c.timers = c.timers[1:]
+		if tm.when.After(c.now) {
+			c.now = tm.when
+		}
+		c.mu.Unlock()
+		tm.f()
+	}
+}
+
+// Advance moves the clock forward by the duration, calling the functions of the timers that expire.
+func (c *SimClock) Advance(d time.Duration) {
+	c.Set(c.Now().Add(d))
+}
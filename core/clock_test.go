@@ -0,0 +1,120 @@
+// Copyright 2026 Andrew McRae
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+// runMain runs the functions sent to the main thread until the test completes.
+func runMain(t *testing.T, d *DB) {
+	done := make(chan struct{})
+	t.Cleanup(func() { close(done) })
+	go func() {
+		for {
+			select {
+			case f := <-d.run:
+				f()
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+func TestFreshness(t *testing.T) {
+	c := NewSimClock(time.Date(2024, 5, 14, 10, 0, 0, 0, time.UTC))
+	d := NewDatabase(nil)
+	d.Clock = c
+	d.AddGauge("G")
+	d.AddAccum("A", false)
+	d.AddDiff("D")
+	d.GetElement("G").Update(1, c.Now())
+	d.GetElement("A").Update(1, c.Now())
+	d.GetElement("D").Update(1, c.Now())
+	d.GetElement("D").Update(2, c.Now())
+	c.Advance(d.freshness)
+	for _, tag := range []string{"G", "A", "D"} {
+		if !d.GetElement(tag).Fresh() {
+			t.Errorf("%s: stale after %s", tag, d.freshness)
+		}
+	}
+	c.Advance(time.Second)
+	for _, tag := range []string{"G", "A", "D"} {
+		if d.GetElement(tag).Fresh() {
+			t.Errorf("%s: fresh after %s", tag, d.freshness+time.Second)
+		}
+	}
+}
+
+// TestNewDay checks the midnight processing using the 30 minute callback,
+// in timezones that are not a multiple of 60 minutes, and on the days that
+// daylight saving time starts and ends. The accumulator is increased by 0.5
+// every 30 minutes, so the daily value is the number of hours in the day.
+func TestNewDay(t *testing.T) {
+	tests := []struct {
+		zone  string
+		start time.Time // Local time to start
+		want  []string  // Time of the rollover, and the daily value before the rollover
+	}{
+		{"Asia/Kolkata", time.Date(2024, 5, 14, 22, 10, 0, 0, time.UTC),
+			[]string{"2024-05-15 00:00 IST 1.5", "2024-05-16 00:00 IST 24"}},
+		// The 30 minute checks are at 15 and 45 minutes past the hour.
+		{"Asia/Kathmandu", time.Date(2024, 5, 14, 22, 10, 0, 0, time.UTC),
+			[]string{"2024-05-15 00:15 +0545 2", "2024-05-16 00:15 +0545 24"}},
+		// Daylight saving starts at 2am.
+		{"Australia/Adelaide", time.Date(2024, 10, 5, 22, 10, 0, 0, time.UTC),
+			[]string{"2024-10-06 00:00 ACST 1.5", "2024-10-07 00:00 ACDT 23"}},
+		// Daylight saving ends at 3am.
+		{"Australia/Adelaide", time.Date(2024, 4, 6, 22, 10, 0, 0, time.UTC),
+			[]string{"2024-04-07 00:00 ACDT 1.5", "2024-04-08 00:00 ACST 25"}},
+		// Daylight saving starts at midnight, so the day starts at 1am.
+		{"America/Santiago", time.Date(2024, 9, 7, 22, 10, 0, 0, time.UTC),
+			[]string{"2024-09-08 01:00 -03 1.5", "2024-09-09 00:00 -03 23"}},
+	}
+	for _, tc := range tests {
+		loc, err := time.LoadLocation(tc.zone)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.zone, err)
+		}
+		y, m, day := tc.start.Date()
+		start := time.Date(y, m, day, tc.start.Hour(), tc.start.Minute(), 0, 0, loc)
+		c := NewSimClock(start)
+		d := NewDatabase(nil)
+		d.Clock = c
+		d.lastDate = start
+		runMain(t, d)
+		d.AddAccum("A", false)
+		a := d.GetAccum("A")
+		var got []string
+		d.AddCallback(time.Minute*30, 0, func(now time.Time) {
+			daily := a.Daily()
+			last := d.lastDate
+			d.newDay(now)
+			if !d.lastDate.Equal(last) {
+				got = append(got, fmt.Sprintf("%s %g", now.Format("2006-01-02 15:04 MST"), daily))
+			}
+			a.Update(a.Get()+0.5, now)
+		})
+		c.Set(time.Date(y, m, day+2, 1, 0, 0, 0, loc))
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("%s from %s: got %q, want %q", tc.zone, start.Format(time.RFC3339), got, tc.want)
+		}
+	}
+}
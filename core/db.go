@@ -48,11 +48,13 @@
 //
 // All setup (registering callbacks, creating database elements etc) must be completed
 // as part of the Start() initialisation, before the processing loop is entered.
+// The exception is when the configuration is reloaded (on SIGHUP), when modules
+// whose configuration has changed are stopped and re-initialised in the main thread.
 
 package core
 
 import (
-	"bytes"
+	"context"
 	"fmt"
 	"log"
 	"os"
@@ -65,34 +67,60 @@ import (
 )
 
 type DbConfig struct {
-	Checkpoint string // Checkpoint file
-	Update     int    // Update interval for checkpoint in seconds
-	Freshness  int    // Number of minutes before data is considered stale
-	Daylight   [2]int // Defines the limits of daylight hours
+	Checkpoint      string            // Checkpoint file
+	Update          int               // Update interval for checkpoint in seconds
+	Generations     *int              // Number of previous checkpoint files kept
+	Freshness       int               // Number of minutes before data is considered stale
+	Daylight        [2]int            // Defines the limits of daylight hours
+	History         string            // Base directory of history store
+	Historyinterval int               // Interval for recording history in seconds
+	Billingday      int               // Day of month that the billing period starts
+	Record          string            // File that the inputs are recorded to
+	Computed        map[string]string // Expression elements
 }
 
 type statusPrinter func() string
 
 // DB contains the element database.
 type DB struct {
-	Config map[string]*yaml.Decoder // Decoded config
-	Trace  bool                     // If true, provide tracing
-	Dryrun bool                     // If true, validate only
+	Config     map[string]*yaml.Decoder // Decoded config
+	ConfigFile string                   // Config file, re-read on SIGHUP
+	Trace      bool                     // If true, provide tracing
+	Dryrun     bool                     // If true, validate only
+	Replay     string                   // If set, the recording to replay
+	Clock      Clock                    // Source of the current time, set before any elements are added
 	// StartHour and EndHour define the limit of daylight hours.
 	StartHour int
 	EndHour   int
 
-	yaml       []byte                        // YAML config
-	input      chan input                    // Channel for tagged data
-	run        chan func()                   // Channel for callbacks
-	pollList   []func()                      // List of polling functions
-	exportMap  map[tickKey][]func(time.Time) // List of export functions
-	elements   map[string]Element            // Map of tags to elements
-	checkpoint map[string]string             // Initial checkpoint data
-	disabled   map[string]struct{}           // Map of disabled features
-	lastDay    int                           // Current day, to check for midnight processing
-	freshness  time.Duration                 // shelf life of data
-	status     map[string]statusPrinter      // Map of status reporters
+	yaml        []byte                                               // YAML config
+	sections    map[string][]byte                                    // YAML config sections
+	core        *feature                                             // Owner of resources added by the core
+	current     *feature                                             // Feature being initialised
+	input       chan input                                           // Channel for tagged data
+	run         chan func()                                          // Channel for callbacks
+	pollList    []hook[func(context.Context)]                        // List of polling functions
+	exportMap   map[tickKey][]hook[func(context.Context, time.Time)] // List of export functions
+	exportTick  map[tickKey]*Ticker                                  // Tickers for export functions
+	elements    map[string]Element                                   // Map of tags to elements
+	checkpoint  map[string]CheckpointEntry                           // Initial checkpoint data
+	state       map[string]string                                    // Feature state saved in the checkpoint
+	generations int                                                  // Number of previous checkpoint files kept
+	disabled    map[string]struct{}                                  // Map of disabled features
+	lastDate    time.Time                                            // Current date, to check for midnight and period processing
+	billingDay  int                                                  // Day of month that the billing period starts
+	freshness   time.Duration                                        // shelf life of data
+	status      map[string]statusPrinter                             // Map of status reporters
+	history     *History                                             // History store, if configured
+	limiters    map[string]PowerLimiter                              // Map of power limiters
+	events      []Event                                              // Recent events
+	lastSaved   time.Time                                            // Time of checkpoint
+	balance     *balance                                             // Energy balance, if grid values are present
+	recorder    *recorder                                            // Input recorder, if configured
+	exporting   sync.WaitGroup                                       // Exports in progress
+	// Event and backfill handlers
+	eventHandlers    []hook[func(Event)]
+	backfillHandlers []hook[func([]BackfillRecord)]
 }
 
 type input struct {
@@ -105,31 +133,27 @@ type tickKey struct {
 	offs time.Duration
 }
 
-// List of functions to call after checkpoint data is available.
-// Used to initialise database elements.
-var initHook []func(*DB) error
-
-// Register an init function.
-// These will be called once the checkpoint data is read.
-func RegisterInit(f func(*DB) error) {
-	initHook = append(initHook, f)
-}
-
 // NewDatabase creates a new database handler.
 func NewDatabase(conf []byte) *DB {
 	d := new(DB)
 	d.Config = make(map[string]*yaml.Decoder)
 	d.yaml = conf
-	d.exportMap = make(map[tickKey][]func(time.Time))
+	d.Clock = SystemClock
+	d.exportMap = make(map[tickKey][]hook[func(context.Context, time.Time)])
+	d.exportTick = make(map[tickKey]*Ticker)
 	d.elements = make(map[string]Element)
-	d.checkpoint = make(map[string]string)
+	d.checkpoint = make(map[string]CheckpointEntry)
+	d.state = make(map[string]string)
 	d.disabled = make(map[string]struct{})
 	d.status = make(map[string]statusPrinter)
+	d.limiters = make(map[string]PowerLimiter)
 	d.StartHour = 5                // 5AM
 	d.EndHour = 20                 // 8PM
 	d.freshness = time.Minute * 10 // Data has shelf life of 10 minutes
 	d.input = make(chan input, 200)
 	d.run = make(chan func(), 100)
+	d.core = newFeature()
+	d.current = d.core
 	return d
 }
 
@@ -138,30 +162,12 @@ func NewDatabase(conf []byte) *DB {
 // and then enters a select loop processing the tag data inputs and tick events.
 func (d *DB) Start() error {
 	// Generate separate subsections for the YAML configuration
-	m := make(map[string]any)
-	err := yaml.Unmarshal(d.yaml, &m)
+	sections, err := d.splitConfig(d.yaml)
 	if err != nil {
 		return err
 	}
-	for k, v := range m {
-		// If a config section has been disabled, do not
-		// save that section.
-		_, ok := d.disabled[k]
-		if ok {
-			log.Printf("Disabling feature %s", k)
-			continue
-		}
-		b, err := yaml.Marshal(v)
-		if err != nil {
-			return fmt.Errorf("YAML marshal of %s failed: %v", k, err)
-		}
-		// Create a YAML decoder for each separate subsection of the YAML config file.
-		d.Config[k] = yaml.NewDecoder(bytes.NewReader(b))
-		d.Config[k].KnownFields(true)
-		if d.Trace || d.Dryrun {
-			log.Printf("YAML section %s = %v", k, v)
-		}
-	}
+	d.sections = sections
+	d.Config = decoders(sections)
 	var conf DbConfig
 	yaml, ok := d.Config["db"]
 	if ok {
@@ -175,120 +181,218 @@ func (d *DB) Start() error {
 	d.EndHour = ConfigOrDefault(conf.Daylight[1], d.EndHour)
 	d.freshness = ConfigOrDefault(time.Minute*time.Duration(conf.Freshness), d.freshness)
 	update := ConfigOrDefault(conf.Update, 60) // default of 60 seconds
+	d.billingDay = ConfigOrDefault(conf.Billingday, 1)
+	if d.billingDay < 1 || d.billingDay > 31 {
+		return fmt.Errorf("invalid billing day %d", d.billingDay)
+	}
+	// If a recording is being replayed, a simulated clock is used, starting at the time of
+	// the first input. The clock must be set before any tickers or elements are created.
+	var replayDone chan struct{}
+	var rec *Recording
+	var recs []Record
+	var simClock *SimClock
+	if len(d.Replay) != 0 {
+		if rec, err = ReadRecording(d.Replay); err != nil {
+			return err
+		}
+		recs = rec.Records
+		if len(recs) == 0 {
+			return fmt.Errorf("%s: no recorded inputs", d.Replay)
+		}
+		simClock = NewSimClock(recs[0].Time)
+		d.Clock = simClock
+		replayDone = make(chan struct{})
+		log.Printf("Replaying %d inputs from %s, %s to %s", len(recs), d.Replay,
+			recs[0].Time.Format(time.UnixDate), recs[len(recs)-1].Time.Format(time.UnixDate))
+		// The checkpoint and history are not read or written, so that
+		// the live files are not overwritten with the replayed values.
+		if len(conf.Checkpoint) != 0 || len(conf.History) != 0 {
+			log.Printf("Replay: checkpoint and history not used")
+			conf.Checkpoint, conf.History = "", ""
+		}
+	} else if len(conf.Record) != 0 && !d.Dryrun {
+		if d.recorder, err = newRecorder(conf.Record); err != nil {
+			return err
+		}
+		log.Printf("Recording inputs to %s", conf.Record)
+		d.AddCallback(time.Minute, 0, func(time.Time) {
+			d.recorder.flush()
+		})
+	}
 	// If a checkpoint file is configured, read it, and set up a
 	// regular callback to write it. The checkpoint file must be
 	// read before the init hooks are called.
 	if len(conf.Checkpoint) != 0 {
-		log.Printf("Checkpoint file %s, updated every %d seconds", conf.Checkpoint, update)
+		d.generations = 2 // default of 2 previous generations
+		if conf.Generations != nil {
+			d.generations = max(*conf.Generations, 0)
+		}
+		log.Printf("Checkpoint file %s, updated every %d seconds, %d previous generations kept", conf.Checkpoint, update, d.generations)
 		if !d.Dryrun {
-			if err := d.readCheckpoint(conf.Checkpoint); err != nil {
-				return err
-			}
+			d.readCheckpoint(conf.Checkpoint)
 			// Add a callback to checkpoint the database at the specified interval.
 			d.AddCallback(time.Second*time.Duration(update), time.Second*15, func(now time.Time) {
 				d.writeCheckpoint(conf.Checkpoint, now)
 			})
 		}
 	}
-	// Get the last saved time from the checkpoint file.
-	var last time.Time
-	lt, ok := d.checkpoint[C_TIME]
-	if !ok {
-		last = time.Now()
-	} else {
-		var sec int64
-		fmt.Sscanf(lt, "%d", &sec)
-		last = time.Unix(sec, 0)
-		if d.Trace {
-			log.Printf("Last time saved was %s\n", last.Format(time.UnixDate))
+	// If a history store is configured, record the elements at the selected interval.
+	// The store is created before the init hooks are called so that
+	// features may access it.
+	if len(conf.History) != 0 {
+		hInterval := ConfigOrDefault(conf.Historyinterval, 60) // default of 60 seconds
+		log.Printf("History stored in %s, recorded every %d seconds", conf.History, hInterval)
+		if !d.Dryrun {
+			d.history = NewHistory(conf.History)
+			d.AddExport(time.Second*time.Duration(hInterval), 0, func(_ context.Context, now time.Time) {
+				d.recordHistory(now)
+			})
 		}
 	}
+	// Get the last saved time from the checkpoint file.
+	last := d.lastSaved
+	if last.IsZero() {
+		last = d.Clock.Now()
+	} else if d.Trace {
+		log.Printf("Last time saved was %s\n", last.Format(time.UnixDate))
+	}
 	// Call the init hooks, which initialises all the registered features.
-	for _, h := range initHook {
-		if err := h(d); err != nil {
+	for _, f := range features {
+		if err := d.startFeature(f); err != nil {
 			return err
 		}
 	}
-	d.lastDay = last.YearDay()
+	d.lastDate = last
+	// Add the elements that receive the replayed inputs, if the
+	// features providing them (i.e the input modules) are not configured.
+	if rec != nil {
+		d.addRecorded(rec.Elements)
+	}
+	// Add the energy balance elements once all the features have added their elements.
+	d.initBalance()
+	if err := d.addExpressions(conf.Computed); err != nil {
+		return err
+	}
 	// Add a callback to check for daily midnight updating. This is done
 	// every 30 minutes (for timezones that are not a multiple of 60 minutes).
 	d.AddCallback(time.Minute*30, 0, d.newDay)
 	// Check for midnight rollover from checkpoint
-	d.newDay(time.Now())
+	d.newDay(d.Clock.Now())
 	log.Printf("Freshness timeout = %s, daylight start %d:00, end %d:00", d.freshness.String(), d.StartHour, d.EndHour)
 	if d.Dryrun {
 		log.Fatalf("Dry run only, exiting")
 	}
 
 	// Initialize the export function callbacks
-	for t, fl := range d.exportMap {
-		if d.Trace {
-			log.Printf("Adding export, tick %v, offs %v, f count %d, poll count %d", t.tick, t.offs, len(fl), len(d.pollList))
-		}
-		NewTicker(t.tick, t.offs, func(now time.Time) {
-			go func() {
-				// Run poll list in separate goroutine
-				var wg sync.WaitGroup
-				for _, f := range d.pollList {
-					wg.Go(f)
-				}
-				wg.Wait()
-				// Run exports in main thread once all pollers have finished
-				d.Execute(func() {
-					d.runExport(now, fl)
-				})
-			}()
-		})
-	}
+	d.startExports()
 	if d.Trace {
 		// Add a callback dump the state of the database every minute
 		d.AddCallback(time.Minute*time.Duration(1), 0, func(now time.Time) {
 			d.dumpDB()
 		})
 	}
+	if simClock != nil {
+		go d.replay(recs, simClock, replayDone)
+	}
 	// Register some signal handlers for graceful termination
 	sigc := make(chan os.Signal, 1)
 	signal.Notify(sigc, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
 	for {
 		select {
+		case <-replayDone:
+			log.Printf("Replay complete, shutting down")
+			d.shutdown(conf.Checkpoint)
+			return nil
 		case r := <-d.input:
 			// Process input data
 			d.processInput(r)
 		case f := <-d.run:
 			// Request to run callback in main thread
 			f()
-		case <-sigc:
-			// Signal caught, write checkpoint file and exit.
-			if len(conf.Checkpoint) != 0 {
-				d.writeCheckpoint(conf.Checkpoint, time.Now())
+		case s := <-sigc:
+			if s == syscall.SIGHUP {
+				// Reload the configuration.
+				log.Printf("SIGHUP received, reloading %s", d.ConfigFile)
+				d.reload()
+				continue
 			}
-			log.Fatalf("Signal received, exiting")
+			// Signal caught, stop the features, write checkpoint file and exit.
+			log.Printf("Signal received, shutting down")
+			d.shutdown(conf.Checkpoint)
+			log.Printf("Shutdown complete")
+			return nil
 		}
 	}
 }
 
 // AddPoll adds a polling function, called before
-// any Export function is called
-func (d *DB) AddPoll(f func()) {
-	d.pollList = append(d.pollList, f)
+// any Export function is called. The context passed is
+// cancelled when the feature adding the poller is stopped.
+func (d *DB) AddPoll(f func(context.Context)) {
+	d.pollList = append(d.pollList, hook[func(context.Context)]{d.current, f})
 }
 
-// AddExport adds an export function.
-func (d *DB) AddExport(t, o time.Duration, f func(time.Time)) {
+// AddExport adds an export function. The context passed is
+// cancelled when the feature adding the export is stopped, and
+// may be used for requests sent by the export function.
+func (d *DB) AddExport(t, o time.Duration, f func(context.Context, time.Time)) {
 	k := tickKey{tick: t, offs: o}
-	d.exportMap[k] = append(d.exportMap[k], f)
+	d.exportMap[k] = append(d.exportMap[k], hook[func(context.Context, time.Time)]{d.current, f})
+}
+
+// startExports starts a ticker for each export interval, and
+// cancels the tickers of intervals that no longer have any exports.
+func (d *DB) startExports() {
+	for k, t := range d.exportTick {
+		if _, ok := d.exportMap[k]; !ok {
+			t.Cancel()
+			delete(d.exportTick, k)
+		}
+	}
+	for k, fl := range d.exportMap {
+		if _, ok := d.exportTick[k]; ok {
+			continue
+		}
+		if d.Trace {
+			log.Printf("Adding export, tick %v, offs %v, f count %d, poll count %d", k.tick, k.offs, len(fl), len(d.pollList))
+		}
+		d.exportTick[k] = newTicker(d.Clock, k.tick, k.offs, func(now time.Time) {
+			d.exporting.Add(1)
+			go func() {
+				defer d.exporting.Done()
+				// Get the current poll list from the main thread.
+				var pl []func()
+				d.Execute(func() {
+					for _, p := range d.pollList {
+						pl = append(pl, func() { p.f(p.owner.ctx) })
+					}
+				})
+				// Run poll list in separate goroutine
+				var wg sync.WaitGroup
+				for _, f := range pl {
+					wg.Go(f)
+				}
+				wg.Wait()
+				// Run exports in main thread once all pollers have finished
+				d.Execute(func() {
+					d.runExport(now, k)
+				})
+			}()
+		})
+	}
 }
 
-// runExport invokes the export function(s)
-func (d *DB) runExport(now time.Time, fl []func(time.Time)) {
+// runExport invokes the export function(s) for the interval
+func (d *DB) runExport(now time.Time, k tickKey) {
 	// Ensure that the input channel is fully drained
 	d.drainInput()
 	if d.Trace {
-		log.Printf("Export run, delay = %v", time.Now().Sub(now))
+		log.Printf("Export run, delay = %v", d.Clock.Now().Sub(now))
 	}
+	d.updateBalance(now)
 	// Now invoke the export functions
-	for _, f := range fl {
-		f(now)
+	for _, h := range d.exportMap[k] {
+		h.f(h.owner.ctx, now)
 	}
 }
 
@@ -298,6 +402,12 @@ func (d *DB) Disable(feat string) {
 	d.disabled[feat] = struct{}{}
 }
 
+// Replaying returns true if a recording is being replayed, in which case
+// the output modules should not send data to external services.
+func (d *DB) Replaying() bool {
+	return len(d.Replay) != 0
+}
+
 // Input sends tagged input data to the input channel
 func (d *DB) Input(tag string, value float64) {
 	d.input <- input{tag, value}
@@ -305,9 +415,13 @@ func (d *DB) Input(tag string, value float64) {
 
 func (d *DB) processInput(r input) {
 	// Received tagged data from producer.
+	now := d.Clock.Now()
 	h, ok := d.elements[r.tag]
+	if d.recorder != nil {
+		d.recorder.record(now, r.tag, r.value, d.elementDef(r.tag, h))
+	}
 	if ok {
-		h.Update(r.value, time.Now())
+		h.Update(r.value, now)
 	} else {
 		log.Printf("Unknown tag: %s\n", r.tag)
 	}
@@ -326,12 +440,18 @@ func (d *DB) drainInput() {
 }
 
 // AddCallback adds a callback to be regularly invoked at the interval specified.
+// The callback is cancelled when the feature adding it is stopped.
 func (d *DB) AddCallback(tick, offset time.Duration, cb func(time.Time)) {
-	NewTicker(tick, offset, func(now time.Time) {
+	var t *Ticker
+	t = newTicker(d.Clock, tick, offset, func(now time.Time) {
 		d.Execute(func() {
-			cb(now)
+			// The ticker may have been cancelled while waiting.
+			if !t.Cancelled() {
+				cb(now)
+			}
 		})
 	})
+	d.current.tickers = append(d.current.tickers, t)
 }
 
 // Execute runs a function in the main thread, blocking until
@@ -349,6 +469,12 @@ func (d *DB) Execute(f func()) {
 // AddStatusPrinter adds a callback to return status of a feature
 func (d *DB) AddStatusPrinter(key string, cb func() string) {
 	d.status[key] = cb
+	d.current.status = append(d.current.status, key)
+}
+
+// History returns the history store, or nil if no store is configured.
+func (d *DB) History() *History {
+	return d.history
 }
 
 // Must be called from the main thread
@@ -362,19 +488,53 @@ func (d *DB) GetStatus() map[string]string {
 
 // newDay checks whether it is a new day, and if so,
 // runs the Midnight method on all the elements.
+// If a new month, year or billing period has started, the
+// accumulators are also rolled over.
 func (d *DB) newDay(now time.Time) {
-	today := now.YearDay()
-	if today != d.lastDay {
-		d.lastDay = today
-		for _, el := range d.elements {
-			if acc, ok := el.(Acc); ok {
-				acc.Midnight()
+	// Compare the dates in the same location, in case the last date was restored from the checkpoint.
+	last := d.lastDate.In(now.Location())
+	y, m, day := now.Date()
+	ly, lm, lday := last.Date()
+	if y == ly && m == lm && day == lday {
+		return
+	}
+	d.lastDate = now
+	var periods []Period
+	if now.Month() != last.Month() || now.Year() != last.Year() {
+		periods = append(periods, P_MONTH)
+	}
+	if now.Year() != last.Year() {
+		periods = append(periods, P_YEAR)
+	}
+	if !d.billingStart(now).Equal(d.billingStart(last)) {
+		periods = append(periods, P_BILLING)
+	}
+	for _, el := range d.elements {
+		if acc, ok := el.(Acc); ok {
+			acc.Midnight()
+			for _, p := range periods {
+				acc.Rollover(p)
 			}
 		}
-		if d.Trace {
-			log.Printf("Day reset!")
-		}
 	}
+	if d.Trace {
+		log.Printf("Day reset! (%d periods rolled over)", len(periods))
+	}
+}
+
+// billingStart returns the start date of the billing period containing the time.
+// If the billing day is past the end of a month, the last day of the month is used.
+func (d *DB) billingStart(t time.Time) time.Time {
+	start := func(y int, m time.Month) time.Time {
+		// Day 0 of the following month is the last day of this month.
+		last := time.Date(y, m+1, 0, 0, 0, 0, 0, t.Location()).Day()
+		return time.Date(y, m, min(d.billingDay, last), 0, 0, 0, 0, t.Location())
+	}
+	s := start(t.Year(), t.Month())
+	if t.Before(s) {
+		s = start(t.Year(), t.Month()-1)
+	}
+	return s
 }
 
 // dumpDB Dumps the current state of the database.
@@ -26,17 +26,24 @@ type Diff struct {
 	previousValue float64
 	previousTime  time.Time
 	stale         time.Duration // Duration until stale
+	clock         Clock         // Source of the current time, or nil for the system clock
 }
 
 func NewDiff(cp string, shelfLife time.Duration) *Diff {
 	d := new(Diff)
 	d.stale = shelfLife
+	d.parse(cp)
+	return d
+}
+
+// parse restores the diff from the checkpoint string.
+func (d *Diff) parse(cp string) error {
 	var sec int64
-	fmt.Sscanf(cp, "%f %f %d", &d.value, &d.previousValue, &sec)
+	_, err := fmt.Sscanf(cp, "%f %f %d", &d.value, &d.previousValue, &sec)
 	if sec != 0 {
 		d.previousTime = time.Unix(sec, 0)
 	}
-	return d
+	return err
 }
 
 func (d *Diff) Update(current float64, ts time.Time) {
@@ -64,7 +71,7 @@ func (d *Diff) Timestamp() time.Time {
 }
 
 func (d *Diff) Fresh() bool {
-	return !d.previousTime.Before(time.Now().Add(-d.stale))
+	return !d.previousTime.Before(clockNow(d.clock).Add(-d.stale))
 }
 
 func (d *Diff) Checkpoint() string {
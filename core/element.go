@@ -15,6 +15,8 @@
 package core
 
 import (
+	"fmt"
+	"log"
 	"time"
 )
 
@@ -29,14 +31,30 @@ type Element interface {
 // Acc is a common interface for accumulators.
 type Acc interface {
 	Element
-	Midnight()      // Called when it is midnight for end-of-day processing
-	Daily() float64 // Return the daily total.
+	Midnight()        // Called when it is midnight for end-of-day processing
+	Daily() float64   // Return the daily total.
+	Rollover(Period)  // Called at the start of a new month, year or billing period
+	Monthly() float64 // Return the month-to-date total.
+	Yearly() float64  // Return the year-to-date total.
+	Billing() float64 // Return the total for the current billing period.
 }
 
+// Period selects the month, year or billing period for rollovers.
+type Period int
+
+const (
+	P_MONTH Period = iota
+	P_YEAR
+	P_BILLING
+)
+
 // AddSubGauge adds a sub-gauge to a master gauge.
 // If average is true, values are averaged, otherwise they are summed.
 // The tag of the new gauge is returned.
 func (d *DB) AddSubGauge(base string, average bool) string {
+	if tag, ok := d.reuseSub(base); ok {
+		return tag
+	}
 	el, ok := d.elements[base]
 	if !ok {
 		el = NewMultiElement(base, average)
@@ -44,9 +62,10 @@ func (d *DB) AddSubGauge(base string, average bool) string {
 	}
 	m := el.(*MultiElement)
 	tag := m.NextTag()
-	g := NewGauge(d.checkpoint[tag], d.freshness)
+	g := d.newGauge(tag)
 	m.Add(g)
 	d.elements[tag] = g
+	d.ownSub(base, tag)
 	return tag
 }
 
@@ -54,6 +73,9 @@ func (d *DB) AddSubGauge(base string, average bool) string {
 // If average is true, values are averaged, otherwise they are summed.
 // The tag of the new Diff is returned.
 func (d *DB) AddSubDiff(base string, average bool) string {
+	if tag, ok := d.reuseSub(base); ok {
+		return tag
+	}
 	el, ok := d.elements[base]
 	if !ok {
 		el = NewMultiElement(base, average)
@@ -61,15 +83,19 @@ func (d *DB) AddSubDiff(base string, average bool) string {
 	}
 	m := el.(*MultiElement)
 	tag := m.NextTag()
-	nd := NewDiff(d.checkpoint[tag], d.freshness)
+	nd := d.newDiff(tag)
 	m.Add(nd)
 	d.elements[tag] = nd
+	d.ownSub(base, tag)
 	return tag
 }
 
 // AddSubAccum adds an sub-accumulator to a master accumulator.
 // The tag of the new accumulator is returned.
 func (d *DB) AddSubAccum(base string, resettable bool) string {
+	if tag, ok := d.reuseSub(base); ok {
+		return tag
+	}
 	el, ok := d.elements[base]
 	if !ok {
 		// Create a new base and add it to the database.
@@ -78,25 +104,61 @@ func (d *DB) AddSubAccum(base string, resettable bool) string {
 	}
 	m := el.(*MultiAccum)
 	tag := m.NextTag()
-	a := NewAccum(d.checkpoint[tag], resettable, d.freshness)
+	a := d.newAccum(tag, resettable)
 	m.Add(a)
 	d.elements[tag] = a
+	d.ownSub(base, tag)
 	return tag
 }
 
 // AddGauge adds a new gauge to the database.
+// An existing gauge is kept (e.g when a module is restarted).
 func (d *DB) AddGauge(name string) {
-	d.elements[name] = NewGauge(d.checkpoint[name], d.freshness)
+	if _, ok := d.elements[name].(*Gauge); !ok {
+		d.elements[name] = d.newGauge(name)
+	}
 }
 
 // AddDiff adds a new Diff element to the database.
+// An existing Diff is kept.
 func (d *DB) AddDiff(name string) {
-	d.elements[name] = NewDiff(d.checkpoint[name], d.freshness)
+	if _, ok := d.elements[name].(*Diff); !ok {
+		d.elements[name] = d.newDiff(name)
+	}
 }
 
 // AddAccum adds a new accumulator to the database.
+// An existing accumulator is kept.
 func (d *DB) AddAccum(name string, resettable bool) {
-	d.elements[name] = NewAccum(d.checkpoint[name], resettable, d.freshness)
+	if _, ok := d.elements[name].(*Accum); !ok {
+		d.elements[name] = d.newAccum(name, resettable)
+	}
+}
+
+// newGauge creates a gauge, restored from the checkpoint, using the database clock.
+func (d *DB) newGauge(tag string) *Gauge {
+	g := NewGauge(d.restore(tag, CP_GAUGE), d.freshness)
+	g.clock = d.Clock
+	return g
+}
+
+// newDiff creates a Diff, restored from the checkpoint, using the database clock.
+func (d *DB) newDiff(tag string) *Diff {
+	nd := NewDiff(d.restore(tag, CP_DIFF), d.freshness)
+	nd.clock = d.Clock
+	return nd
+}
+
+// newAccum creates an accumulator, restored from the checkpoint, using the database clock.
+func (d *DB) newAccum(tag string, resettable bool) *Accum {
+	a := NewAccum(d.restore(tag, CP_ACCUM), resettable, d.freshness)
+	a.clock = d.Clock
+	return a
+}
+
+// AddElement adds an element (such as a derived element) to the database.
+func (d *DB) AddElement(name string, e Element) {
+	d.elements[name] = e
 }
 
 // GetElement returns the named element.
@@ -109,6 +171,25 @@ func (d *DB) GetElements() map[string]Element {
 	return d.elements
 }
 
+// Rebase rebases the named accumulator so that its value continues from the current
+// value when the device providing it is replaced. If next is set, the accumulator is
+// rebased using the next value from the device, otherwise v is the current value of the device.
+// Only accumulators (and not the totals of sub-accumulators) can be rebased.
+func (d *DB) Rebase(tag string, v float64, next bool) error {
+	a, ok := d.elements[tag].(*Accum)
+	if !ok {
+		return fmt.Errorf("%s: not an accumulator", tag)
+	}
+	if next {
+		a.RebaseNext()
+		log.Printf("%s: rebasing on next update, current value %g", tag, a.Get())
+	} else {
+		a.Rebase(v)
+		log.Printf("%s: rebased to device value %g, offset %g", tag, v, a.Offset())
+	}
+	return nil
+}
+
 // GetAccum returns the named accumulator.
 func (d *DB) GetAccum(name string) Acc {
 	if el, ok := d.elements[name]; ok {
@@ -0,0 +1,81 @@
+// Copyright 2026 Andrew McRae
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"log"
+	"time"
+)
+
+// Events are reported by features (such as inverter faults), and
+// are passed to the registered event handlers e.g for alerting.
+
+type EventLevel int
+
+const (
+	EV_INFO EventLevel = iota
+	EV_WARNING
+	EV_FAULT
+)
+
+// Number of recent events retained.
+const maxEvents = 100
+
+// Event is a single event reported by a feature.
+type Event struct {
+	Time    time.Time  // Time of event
+	Source  string     // Feature reporting the event e.g SMA-inverter
+	Code    int        // Source specific event code
+	Level   EventLevel // Severity
+	Message string     // Description
+}
+
+func (l EventLevel) String() string {
+	switch l {
+	case EV_INFO:
+		return "info"
+	case EV_WARNING:
+		return "warning"
+	case EV_FAULT:
+		return "fault"
+	}
+	return "unknown"
+}
+
+// AddEventHandler adds a handler that is called for each event.
+// The handlers are called in the main thread.
+func (d *DB) AddEventHandler(f func(Event)) {
+	d.eventHandlers = append(d.eventHandlers, hook[func(Event)]{d.current, f})
+}
+
+// PostEvent reports an event. This must not be called from the main thread.
+func (d *DB) PostEvent(e Event) {
+	log.Printf("Event: %s: %s: code %d: %s", e.Source, e.Level, e.Code, e.Message)
+	d.Execute(func() {
+		d.events = append(d.events, e)
+		if len(d.events) > maxEvents {
+			d.events = d.events[len(d.events)-maxEvents:]
+		}
+		for _, h := range d.eventHandlers {
+			h.f(e)
+		}
+	})
+}
+
+// GetEvents returns the most recent events, oldest first.
+// This must be called from the main thread.
+func (d *DB) GetEvents() []Event {
+	return d.events
+}
@@ -0,0 +1,468 @@
+// Copyright 2026 Andrew McRae
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Expression elements are computed from other elements, and are declared in the db config as:
+//
+//	computed:
+//	  <tag>: <expression>
+//
+// The expression may contain numbers, tags, the operators + - * / and parentheses,
+// and the functions:
+//
+//	min(a, b, ...), max(a, b, ...)  Minimum or maximum value
+//	clamp(x, lo, hi)                x limited to the range lo to hi
+//	abs(x)                          Absolute value
+//	if(c, a, b)                     a if c is non-zero, otherwise b
+//	fresh(tag)                      1 if the element is fresh, otherwise 0
+//	default(tag, x)                 The element value if it is fresh, otherwise x
+//
+// Tags may contain '-' and '/' (e.g GEN-P or IMP/0), so operators adjacent to tags must
+// be separated by spaces e.g "GEN-P + BATT-P - OUT-P".
+// The expression is evaluated when the value is requested. The element is fresh if all
+// the tags used are fresh, except those only used in fresh() or the first argument of default().
+
+// Expr is an element whose value is computed from an expression.
+type Expr struct {
+	d      *DB
+	src    string   // Expression source
+	root   node     // Parsed expression
+	inputs []string // Tags that must be fresh
+	tags   []string // All tags referenced
+}
+
+// node is a parsed expression node.
+type node interface {
+	eval(d *DB) float64
+}
+
+type numNode float64
+
+type tagNode string
+
+type negNode struct {
+	x node
+}
+
+type binNode struct {
+	op   byte
+	l, r node
+}
+
+type callNode struct {
+	fn   string
+	args []node
+}
+
+// Number of arguments for each function (-1 is 1 or more).
+var exprFuncs = map[string]int{
+	"min":     -1,
+	"max":     -1,
+	"clamp":   3,
+	"abs":     1,
+	"if":      3,
+	"fresh":   1,
+	"default": 2,
+}
+
+// NewExpr parses the expression.
+func NewExpr(d *DB, src string) (*Expr, error) {
+	toks, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks, required: make(map[string]bool)}
+	root, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.toks) {
+		return nil, fmt.Errorf("unexpected '%s'", p.toks[p.pos])
+	}
+	e := &Expr{d: d, src: src, root: root}
+	for _, t := range p.order {
+		e.tags = append(e.tags, t)
+		if p.required[t] {
+			e.inputs = append(e.inputs, t)
+		}
+	}
+	return e, nil
+}
+
+// String returns the expression source.
+func (e *Expr) String() string {
+	return e.src
+}
+
+// Tags returns the tags referenced by the expression.
+func (e *Expr) Tags() []string {
+	return e.tags
+}
+
+func (e *Expr) Update(v float64, ts time.Time) {
+	// No one should be updating an expression.
+	log.Printf("%s: update of expression ignored", e.src)
+}
+
+func (e *Expr) Get() float64 {
+	return e.root.eval(e.d)
+}
+
+// Return the oldest timestamp of the required tags.
+func (e *Expr) Timestamp() time.Time {
+	var timestamp time.Time
+	for _, t := range e.inputs {
+		if el := e.d.elements[t]; el != nil {
+			ts := el.Timestamp()
+			if timestamp.IsZero() || timestamp.After(ts) {
+				timestamp = ts
+			}
+		}
+	}
+	return timestamp
+}
+
+// Return true only if all the required tags are fresh.
+func (e *Expr) Fresh() bool {
+	for _, t := range e.inputs {
+		if el := e.d.elements[t]; el == nil || !el.Fresh() {
+			return false
+		}
+	}
+	return true
+}
+
+func (n numNode) eval(d *DB) float64 {
+	return float64(n)
+}
+
+func (n tagNode) eval(d *DB) float64 {
+	if el := d.elements[string(n)]; el != nil {
+		return el.Get()
+	}
+	return math.NaN()
+}
+
+func (n *negNode) eval(d *DB) float64 {
+	return -n.x.eval(d)
+}
+
+func (n *binNode) eval(d *DB) float64 {
+	l, r := n.l.eval(d), n.r.eval(d)
+	switch n.op {
+	case '+':
+		return l + r
+	case '-':
+		return l - r
+	case '*':
+		return l * r
+	default:
+		if r == 0 {
+			return 0
+		}
+		return l / r
+	}
+}
+
+func (n *callNode) eval(d *DB) float64 {
+	switch n.fn {
+	case "min", "max":
+		v := n.args[0].eval(d)
+		for _, a := range n.args[1:] {
+			if n.fn == "min" {
+				v = min(v, a.eval(d))
+			} else {
+				v = max(v, a.eval(d))
+			}
+		}
+		return v
+	case "clamp":
+		return min(max(n.args[0].eval(d), n.args[1].eval(d)), n.args[2].eval(d))
+	case "abs":
+		return math.Abs(n.args[0].eval(d))
+	case "if":
+		if n.args[0].eval(d) != 0 {
+			return n.args[1].eval(d)
+		}
+		return n.args[2].eval(d)
+	case "fresh":
+		if el := d.elements[string(n.args[0].(tagNode))]; el != nil && el.Fresh() {
+			return 1
+		}
+		return 0
+	default: // "default"
+		if el := d.elements[string(n.args[0].(tagNode))]; el != nil && el.Fresh() {
+			return el.Get()
+		}
+		return n.args[1].eval(d)
+	}
+}
+
+// lex splits the expression into tokens.
+func lex(s string) ([]string, error) {
+	var toks []string
+	r := []rune(s)
+	for i := 0; i < len(r); {
+		c := r[i]
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case strings.ContainsRune("+-*/(),", c):
+			toks = append(toks, string(c))
+			i++
+		case unicode.IsDigit(c) || c == '.':
+			j := i
+			for j < len(r) && (unicode.IsDigit(r[j]) || r[j] == '.') {
+				j++
+			}
+			toks = append(toks, string(r[i:j]))
+			i = j
+		case unicode.IsLetter(c):
+			// '-' and '/' are part of the tag if followed by a letter or digit.
+			j := i
+			for j < len(r) {
+				if isTagRune(r[j]) {
+					j++
+				} else if (r[j] == '-' || r[j] == '/') && j+1 < len(r) && isTagRune(r[j+1]) {
+					j += 2
+				} else {
+					break
+				}
+			}
+			toks = append(toks, string(r[i:j]))
+			i = j
+		default:
+			return nil, fmt.Errorf("unexpected character '%c'", c)
+		}
+	}
+	return toks, nil
+}
+
+func isTagRune(c rune) bool {
+	return unicode.IsLetter(c) || unicode.IsDigit(c) || c == '_'
+}
+
+// parser is a recursive descent parser of the expression tokens.
+type parser struct {
+	toks     []string
+	pos      int
+	order    []string        // Tags in order of reference
+	required map[string]bool // Whether the tag must be fresh
+}
+
+func (p *parser) peek() string {
+	if p.pos < len(p.toks) {
+		return p.toks[p.pos]
+	}
+	return ""
+}
+
+func (p *parser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *parser) expect(t string) error {
+	if n := p.next(); n != t {
+		if len(n) == 0 {
+			return fmt.Errorf("expected '%s' at end of expression", t)
+		}
+		return fmt.Errorf("expected '%s', found '%s'", t, n)
+	}
+	return nil
+}
+
+// addTag records a reference to a tag.
+func (p *parser) addTag(t string, required bool) {
+	r, ok := p.required[t]
+	if !ok {
+		p.order = append(p.order, t)
+	}
+	p.required[t] = r || required
+}
+
+// expr := term { ('+' | '-') term }
+func (p *parser) expr() (node, error) {
+	l, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "+" || p.peek() == "-" {
+		op := p.next()[0]
+		r, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		l = &binNode{op: op, l: l, r: r}
+	}
+	return l, nil
+}
+
+// term := unary { ('*' | '/') unary }
+func (p *parser) term() (node, error) {
+	l, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "*" || p.peek() == "/" {
+		op := p.next()[0]
+		r, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		l = &binNode{op: op, l: l, r: r}
+	}
+	return l, nil
+}
+
+// unary := '-' unary | primary
+func (p *parser) unary() (node, error) {
+	if p.peek() == "-" {
+		p.next()
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &negNode{x: x}, nil
+	}
+	return p.primary()
+}
+
+// primary := number | tag | function '(' args ')' | '(' expr ')'
+func (p *parser) primary() (node, error) {
+	t := p.next()
+	switch {
+	case len(t) == 0:
+		return nil, fmt.Errorf("unexpected end of expression")
+	case t == "(":
+		x, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		return x, p.expect(")")
+	case unicode.IsDigit(rune(t[0])) || t[0] == '.':
+		v, err := strconv.ParseFloat(t, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number '%s'", t)
+		}
+		return numNode(v), nil
+	case unicode.IsLetter(rune(t[0])):
+		if n, ok := exprFuncs[t]; ok && p.peek() == "(" {
+			return p.call(t, n)
+		}
+		p.addTag(t, true)
+		return tagNode(t), nil
+	}
+	return nil, fmt.Errorf("unexpected '%s'", t)
+}
+
+// call parses the arguments of a function.
+func (p *parser) call(fn string, nargs int) (node, error) {
+	p.next() // '('
+	c := &callNode{fn: fn}
+	for {
+		var a node
+		var err error
+		if (fn == "fresh" || fn == "default") && len(c.args) == 0 {
+			// The first argument must be a tag, which is not required to be fresh.
+			t := p.next()
+			if len(t) == 0 || !unicode.IsLetter(rune(t[0])) {
+				return nil, fmt.Errorf("%s: argument must be a tag", fn)
+			}
+			p.addTag(t, false)
+			a = tagNode(t)
+		} else if a, err = p.expr(); err != nil {
+			return nil, err
+		}
+		c.args = append(c.args, a)
+		if p.peek() != "," {
+			break
+		}
+		p.next()
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	if (nargs < 0 && len(c.args) == 0) || (nargs > 0 && len(c.args) != nargs) {
+		return nil, fmt.Errorf("%s: wrong number of arguments (%d)", fn, len(c.args))
+	}
+	return c, nil
+}
+
+// addExpressions adds the configured expression elements.
+// The tags used must exist, and expressions may not refer to themselves.
+func (d *DB) addExpressions(m map[string]string) error {
+	exprs := make(map[string]*Expr)
+	for tag, src := range m {
+		if _, ok := d.elements[tag]; ok {
+			return fmt.Errorf("computed %s: tag already exists", tag)
+		}
+		e, err := NewExpr(d, src)
+		if err != nil {
+			return fmt.Errorf("computed %s: %s: %v", tag, src, err)
+		}
+		exprs[tag] = e
+	}
+	for tag, e := range exprs {
+		for _, t := range e.tags {
+			if _, ok := d.elements[t]; !ok && exprs[t] == nil {
+				return fmt.Errorf("computed %s: unknown tag %s", tag, t)
+			}
+		}
+	}
+	// Check for cycles.
+	state := make(map[string]int) // 1 = visiting, 2 = done
+	var visit func(tag string) error
+	visit = func(tag string) error {
+		e := exprs[tag]
+		if e == nil || state[tag] == 2 {
+			return nil
+		}
+		if state[tag] == 1 {
+			return fmt.Errorf("computed %s: expression refers to itself", tag)
+		}
+		state[tag] = 1
+		for _, t := range e.tags {
+			if err := visit(t); err != nil {
+				return err
+			}
+		}
+		state[tag] = 2
+		return nil
+	}
+	for tag := range exprs {
+		if err := visit(tag); err != nil {
+			return err
+		}
+	}
+	for tag, e := range exprs {
+		d.elements[tag] = e
+		if d.Trace {
+			log.Printf("Computed %s = %s", tag, e.src)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+// Copyright 2026 Andrew McRae
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"time"
+
+	"testing"
+)
+
+func TestExpr(t *testing.T) {
+	d := NewDatabase(nil)
+	d.AddGauge(G_IN_POWER)
+	d.AddGauge(G_OUT_POWER)
+	d.AddGauge(G_BATT_POWER)
+	imp := d.AddSubAccum(A_IMPORT, false)
+	now := time.Now()
+	d.GetElement(G_IN_POWER).Update(3, now)
+	d.GetElement(G_OUT_POWER).Update(1, now)
+	d.GetElement(imp).Update(100, now)
+	err := d.addExpressions(map[string]string{
+		"NET":    "IN-P - OUT-P",
+		"PREC":   "2 + 3 * -IN-P / (4 - 1)",
+		"MIN":    "min(IN-P, OUT-P, 2)",
+		"MAX":    "max(IN-P, OUT-P, 2)",
+		"CLAMP":  "clamp(NET * 10, 0, 5)",
+		"ABS":    "abs(OUT-P - IN-P)",
+		"IF":     "if(fresh(BATT-P), 1, 2)",
+		"DEF":    "default(BATT-P, 7) + IMP/0",
+		"STALE":  "BATT-P + IN-P",
+		"DIVIDE": "IN-P / 0",
+	})
+	if err != nil {
+		t.Fatalf("addExpressions: %v", err)
+	}
+	for _, v := range []struct {
+		tag   string
+		want  float64
+		fresh bool
+	}{
+		{"NET", 2, true},
+		{"PREC", -1, true},
+		{"MIN", 1, true},
+		{"MAX", 3, true},
+		{"CLAMP", 5, true},
+		{"ABS", 2, true},
+		{"IF", 2, true},
+		{"DEF", 107, true},
+		{"STALE", 3, false},
+		{"DIVIDE", 0, true},
+	} {
+		e := d.GetElement(v.tag)
+		if got := e.Get(); got != v.want {
+			t.Errorf("%s: got %g, want %g", v.tag, got, v.want)
+		}
+		if e.Fresh() != v.fresh {
+			t.Errorf("%s: fresh %v, want %v", v.tag, e.Fresh(), v.fresh)
+		}
+	}
+	// Values are evaluated when requested.
+	d.GetElement(G_BATT_POWER).Update(4, now)
+	if got := d.GetElement("DEF").Get(); got != 104 {
+		t.Errorf("DEF: got %g, want 104", got)
+	}
+	if !d.GetElement("STALE").Fresh() {
+		t.Errorf("STALE: not fresh after update")
+	}
+	// Updating an expression is ignored.
+	d.GetElement("NET").Update(10, now)
+	if got := d.GetElement("NET").Get(); got != 2 {
+		t.Errorf("NET update: got %g, want 2", got)
+	}
+}
+
+func TestExprErrors(t *testing.T) {
+	d := NewDatabase(nil)
+	d.AddGauge(G_IN_POWER)
+	for _, v := range []map[string]string{
+		{"A": "IN-P +"},
+		{"A": "(IN-P"},
+		{"A": "IN-P )"},
+		{"A": "clamp(IN-P, 1)"},
+		{"A": "fresh(1)"},
+		{"A": "IN-P $ 2"},
+		{"A": "UNKNOWN"},
+		{G_IN_POWER: "1"},
+		{"A": "B + 1", "B": "A * 2"},
+	} {
+		if err := d.addExpressions(v); err == nil {
+			t.Errorf("%v: expected error", v)
+		}
+	}
+	if _, ok := d.elements["A"]; ok {
+		t.Errorf("element added after error")
+	}
+}
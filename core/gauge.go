@@ -25,17 +25,24 @@ type Gauge struct {
 	value float64
 	ts    time.Time
 	stale time.Duration // Duration until stale
+	clock Clock         // Source of the current time, or nil for the system clock
 }
 
 func NewGauge(cp string, shelfLife time.Duration) *Gauge {
 	g := new(Gauge)
 	g.stale = shelfLife
+	g.parse(cp)
+	return g
+}
+
+// parse restores the gauge from the checkpoint string.
+func (g *Gauge) parse(cp string) error {
 	var sec int64
-	fmt.Sscanf(cp, "%f %d", &g.value, &sec)
+	_, err := fmt.Sscanf(cp, "%f %d", &g.value, &sec)
 	if sec != 0 {
 		g.ts = time.Unix(sec, 0)
 	}
-	return g
+	return err
 }
 
 func (g *Gauge) Update(value float64, ts time.Time) {
@@ -52,7 +59,7 @@ func (g *Gauge) Timestamp() time.Time {
 }
 
 func (g *Gauge) Fresh() bool {
-	return !g.Timestamp().Before(time.Now().Add(-g.stale))
+	return !g.Timestamp().Before(clockNow(g.clock).Add(-g.stale))
 }
 
 func (g *Gauge) Checkpoint() string {
@@ -0,0 +1,428 @@
+// Copyright 2026 Andrew McRae
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path"
+	"sync"
+	"time"
+)
+
+// History is an append-only store of element values over time.
+// Each day is held in a separate segment file under the base directory,
+// in the form <base>/YYYY/MM/YYYY-MM-DD.hist
+//
+// A segment is a sequence of records, each starting with a type byte:
+//
+//	'T' - tag definition: uint16 index, uint8 length, tag name
+//	'S' - sample: int64 time (unix seconds), uint16 count, followed by
+//	      count entries of: uint16 tag index, uint8 flags, float64 value, float64 daily
+//
+// Tag definitions are local to a segment, and are written before the first
+// sample that refers to them, so each segment can be read independently.
+// All values are little endian.
+//
+// History is safe for concurrent use.
+type History struct {
+	base string
+
+	mu   sync.Mutex
+	seg  *segment       // Current segment being written
+	recs chan histEntry // Pending samples to be written
+}
+
+// Sample is a single historical value of an element.
+type Sample struct {
+	Time  time.Time
+	Value float64
+	Daily float64 // Daily value, if an accumulator
+	Accum bool    // Element is an accumulator
+	Fresh bool    // Element was fresh when recorded
+}
+
+const (
+	recTag    = 'T'
+	recSample = 'S'
+
+	flagFresh = 0x01
+	flagAccum = 0x02
+)
+
+// histValue is a single tag value within a sample.
+type histValue struct {
+	tag   string
+	flags byte
+	value float64
+	daily float64
+}
+
+// histEntry is the set of values recorded at a single time.
+type histEntry struct {
+	ts     time.Time
+	values []histValue
+}
+
+// segment is a single day of history being appended to.
+type segment struct {
+	name string
+	day  time.Time
+	f    *os.File
+	wr   *bufio.Writer
+	tags map[string]uint16
+}
+
+// NewHistory creates a history store using the base directory.
+func NewHistory(base string) *History {
+	h := &History{base: base, recs: make(chan histEntry, 10)}
+	go h.writer()
+	return h
+}
+
+// recordHistory takes a snapshot of the database elements and
+// queues it to be written to the history store.
+// If the writer has fallen behind (e.g the disk is slow), the
+// snapshot is dropped rather than blocking the main thread.
+func (d *DB) recordHistory(now time.Time) {
+	e := histEntry{ts: now}
+	for tag, el := range d.elements {
+		v := histValue{tag: tag, value: el.Get()}
+		if el.Fresh() {
+			v.flags |= flagFresh
+		}
+		if a, ok := el.(Acc); ok {
+			v.flags |= flagAccum
+			v.daily = a.Daily()
+		}
+		e.values = append(e.values, v)
+	}
+	select {
+	case d.history.recs <- e:
+	default:
+		log.Printf("History: writer busy, sample at %s dropped", now.Format("2006-01-02 15:04:05"))
+	}
+}
+
+// writer runs as a separate goroutine, appending history entries
+// so that file I/O is not performed on the main thread.
+func (h *History) writer() {
+	for e := range h.recs {
+		if err := h.append(e); err != nil {
+			log.Printf("History: %v", err)
+		}
+	}
+}
+
+// append writes a single entry to the segment for the entry's day.
+func (h *History) append(e histEntry) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	day := startOfDay(e.ts)
+	if h.seg == nil || !h.seg.day.Equal(day) {
+		if h.seg != nil {
+			h.seg.close()
+			h.seg = nil
+		}
+		s, err := openSegment(h.segmentName(day), day)
+		if err != nil {
+			return err
+		}
+		h.seg = s
+	}
+	return h.seg.write(e)
+}
+
+// Query returns the samples of a tag that were recorded in the time range [from, to).
+// If step is 0, all samples are returned, otherwise the samples are
+// grouped into intervals of step duration, each interval being represented
+// by a single sample timestamped at the start of the interval.
+// Intervals are aligned to the step from the start of the local day
+// (or to multiples of the step for steps of a day or longer), so that
+// they do not depend on the start of the range.
+// Gauge values are averaged over the interval, and accumulators
+// use the last value in the interval. Only fresh samples are used
+// for the intervals, and intervals without any fresh samples are skipped.
+func (h *History) Query(tag string, from, to time.Time, step time.Duration) ([]Sample, error) {
+	var raw []Sample
+	for day := startOfDay(from); day.Before(to); day = nextDay(day) {
+		err := h.scan(day, func(s *Sample, t string) {
+			if t == tag && !s.Time.Before(from) && s.Time.Before(to) {
+				raw = append(raw, *s)
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if step <= 0 {
+		return raw, nil
+	}
+	var res []Sample
+	var count int
+	for _, s := range raw {
+		if !s.Fresh {
+			continue
+		}
+		bucket := interval(s.Time, step)
+		if len(res) == 0 || !res[len(res)-1].Time.Equal(bucket) {
+			if count > 1 {
+				res[len(res)-1].Value /= float64(count)
+			}
+			res = append(res, Sample{Time: bucket, Accum: s.Accum, Fresh: true})
+			count = 0
+		}
+		last := &res[len(res)-1]
+		if s.Accum {
+			last.Value = s.Value
+			last.Daily = s.Daily
+		} else {
+			last.Value += s.Value
+			count++
+		}
+	}
+	if count > 1 {
+		res[len(res)-1].Value /= float64(count)
+	}
+	return res, nil
+}
+
+// interval returns the start of the interval of step duration containing t.
+func interval(t time.Time, step time.Duration) time.Time {
+	if step >= time.Hour*24 {
+		return t.Truncate(step)
+	}
+	day := startOfDay(t)
+	return day.Add(t.Sub(day).Truncate(step))
+}
+
+// scan reads the segment for the selected day, calling the function for each tag value.
+// A missing segment is not an error.
+func (h *History) scan(day time.Time, f func(*Sample, string)) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	name := h.segmentName(day)
+	// Ensure any buffered data in the current segment is visible.
+	if h.seg != nil && h.seg.name == name {
+		if err := h.seg.wr.Flush(); err != nil {
+			return err
+		}
+	}
+	fl, err := os.Open(name)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	defer fl.Close()
+	_, err = readSegment(bufio.NewReader(fl), f)
+	if err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	return nil
+}
+
+// segmentName returns the file name of the segment for the day.
+func (h *History) segmentName(day time.Time) string {
+	return path.Join(h.base, day.Format("2006"), day.Format("01"), day.Format("2006-01-02")+".hist")
+}
+
+// openSegment opens a segment for appending, creating it if necessary.
+// If the segment already exists, the tag definitions are read so that
+// the existing indices are reused. Any partial record at the end of the
+// segment (e.g from a crash mid-write) is truncated.
+func openSegment(name string, day time.Time) (*segment, error) {
+	if err := os.MkdirAll(path.Dir(name), 0775); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_RDWR, 0664)
+	if err != nil {
+		return nil, err
+	}
+	s := &segment{name: name, day: day, f: f, tags: make(map[string]uint16)}
+	valid, err := readSegmentTags(bufio.NewReader(f), s.tags, nil)
+	if err != nil {
+		log.Printf("History: %s: %v, truncating to %d bytes", name, err, valid)
+		if err := f.Truncate(valid); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	if _, err := f.Seek(valid, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	s.wr = bufio.NewWriter(f)
+	return s, nil
+}
+
+// write appends a sample record, adding any new tag definitions.
+func (s *segment) write(e histEntry) error {
+	for _, v := range e.values {
+		if _, ok := s.tags[v.tag]; ok {
+			continue
+		}
+		if len(s.tags) >= math.MaxUint16 || len(v.tag) > math.MaxUint8 {
+			return fmt.Errorf("%s: cannot add tag %s", s.name, v.tag)
+		}
+		index := uint16(len(s.tags))
+		s.tags[v.tag] = index
+		s.wr.WriteByte(recTag)
+		binary.Write(s.wr, binary.LittleEndian, index)
+		s.wr.WriteByte(byte(len(v.tag)))
+		s.wr.WriteString(v.tag)
+	}
+	s.wr.WriteByte(recSample)
+	binary.Write(s.wr, binary.LittleEndian, e.ts.Unix())
+	binary.Write(s.wr, binary.LittleEndian, uint16(len(e.values)))
+	for _, v := range e.values {
+		binary.Write(s.wr, binary.LittleEndian, s.tags[v.tag])
+		s.wr.WriteByte(v.flags)
+		binary.Write(s.wr, binary.LittleEndian, v.value)
+		binary.Write(s.wr, binary.LittleEndian, v.daily)
+	}
+	return s.wr.Flush()
+}
+
+func (s *segment) close() {
+	s.wr.Flush()
+	s.f.Close()
+}
+
+// readSegment reads a segment, calling f (if not nil) for each tag value.
+// The length of the valid data read is returned, along with any error.
+func readSegment(r *bufio.Reader, f func(*Sample, string)) (int64, error) {
+	return readSegmentTags(r, make(map[string]uint16), f)
+}
+
+// readSegmentTags reads a segment, adding the tag definitions to the map.
+func readSegmentTags(r *bufio.Reader, tags map[string]uint16, f func(*Sample, string)) (int64, error) {
+	var valid int64
+	names := make(map[uint16]string)
+	for k, v := range tags {
+		names[v] = k
+	}
+	for {
+		rt, err := r.ReadByte()
+		if err == io.EOF {
+			return valid, nil
+		} else if err != nil {
+			return valid, err
+		}
+		switch rt {
+		case recTag:
+			var index uint16
+			var l uint8
+			if err := binary.Read(r, binary.LittleEndian, &index); err != nil {
+				return valid, err
+			}
+			if err := binary.Read(r, binary.LittleEndian, &l); err != nil {
+				return valid, err
+			}
+			b := make([]byte, l)
+			if _, err := io.ReadFull(r, b); err != nil {
+				return valid, err
+			}
+			names[index] = string(b)
+			tags[string(b)] = index
+			valid += 4 + int64(l)
+		case recSample:
+			var sec int64
+			var count uint16
+			if err := binary.Read(r, binary.LittleEndian, &sec); err != nil {
+				return valid, err
+			}
+			if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+				return valid, err
+			}
+			const entrySize = 2 + 1 + 8 + 8
+			b := make([]byte, int(count)*entrySize)
+			if _, err := io.ReadFull(r, b); err != nil {
+				return valid, err
+			}
+			valid += 1 + 8 + 2 + int64(len(b))
+			if f == nil {
+				continue
+			}
+			ts := time.Unix(sec, 0)
+			for i := 0; i < len(b); i += entrySize {
+				name, ok := names[binary.LittleEndian.Uint16(b[i:])]
+				if !ok {
+					return valid, fmt.Errorf("undefined tag index %d", binary.LittleEndian.Uint16(b[i:]))
+				}
+				flags := b[i+2]
+				s := &Sample{
+					Time:  ts,
+					Value: math.Float64frombits(binary.LittleEndian.Uint64(b[i+3:])),
+					Daily: math.Float64frombits(binary.LittleEndian.Uint64(b[i+11:])),
+					Accum: flags&flagAccum != 0,
+					Fresh: flags&flagFresh != 0,
+				}
+				f(s, name)
+			}
+		default:
+			return valid, fmt.Errorf("unknown record type 0x%02x at offset %d", rt, valid)
+		}
+	}
+}
+
+// startOfDay returns midnight (local time) of the day of t.
+// The segments are stored by local date, so t is converted to local time
+// (e.g a query time may have been specified with a different offset).
+func startOfDay(t time.Time) time.Time {
+	y, m, d := t.In(time.Local).Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.Local)
+}
+
+// nextDay returns the start of the following day.
+func nextDay(day time.Time) time.Time {
+	y, m, d := day.Date()
+	return time.Date(y, m, d+1, 0, 0, 0, 0, day.Location())
+}
+
+// DayTotal holds the daily totals of the accumulators for a single day.
+type DayTotal struct {
+	Day    time.Time          // Start of the day
+	Totals map[string]float64 // Map of accumulator tags to daily totals
+}
+
+// DailyTotals returns the final daily totals of every accumulator for
+// each day in the range [from, to). The last fresh daily value recorded for
+// each accumulator is used. Days without any history are skipped.
+func (h *History) DailyTotals(from, to time.Time) ([]DayTotal, error) {
+	var res []DayTotal
+	for day := startOfDay(from); day.Before(to); day = nextDay(day) {
+		dt := DayTotal{Day: day, Totals: make(map[string]float64)}
+		err := h.scan(day, func(s *Sample, t string) {
+			if s.Accum && s.Fresh {
+				dt.Totals[t] = s.Daily
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(dt.Totals) != 0 {
+			res = append(res, dt)
+		}
+	}
+	return res, nil
+}
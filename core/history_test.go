@@ -0,0 +1,163 @@
+// Copyright 2026 Andrew McRae
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"os"
+	"time"
+
+	"testing"
+)
+
+func histEntryAt(ts time.Time, g, a, daily float64) histEntry {
+	return histEntry{ts: ts, values: []histValue{
+		{tag: "G", flags: flagFresh, value: g},
+		{tag: "A", flags: flagFresh | flagAccum, value: a, daily: daily},
+	}}
+}
+
+func TestHistory(t *testing.T) {
+	dir := t.TempDir()
+	h := NewHistory(dir)
+	start := time.Date(2026, 3, 14, 10, 0, 0, 0, time.Local)
+	for i := range 3 {
+		err := h.append(histEntryAt(start.Add(time.Minute*time.Duration(i)), float64(i+1), 100+float64(i), float64(i)))
+		if err != nil {
+			t.Fatalf("append: %v", err)
+		}
+	}
+	end := start.Add(time.Hour)
+	s, err := h.Query("G", start, end, 0)
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if len(s) != 3 {
+		t.Fatalf("Query: got %d samples want %d", len(s), 3)
+	}
+	if !cmp(s[2].Value, 3) || s[2].Time != start.Add(time.Minute*2) || s[2].Accum {
+		t.Errorf("Query: got %v", s[2])
+	}
+	// Gauges are averaged over the interval.
+	s, err = h.Query("G", start, end, time.Minute*5)
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if len(s) != 1 || !cmp(s[0].Value, 2) || s[0].Time != start {
+		t.Errorf("Query step: got %v want value %v", s, 2.0)
+	}
+	// Intervals are aligned to the step, not the start of the range.
+	s, err = h.Query("G", start.Add(time.Minute), end, time.Minute*5)
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if len(s) != 1 || !cmp(s[0].Value, 2.5) || s[0].Time != start {
+		t.Errorf("Query aligned: got %v want value %v at %s", s, 2.5, start)
+	}
+	// Accumulators use the last value.
+	s, err = h.Query("A", start, end, time.Minute*5)
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if len(s) != 1 || !cmp(s[0].Value, 102) || !cmp(s[0].Daily, 2) || !s[0].Accum {
+		t.Errorf("Query step: got %v want value %v", s, 102.0)
+	}
+	h.seg.close()
+	// Simulate a partial write, and reopen the store.
+	f, err := os.OpenFile(h.segmentName(start), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	f.Write([]byte{recSample, 1, 2, 3})
+	f.Close()
+	h = NewHistory(dir)
+	if err := h.append(histEntryAt(start.Add(time.Minute*3), 4, 103, 3)); err != nil {
+		t.Fatalf("append: %v", err)
+	}
+	s, err = h.Query("A", start, end, 0)
+	if err != nil {
+		t.Fatalf("Query after reopen: %v", err)
+	}
+	if len(s) != 4 || !cmp(s[3].Value, 103) {
+		t.Errorf("Query after reopen: got %v", s)
+	}
+	// Range excludes other samples.
+	s, err = h.Query("G", start.Add(time.Minute), start.Add(time.Minute*3), 0)
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if len(s) != 2 {
+		t.Errorf("Query range: got %d samples want %d", len(s), 2)
+	}
+	// Daily totals use the last daily value.
+	dt, err := h.DailyTotals(start, end)
+	if err != nil {
+		t.Fatalf("DailyTotals: %v", err)
+	}
+	if len(dt) != 1 || len(dt[0].Totals) != 1 || !cmp(dt[0].Totals["A"], 3) {
+		t.Errorf("DailyTotals: got %v want A = %v", dt, 3.0)
+	}
+}
+
+// TestHistoryQueryOffset checks that a query using a time with a different
+// offset to the local time reads the segments of the local days.
+func TestHistoryQueryOffset(t *testing.T) {
+	saved := time.Local
+	defer func() { time.Local = saved }()
+	time.Local = time.FixedZone("AEST", 10*3600)
+	h := NewHistory(t.TempDir())
+	// 8am local time is 10pm UTC on the previous day.
+	start := time.Date(2026, 3, 14, 8, 0, 0, 0, time.Local)
+	for i := range 3 {
+		if err := h.append(histEntryAt(start.Add(time.Minute*time.Duration(i)), float64(i+1), 100+float64(i), float64(i))); err != nil {
+			t.Fatalf("append: %v", err)
+		}
+	}
+	from, err := time.Parse(time.RFC3339, "2026-03-13T21:00:00+00:00")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	to := from.Add(time.Hour * 2)
+	s, err := h.Query("G", from, to, 0)
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if len(s) != 3 {
+		t.Errorf("Query: got %d samples want %d", len(s), 3)
+	}
+	dt, err := h.DailyTotals(from, to)
+	if err != nil {
+		t.Fatalf("DailyTotals: %v", err)
+	}
+	if len(dt) != 1 || !dt[0].Day.Equal(time.Date(2026, 3, 14, 0, 0, 0, 0, time.Local)) || !cmp(dt[0].Totals["A"], 2) {
+		t.Errorf("DailyTotals: got %v want 2026-03-14 A = %v", dt, 2.0)
+	}
+}
+
+// TestHistoryDrop checks that samples are dropped if the writer is busy.
+func TestHistoryDrop(t *testing.T) {
+	d := NewDatabase(nil)
+	d.AddGauge("G")
+	// A history without a writer.
+	d.history = &History{recs: make(chan histEntry, 1)}
+	now := time.Now()
+	d.recordHistory(now)
+	d.recordHistory(now.Add(time.Minute))
+	if len(d.history.recs) != 1 {
+		t.Fatalf("recordHistory: got %d queued want 1", len(d.history.recs))
+	}
+	if e := <-d.history.recs; !e.ts.Equal(now) || len(e.values) != 1 {
+		t.Errorf("recordHistory: got %v", e)
+	}
+}
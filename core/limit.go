@@ -0,0 +1,34 @@
+// Copyright 2026 Andrew McRae
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+// PowerLimiter is implemented by features that can limit
+// the power generated e.g to curtail export to the grid.
+type PowerLimiter interface {
+	SetPowerLimit(watts float64) error          // Set limit in watts
+	SetPowerLimitPercent(percent float64) error // Set limit as percentage of nominal power
+}
+
+// AddPowerLimiter registers a power limiter.
+func (d *DB) AddPowerLimiter(name string, l PowerLimiter) {
+	d.limiters[name] = l
+	d.current.limiters = append(d.current.limiters, name)
+}
+
+// GetPowerLimiters returns the map of registered power limiters.
+// Must be called from the main thread.
+func (d *DB) GetPowerLimiters() map[string]PowerLimiter {
+	return d.limiters
+}
@@ -0,0 +1,446 @@
+// Copyright 2026 Andrew McRae
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Features are initialised by the init hooks.
+// A feature registered with the name of its config section (via RegisterModule)
+// may be restarted when the configuration is reloaded (on SIGHUP). The tickers,
+// pollers, exports, handlers, modules and status printers added by the feature while it is
+// being initialised are owned by the feature, and are removed when the feature is stopped.
+// The elements are kept, so that the values and accumulators are preserved
+// across a restart; sub-elements are reallocated to the feature in the same order.
+//
+// Each feature has a context that is passed to its pollers and exporters, and
+// to the modules it adds. When a feature is stopped, the modules are stopped
+// (allowing in-flight requests to complete) and then the context is cancelled.
+//
+// Features registered via RegisterInit are not restarted when the configuration
+// is reloaded, so a change to their configuration requires MeterMan to be restarted.
+
+// Module is implemented by the parts of a feature that run goroutines or hold
+// resources (such as connections or files) that must be released when the feature is stopped.
+// Stop is called from the main thread, so it must not wait for anything that requires the main thread.
+type Module interface {
+	Start(ctx context.Context) error // Start the module. ctx is cancelled after the module is stopped
+	Stop(ctx context.Context) error  // Stop the module, completing in-flight requests unless ctx is done
+	Status() string                  // Return the current status
+}
+
+// Time allowed for modules to stop.
+const stopTimeout = time.Second * 10
+
+// feature is a registered init hook, and the resources it owns.
+type feature struct {
+	name     string              // Config section, or empty if not restartable
+	external bool                // Uses external devices or services, so is not started when replaying
+	init     func(*DB) error     // Init hook
+	ctx      context.Context     // Cancelled when the feature is stopped
+	cancel   context.CancelFunc  //
+	modules  []Module            // Modules added, in order
+	tickers  []*Ticker           // Callback tickers
+	status   []string            // Status printer keys
+	limiters []string            // Power limiter names
+	subs     map[string][]string // Sub-element tags allocated, by base tag
+	next     map[string]int      // Index of next sub-element tag to reuse, by base tag
+}
+
+// hook is a function registered by a feature.
+type hook[T any] struct {
+	owner *feature
+	f     T
+}
+
+// List of features to initialise after checkpoint data is available.
+// Used to initialise database elements.
+var features []*feature
+
+// Register an init function.
+// These will be called once the checkpoint data is read.
+func RegisterInit(f func(*DB) error) {
+	features = append(features, &feature{init: f})
+}
+
+// RegisterModule registers an init function for a module that
+// is configured via the named config section, and which can be
+// restarted if that section changes when the configuration is reloaded.
+func RegisterModule(name string, f func(*DB) error) {
+	features = append(features, &feature{name: name, init: f})
+}
+
+// RegisterExternal registers an init function for a module (in the same way
+// as RegisterModule) that reads from devices or sends to external services.
+// These modules are not started when a recording is replayed, so that the
+// replay does not poll live devices or publish the replayed values.
+func RegisterExternal(name string, f func(*DB) error) {
+	features = append(features, &feature{name: name, init: f, external: true})
+}
+
+// newFeature creates the feature that owns the resources added by the core.
+func newFeature() *feature {
+	f := &feature{}
+	f.ctx, f.cancel = context.WithCancel(context.Background())
+	return f
+}
+
+// AddModule adds a module, which is started immediately (unless this is a dry run),
+// and stopped when the feature adding it is stopped. The status of the module is
+// displayed under the name.
+func (d *DB) AddModule(name string, m Module) error {
+	d.AddStatusPrinter(name, m.Status)
+	if d.Dryrun {
+		return nil
+	}
+	f := d.current
+	f.modules = append(f.modules, m)
+	return m.Start(f.ctx)
+}
+
+// Sleep waits for the duration, returning false if the context is done first.
+func Sleep(ctx context.Context, t time.Duration) bool {
+	timer := time.NewTimer(t)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// Wait waits for the goroutines in the WaitGroup to complete, or until the context is done.
+func Wait(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-done:
+		return nil
+	}
+}
+
+// splitConfig splits the YAML config into separate sections.
+// Disabled features are skipped.
+func (d *DB) splitConfig(conf []byte) (map[string][]byte, error) {
+	m := make(map[string]any)
+	err := yaml.Unmarshal(conf, &m)
+	if err != nil {
+		return nil, err
+	}
+	sections := make(map[string][]byte)
+	for k, v := range m {
+		// If a config section has been disabled, do not
+		// save that section.
+		_, ok := d.disabled[k]
+		if ok {
+			log.Printf("Disabling feature %s", k)
+			continue
+		}
+		b, err := yaml.Marshal(v)
+		if err != nil {
+			return nil, fmt.Errorf("YAML marshal of %s failed: %v", k, err)
+		}
+		sections[k] = b
+		if d.Trace || d.Dryrun {
+			log.Printf("YAML section %s = %v", k, v)
+		}
+	}
+	return sections, nil
+}
+
+// decoders creates a YAML decoder for each separate subsection of the YAML config file.
+// Unknown fields are rejected.
+func decoders(sections map[string][]byte) map[string]*yaml.Decoder {
+	m := make(map[string]*yaml.Decoder)
+	for k, b := range sections {
+		m[k] = yaml.NewDecoder(bytes.NewReader(b))
+		m[k].KnownFields(true)
+	}
+	return m
+}
+
+// startFeature calls the feature's init hook, recording the resources the feature adds.
+func (d *DB) startFeature(m *feature) error {
+	m.ctx, m.cancel = context.WithCancel(context.Background())
+	if m.external && d.Replaying() {
+		if _, ok := d.Config[m.name]; ok {
+			log.Printf("Replay: %s not started", m.name)
+		}
+		return nil
+	}
+	d.current = m
+	err := m.init(d)
+	d.current = d.core
+	d.releaseSubs(m)
+	return err
+}
+
+// stopFeature stops the feature's modules, cancels the feature's tickers and context,
+// and removes its pollers, exports, handlers and status printers.
+func (d *DB) stopFeature(m *feature, ctx context.Context) {
+	for _, t := range m.tickers {
+		t.Cancel()
+	}
+	m.tickers = nil
+	// Stop the modules in the reverse order they were added.
+	for i := len(m.modules) - 1; i >= 0; i-- {
+		if err := m.modules[i].Stop(ctx); err != nil {
+			log.Printf("Stop %s: %v", m.name, err)
+		}
+	}
+	m.modules = nil
+	if m.cancel != nil {
+		m.cancel()
+	}
+	d.pollList = slices.DeleteFunc(d.pollList, func(h hook[func(context.Context)]) bool { return h.owner == m })
+	for k, fl := range d.exportMap {
+		fl = slices.DeleteFunc(fl, func(h hook[func(context.Context, time.Time)]) bool { return h.owner == m })
+		if len(fl) == 0 {
+			delete(d.exportMap, k)
+		} else {
+			d.exportMap[k] = fl
+		}
+	}
+	d.eventHandlers = slices.DeleteFunc(d.eventHandlers, func(h hook[func(Event)]) bool { return h.owner == m })
+	d.backfillHandlers = slices.DeleteFunc(d.backfillHandlers, func(h hook[func([]BackfillRecord)]) bool { return h.owner == m })
+	for _, k := range m.status {
+		delete(d.status, k)
+	}
+	m.status = nil
+	for _, k := range m.limiters {
+		delete(d.limiters, k)
+	}
+	m.limiters = nil
+	// Sub-elements are reused in the same order when the feature is restarted.
+	m.next = nil
+}
+
+// reuseSub returns the next sub-element tag previously allocated
+// to the feature being restarted, reattaching the sub-element if necessary.
+func (d *DB) reuseSub(base string) (string, bool) {
+	m := d.current
+	if m.next[base] >= len(m.subs[base]) {
+		return "", false
+	}
+	if m.next == nil {
+		m.next = make(map[string]int)
+	}
+	tag := m.subs[base][m.next[base]]
+	m.next[base]++
+	switch b := d.elements[base].(type) {
+	case *MultiElement:
+		b.attach(d.elements[tag])
+	case *MultiAccum:
+		b.attach(d.elements[tag].(Acc))
+	}
+	return tag, true
+}
+
+// ownSub records a sub-element tag allocated to the feature being initialised.
+func (d *DB) ownSub(base, tag string) {
+	m := d.current
+	if m.subs == nil {
+		m.subs = make(map[string][]string)
+	}
+	if m.next == nil {
+		m.next = make(map[string]int)
+	}
+	m.subs[base] = append(m.subs[base], tag)
+	m.next[base] = len(m.subs[base])
+}
+
+// releaseSubs detaches the sub-elements that were previously allocated to
+// the feature and not reused, so that the stale values are not included in the totals.
+// The sub-elements remain in the database.
+func (d *DB) releaseSubs(m *feature) {
+	for base, tags := range m.subs {
+		for _, tag := range tags[m.next[base]:] {
+			switch b := d.elements[base].(type) {
+			case *MultiElement:
+				b.detach(d.elements[tag])
+			case *MultiAccum:
+				b.detach(d.elements[tag].(Acc))
+			}
+		}
+	}
+}
+
+// reload re-reads the config file, and restarts the features
+// whose config section has changed. If the new configuration
+// is invalid, the current configuration remains in use.
+func (d *DB) reload() {
+	if len(d.ConfigFile) == 0 {
+		log.Printf("Reload: no config file")
+		return
+	}
+	conf, err := os.ReadFile(d.ConfigFile)
+	if err != nil {
+		log.Printf("Reload: %v", err)
+		return
+	}
+	sections, err := d.splitConfig(conf)
+	if err != nil {
+		log.Printf("Reload: %s: %v", d.ConfigFile, err)
+		return
+	}
+	// Find the changed sections.
+	changed := make(map[string]bool)
+	for k, b := range sections {
+		changed[k] = !bytes.Equal(b, d.sections[k])
+	}
+	for k := range d.sections {
+		if _, ok := sections[k]; !ok {
+			changed[k] = true
+		}
+	}
+	var restart []*feature
+	for _, m := range features {
+		if len(m.name) != 0 && changed[m.name] {
+			restart = append(restart, m)
+			delete(changed, m.name)
+		}
+	}
+	var keep []string
+	for k, c := range changed {
+		if c {
+			keep = append(keep, k)
+		}
+	}
+	sort.Strings(keep)
+	for _, k := range keep {
+		log.Printf("Reload: config section %s changed, restart required", k)
+		// Keep the current section so that the change is reported on the next reload.
+		if b, ok := d.sections[k]; ok {
+			sections[k] = b
+		} else {
+			delete(sections, k)
+		}
+	}
+	if len(restart) == 0 {
+		log.Printf("Reload: no features to restart")
+		return
+	}
+	// Validate the new configuration of the features against a copy of the database,
+	// in the same way as a dry run. The bases of the sub-elements are copied so that
+	// the sub-elements allocated during validation are not added to the live bases.
+	v := NewDatabase(nil)
+	v.Dryrun = true
+	v.Config = decoders(sections)
+	for k, e := range d.elements {
+		switch b := e.(type) {
+		case *MultiElement:
+			e = b.clone()
+		case *MultiAccum:
+			e = b.clone()
+		}
+		v.elements[k] = e
+	}
+	v.freshness = d.freshness
+	v.state = maps.Clone(d.state)
+	for _, m := range restart {
+		if err := m.init(v); err != nil {
+			log.Printf("Reload: %s: %v - configuration not changed", m.name, err)
+			return
+		}
+	}
+	d.sections = sections
+	d.Config = decoders(sections)
+	ctx, cancel := context.WithTimeout(context.Background(), stopTimeout)
+	defer cancel()
+	for _, m := range restart {
+		if _, ok := sections[m.name]; !ok {
+			// The feature has been removed from the config.
+			d.stopFeature(m, ctx)
+			log.Printf("Reload: stopped %s", m.name)
+			d.releaseSubs(m)
+			continue
+		}
+		log.Printf("Reload: restarting %s", m.name)
+		d.restartFeature(m, ctx)
+	}
+	d.startExports()
+}
+
+// Restart stops and restarts the feature registered with the name, e.g
+// when a module has found new devices that require elements to be added.
+// Must be called from the main thread.
+func (d *DB) Restart(name string) {
+	for _, m := range features {
+		if len(m.name) != 0 && m.name == name {
+			ctx, cancel := context.WithTimeout(context.Background(), stopTimeout)
+			defer cancel()
+			log.Printf("Restarting %s", name)
+			// The config section is decoded again by the init hook.
+			if sec, ok := d.sections[name]; ok {
+				d.Config[name] = decoders(map[string][]byte{name: sec})[name]
+			}
+			d.restartFeature(m, ctx)
+			d.startExports()
+			return
+		}
+	}
+	log.Printf("Restart: %s: unknown feature", name)
+}
+
+// restartFeature stops the feature, and calls the init hook again.
+// If the init hook fails, the feature remains stopped.
+func (d *DB) restartFeature(m *feature, ctx context.Context) {
+	d.stopFeature(m, ctx)
+	if err := d.startFeature(m); err != nil {
+		log.Printf("Restart %s: %v", m.name, err)
+		d.stopFeature(m, ctx)
+		d.releaseSubs(m)
+	}
+}
+
+// shutdown stops the features in the reverse order they were started, allowing
+// time for in-flight requests to complete, and then writes the checkpoint.
+func (d *DB) shutdown(checkpoint string) {
+	for _, t := range d.exportTick {
+		t.Cancel()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), stopTimeout)
+	defer cancel()
+	for i := len(features) - 1; i >= 0; i-- {
+		d.stopFeature(features[i], ctx)
+	}
+	d.stopFeature(d.core, ctx)
+	// Process any remaining inputs before the checkpoint is written.
+	d.drainInput()
+	if len(checkpoint) != 0 {
+		d.writeCheckpoint(checkpoint, d.Clock.Now())
+	}
+	if d.recorder != nil {
+		d.recorder.close()
+	}
+}
@@ -0,0 +1,206 @@
+// Copyright 2026 Andrew McRae
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"testing"
+)
+
+func TestReload(t *testing.T) {
+	type testConfig struct {
+		Value int
+	}
+	saved := features
+	defer func() { features = saved }()
+	features = nil
+	var value, inits int
+	var tag string
+	RegisterModule("test", func(d *DB) error {
+		var conf testConfig
+		c, ok := d.Config["test"]
+		if !ok {
+			return nil
+		}
+		if err := c.Decode(&conf); err != nil {
+			return err
+		}
+		d.AddStatusPrinter("test", func() string { return "ok" })
+		// Sub-elements are allocated when validating the config, as the modules do.
+		subTag := d.AddSubGauge("TV", false)
+		if !d.Dryrun {
+			value = conf.Value
+			inits++
+			tag = subTag
+			d.AddCallback(time.Hour, 0, func(time.Time) {})
+			d.AddPoll(func(context.Context) {})
+		}
+		return nil
+	})
+	fn := filepath.Join(t.TempDir(), "config")
+	write := func(s string) {
+		if err := os.WriteFile(fn, []byte(s), 0644); err != nil {
+			t.Fatalf("%s: %v", fn, err)
+		}
+	}
+	write("db:\n  update: 60\ntest:\n  value: 1\n")
+	conf, _ := os.ReadFile(fn)
+	d := NewDatabase(conf)
+	d.ConfigFile = fn
+	var err error
+	if d.sections, err = d.splitConfig(d.yaml); err != nil {
+		t.Fatalf("splitConfig: %v", err)
+	}
+	d.Config = decoders(d.sections)
+	for _, f := range features {
+		if err := d.startFeature(f); err != nil {
+			t.Fatalf("init: %v", err)
+		}
+	}
+	if value != 1 || tag != "TV/0" {
+		t.Fatalf("init: value %d, tag %s", value, tag)
+	}
+	d.GetElement(tag).Update(5, time.Now())
+	ticker := features[0].tickers[0]
+	// Unchanged config does not restart the module.
+	d.reload()
+	if inits != 1 {
+		t.Errorf("unchanged config: %d inits", inits)
+	}
+	// Changed config restarts the module, reusing the sub-gauge.
+	write("db:\n  update: 60\ntest:\n  value: 2\n")
+	d.reload()
+	if inits != 2 || value != 2 || tag != "TV/0" {
+		t.Errorf("reload: inits %d, value %d, tag %s", inits, value, tag)
+	}
+	if !ticker.Cancelled() {
+		t.Errorf("reload: ticker not cancelled")
+	}
+	if len(d.pollList) != 1 || len(features[0].tickers) != 1 {
+		t.Errorf("reload: %d polls, %d tickers", len(d.pollList), len(features[0].tickers))
+	}
+	if v := d.GetElement("TV").Get(); v != 5 {
+		t.Errorf("reload: TV is %g, want 5", v)
+	}
+	if n := len(d.GetElement("TV").(*MultiElement).elements); n != 1 || !d.GetElement("TV").Fresh() {
+		t.Errorf("reload: TV has %d sub-elements, fresh %v", n, d.GetElement("TV").Fresh())
+	}
+	// Restarting the module reuses the sub-gauge.
+	d.Restart("test")
+	if inits != 3 || value != 2 || tag != "TV/0" {
+		t.Errorf("restart: inits %d, value %d, tag %s", inits, value, tag)
+	}
+	if len(d.pollList) != 1 || len(d.GetElement("TV").(*MultiElement).elements) != 1 {
+		t.Errorf("restart: %d polls, %d sub-elements", len(d.pollList), len(d.GetElement("TV").(*MultiElement).elements))
+	}
+	// An invalid config is rejected.
+	write("db:\n  update: 60\ntest:\n  value: 3\n  unknown: 1\n")
+	d.reload()
+	if inits != 3 || value != 2 {
+		t.Errorf("invalid config: inits %d, value %d", inits, value)
+	}
+	// A change to a section of a module that cannot be restarted is not applied.
+	write("db:\n  update: 30\ntest:\n  value: 2\n")
+	d.reload()
+	if inits != 3 || string(d.sections["db"]) != "update: 60\n" {
+		t.Errorf("db change: inits %d, db section %q", inits, d.sections["db"])
+	}
+	// Removing the module's config stops it.
+	write("db:\n  update: 60\n")
+	d.reload()
+	if len(d.pollList) != 0 || len(features[0].tickers) != 0 {
+		t.Errorf("removed: %d polls, %d tickers", len(d.pollList), len(features[0].tickers))
+	}
+	if _, ok := d.GetStatus()["test"]; ok {
+		t.Errorf("removed: status printer not removed")
+	}
+	if d.GetElement("TV").Fresh() {
+		t.Errorf("removed: TV is fresh")
+	}
+	if d.GetElement(tag) == nil {
+		t.Errorf("removed: %s not kept", tag)
+	}
+}
+
+// testModule records the calls to the module.
+type testModule struct {
+	name  string
+	calls *[]string
+	ctx   context.Context
+}
+
+func (m *testModule) Start(ctx context.Context) error {
+	m.ctx = ctx
+	*m.calls = append(*m.calls, "start "+m.name)
+	return nil
+}
+
+func (m *testModule) Stop(ctx context.Context) error {
+	if m.ctx.Err() != nil {
+		*m.calls = append(*m.calls, "cancelled before stop "+m.name)
+	}
+	*m.calls = append(*m.calls, "stop "+m.name)
+	return nil
+}
+
+func (m *testModule) Status() string {
+	return m.name
+}
+
+func TestShutdown(t *testing.T) {
+	saved := features
+	defer func() { features = saved }()
+	features = nil
+	var calls []string
+	a := &testModule{name: "a", calls: &calls}
+	b := &testModule{name: "b", calls: &calls}
+	RegisterModule("test", func(d *DB) error {
+		d.AddGauge("G")
+		if err := d.AddModule("a", a); err != nil {
+			return err
+		}
+		return d.AddModule("b", b)
+	})
+	d := NewDatabase(nil)
+	for _, f := range features {
+		if err := d.startFeature(f); err != nil {
+			t.Fatalf("init: %v", err)
+		}
+	}
+	if s := d.GetStatus(); s["a"] != "a" || s["b"] != "b" {
+		t.Errorf("status: %v", s)
+	}
+	d.GetElement("G").Update(3, time.Now())
+	cp := filepath.Join(t.TempDir(), "checkpoint")
+	d.shutdown(cp)
+	want := []string{"start a", "start b", "stop b", "stop a"}
+	if !slices.Equal(calls, want) {
+		t.Errorf("calls: got %v, want %v", calls, want)
+	}
+	if a.ctx.Err() == nil || b.ctx.Err() == nil {
+		t.Errorf("context not cancelled after shutdown")
+	}
+	if len(d.GetStatus()) != 0 {
+		t.Errorf("status not removed: %v", d.GetStatus())
+	}
+	if _, err := os.Stat(cp); err != nil {
+		t.Errorf("checkpoint not written: %v", err)
+	}
+}
@@ -16,6 +16,7 @@ package core
 
 import (
 	"fmt"
+	"slices"
 	"time"
 )
 
@@ -24,6 +25,7 @@ import (
 type MultiAccum struct {
 	name   string
 	accums []Acc
+	count  int // Number of tags allocated
 }
 
 func NewMultiAccum(base string) *MultiAccum {
@@ -31,11 +33,31 @@ func NewMultiAccum(base string) *MultiAccum {
 }
 
 func (m *MultiAccum) NextTag() string {
-	return fmt.Sprintf("%s/%d", m.name, len(m.accums))
+	return fmt.Sprintf("%s/%d", m.name, m.count)
 }
 
 func (m *MultiAccum) Add(a Acc) {
 	m.accums = append(m.accums, a)
+	m.count++
+}
+
+// clone returns a copy with its own list of sub-accumulators.
+func (m *MultiAccum) clone() *MultiAccum {
+	c := *m
+	c.accums = slices.Clone(m.accums)
+	return &c
+}
+
+// attach re-adds a detached sub-accumulator.
+func (m *MultiAccum) attach(a Acc) {
+	if !slices.Contains(m.accums, a) {
+		m.accums = append(m.accums, a)
+	}
+}
+
+// detach removes a sub-accumulator that is no longer being updated.
+func (m *MultiAccum) detach(a Acc) {
+	m.accums = slices.DeleteFunc(m.accums, func(e Acc) bool { return e == a })
 }
 
 func (m *MultiAccum) Update(v float64, ts time.Time) {
@@ -76,13 +98,37 @@ func (m *MultiAccum) Fresh() bool {
 			return false
 		}
 	}
-	return true
+	// All the sub-accumulators may have been detached.
+	return len(m.accums) != 0
+}
+
+func (m *MultiAccum) Rollover(p Period) {
+	for _, a := range m.accums {
+		a.Rollover(p)
+	}
 }
 
 func (m *MultiAccum) Daily() float64 {
+	return m.sum(Acc.Daily)
+}
+
+func (m *MultiAccum) Monthly() float64 {
+	return m.sum(Acc.Monthly)
+}
+
+func (m *MultiAccum) Yearly() float64 {
+	return m.sum(Acc.Yearly)
+}
+
+func (m *MultiAccum) Billing() float64 {
+	return m.sum(Acc.Billing)
+}
+
+// sum returns the sum of the selected value of the sub-accumulators.
+func (m *MultiAccum) sum(f func(Acc) float64) float64 {
 	var v float64
 	for _, a := range m.accums {
-		v += a.Daily()
+		v += f(a)
 	}
 	return v
 }
@@ -16,6 +16,7 @@ package core
 
 import (
 	"fmt"
+	"slices"
 	"time"
 )
 
@@ -25,6 +26,7 @@ type MultiElement struct {
 	name     string
 	average  bool
 	elements []Element
+	count    int // Number of tags allocated
 }
 
 func NewMultiElement(base string, average bool) *MultiElement {
@@ -32,11 +34,31 @@ func NewMultiElement(base string, average bool) *MultiElement {
 }
 
 func (m *MultiElement) NextTag() string {
-	return fmt.Sprintf("%s/%d", m.name, len(m.elements))
+	return fmt.Sprintf("%s/%d", m.name, m.count)
 }
 
 func (m *MultiElement) Add(g Element) {
 	m.elements = append(m.elements, g)
+	m.count++
+}
+
+// clone returns a copy with its own list of sub-elements.
+func (m *MultiElement) clone() *MultiElement {
+	c := *m
+	c.elements = slices.Clone(m.elements)
+	return &c
+}
+
+// attach re-adds a detached sub-element.
+func (m *MultiElement) attach(g Element) {
+	if !slices.Contains(m.elements, g) {
+		m.elements = append(m.elements, g)
+	}
+}
+
+// detach removes a sub-element that is no longer being updated.
+func (m *MultiElement) detach(g Element) {
+	m.elements = slices.DeleteFunc(m.elements, func(e Element) bool { return e == g })
 }
 
 func (m *MultiElement) Update(value float64, ts time.Time) {
@@ -74,5 +96,6 @@ func (m *MultiElement) Fresh() bool {
 			return false
 		}
 	}
-	return true
+	// All the sub-elements may have been detached.
+	return len(m.elements) != 0
 }
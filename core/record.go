@@ -0,0 +1,304 @@
+// Copyright 2026 Andrew McRae
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The inputs received may be recorded to a file, so that they can later be
+// replayed (e.g to reproduce the exported data). Each line of the recording is of the form:
+//
+//	<RFC3339 time> <tag> <value>
+//
+// Before the first input to each tag, the element receiving the inputs is declared:
+//
+//	# <tag> <type> [average] [resettable]
+//
+// where the type is the checkpoint type of the element (gauge, diff or accum), average is
+// set if the element is a sub-element that is averaged, and resettable is set if the
+// element is a resettable accumulator.
+//
+// When the recording is replayed, the declared elements that are not added by
+// the configured features are created, so that the input modules are not required.
+// The database uses a simulated clock that is advanced to the time of each recorded
+// input in turn, so that the tickers (and the export functions) are invoked at the
+// same times as when the inputs were recorded.
+
+// Record is a recorded input.
+type Record struct {
+	Time  time.Time
+	Tag   string
+	Value float64
+}
+
+// String formats the record as a line of the recording.
+func (r Record) String() string {
+	return fmt.Sprintf("%s %s %s", r.Time.Format(time.RFC3339Nano), r.Tag, strconv.FormatFloat(r.Value, 'g', -1, 64))
+}
+
+// ElementDef is the declaration of an element in a recording.
+type ElementDef struct {
+	Type       string // Checkpoint type of the element
+	Average    bool   // The element is a sub-element that is averaged
+	Resettable bool   // The element is a resettable accumulator
+}
+
+// String formats the type and flags of the declaration.
+func (e ElementDef) String() string {
+	s := e.Type
+	if e.Average {
+		s += " average"
+	}
+	if e.Resettable {
+		s += " resettable"
+	}
+	return s
+}
+
+// parseElementDef decodes a declaration line of the recording, returning the tag and the declaration.
+func parseElementDef(s string) (string, ElementDef, error) {
+	var e ElementDef
+	f := strings.Fields(s)
+	if len(f) < 3 || f[0] != "#" {
+		return "", e, fmt.Errorf("bad declaration '%s'", s)
+	}
+	e.Type = f[2]
+	switch e.Type {
+	case CP_GAUGE, CP_DIFF, CP_ACCUM:
+	default:
+		return "", e, fmt.Errorf("%s: unknown element type '%s'", f[1], e.Type)
+	}
+	for _, flag := range f[3:] {
+		switch flag {
+		case "average":
+			e.Average = true
+		case "resettable":
+			e.Resettable = true
+		default:
+			return "", e, fmt.Errorf("%s: unknown flag '%s'", f[1], flag)
+		}
+	}
+	return f[1], e, nil
+}
+
+// Recording is the contents of a recording.
+type Recording struct {
+	Records  []Record              // The inputs, sorted by time
+	Elements map[string]ElementDef // The declared elements, by tag
+}
+
+// ParseRecord decodes a line of the recording.
+func ParseRecord(s string) (Record, error) {
+	var r Record
+	f := strings.Fields(s)
+	if len(f) != 3 {
+		return r, fmt.Errorf("bad record '%s'", s)
+	}
+	var err error
+	if r.Time, err = time.Parse(time.RFC3339Nano, f[0]); err != nil {
+		return r, err
+	}
+	r.Tag = f[1]
+	r.Value, err = strconv.ParseFloat(f[2], 64)
+	return r, err
+}
+
+// ReadRecording reads the records and element declarations from a recording.
+func ReadRecording(file string) (*Recording, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rec := &Recording{Elements: make(map[string]ElementDef)}
+	sc := bufio.NewScanner(f)
+	for lineno := 1; sc.Scan(); lineno++ {
+		line := strings.TrimSpace(sc.Text())
+		if len(line) == 0 {
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			tag, e, err := parseElementDef(line)
+			if err != nil {
+				return nil, fmt.Errorf("%s: line %d: %v", file, lineno, err)
+			}
+			rec.Elements[tag] = e
+			continue
+		}
+		r, err := ParseRecord(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s: line %d: %v", file, lineno, err)
+		}
+		rec.Records = append(rec.Records, r)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	sortRecords(rec.Records)
+	return rec, nil
+}
+
+// sortRecords sorts the records by time, keeping the order of records with the same time.
+func sortRecords(recs []Record) {
+	sort.SliceStable(recs, func(i, j int) bool { return recs[i].Time.Before(recs[j].Time) })
+}
+
+// recorder appends the inputs to a recording file.
+type recorder struct {
+	mu       sync.Mutex
+	f        *os.File
+	w        *bufio.Writer
+	declared map[string]struct{} // Tags that have been declared
+}
+
+func newRecorder(file string) (*recorder, error) {
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &recorder{f: f, w: bufio.NewWriter(f), declared: make(map[string]struct{})}, nil
+}
+
+// record writes the input, declaring the element first if this is the first input to the tag.
+// Inputs to unknown tags are not declared.
+func (r *recorder) record(ts time.Time, tag string, v float64, e ElementDef) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.declared[tag]; !ok && len(e.Type) != 0 {
+		fmt.Fprintf(r.w, "# %s %s\n", tag, e)
+		r.declared[tag] = struct{}{}
+	}
+	fmt.Fprintln(r.w, Record{ts, tag, v})
+}
+
+// flush writes the buffered records to the file.
+func (r *recorder) flush() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.w.Flush(); err != nil {
+		log.Printf("Recording: %v", err)
+	}
+}
+
+func (r *recorder) close() {
+	r.flush()
+	r.f.Close()
+}
+
+// elementDef returns the declaration of the element for the recording.
+func (d *DB) elementDef(tag string, el Element) ElementDef {
+	e := ElementDef{Type: checkpointType(el)}
+	if a, ok := el.(*Accum); ok {
+		e.Resettable = a.resettable
+	}
+	if base, _, ok := strings.Cut(tag, "/"); ok {
+		if m, ok := d.elements[base].(*MultiElement); ok {
+			e.Average = m.average
+		}
+	}
+	return e
+}
+
+// addRecorded adds the elements declared in the recording that have not been
+// added by the features. Sub-elements are added to their base elements.
+func (d *DB) addRecorded(defs map[string]ElementDef) {
+	tags := make([]string, 0, len(defs))
+	for tag := range defs {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	for _, tag := range tags {
+		if _, ok := d.elements[tag]; ok {
+			continue
+		}
+		e := defs[tag]
+		var el Element
+		switch e.Type {
+		case CP_GAUGE:
+			el = d.newGauge(tag)
+		case CP_DIFF:
+			el = d.newDiff(tag)
+		case CP_ACCUM:
+			el = d.newAccum(tag, e.Resettable)
+		}
+		if base, _, ok := strings.Cut(tag, "/"); ok {
+			b, exists := d.elements[base]
+			if !exists {
+				if e.Type == CP_ACCUM {
+					b = NewMultiAccum(base)
+				} else {
+					b = NewMultiElement(base, e.Average)
+				}
+				d.elements[base] = b
+			}
+			switch m := b.(type) {
+			case *MultiAccum:
+				if a, ok := el.(Acc); ok {
+					m.Add(a)
+				}
+			case *MultiElement:
+				m.Add(el)
+			}
+		}
+		d.elements[tag] = el
+		if d.Trace {
+			log.Printf("Replay: added %s (%s)", tag, e)
+		}
+	}
+}
+
+// replay feeds the recorded inputs to the database, advancing the simulated clock
+// to the time of each input, and closes done when the replay is complete.
+// Once the inputs have been replayed, the clock is advanced by the
+// longest export interval so that the final exports are run.
+func (d *DB) replay(recs []Record, c *SimClock, done chan<- struct{}) {
+	defer close(done)
+	for _, r := range recs {
+		d.advance(c, r.Time)
+		d.Execute(func() {
+			d.processInput(input{tag: r.Tag, value: r.Value})
+		})
+	}
+	var longest time.Duration
+	d.Execute(func() {
+		for k := range d.exportMap {
+			longest = max(longest, k.tick+k.offs)
+		}
+	})
+	d.advance(c, recs[len(recs)-1].Time.Add(longest))
+}
+
+// advance sets the simulated clock, stepping through the
+// timers so that each export completes before the next timer expires.
+func (d *DB) advance(c *SimClock, t time.Time) {
+	for {
+		next, ok := c.Next()
+		if !ok || next.After(t) {
+			break
+		}
+		c.Set(next)
+		d.exporting.Wait()
+	}
+	c.Set(t)
+}
@@ -0,0 +1,191 @@
+// Copyright 2026 Andrew McRae
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSimClock(t *testing.T) {
+	start := time.Date(2024, 5, 14, 10, 0, 30, 0, time.UTC)
+	c := NewSimClock(start)
+	var ticks []string
+	newTicker(c, time.Minute*5, 0, func(now time.Time) {
+		ticks = append(ticks, "5m "+now.Format("15:04:05"))
+	})
+	tk := newTicker(c, time.Minute, time.Second*15, func(now time.Time) {
+		ticks = append(ticks, "1m "+now.Format("15:04:05"))
+	})
+	if next, ok := c.Next(); !ok || !next.Equal(start.Add(time.Second*45)) {
+		t.Errorf("Next: got %v %v", next, ok)
+	}
+	c.Set(start.Add(time.Minute * 5))
+	want := []string{"1m 10:01:15", "1m 10:02:15", "1m 10:03:15", "1m 10:04:15", "5m 10:05:00", "1m 10:05:15"}
+	if !slices.Equal(ticks, want) {
+		t.Errorf("ticks: got %v, want %v", ticks, want)
+	}
+	if now := c.Now(); !now.Equal(start.Add(time.Minute * 5)) {
+		t.Errorf("Now: got %v", now)
+	}
+	// The clock is not moved backwards.
+	c.Set(start)
+	if now := c.Now(); !now.Equal(start.Add(time.Minute * 5)) {
+		t.Errorf("Now after set backwards: got %v", now)
+	}
+	ticks = nil
+	tk.Cancel()
+	c.Set(start.Add(time.Minute * 10))
+	if want := []string{"5m 10:10:00"}; !slices.Equal(ticks, want) {
+		t.Errorf("cancelled ticker: got %v, want %v", ticks, want)
+	}
+}
+
+func TestRecord(t *testing.T) {
+	r := Record{time.Date(2024, 5, 14, 10, 0, 30, 500, time.FixedZone("AEST", 10*3600)), "IN", 1234.5678}
+	s := r.String()
+	if s != "2024-05-14T10:00:30.0000005+10:00 IN 1234.5678" {
+		t.Errorf("String: got %s", s)
+	}
+	p, err := ParseRecord(s)
+	if err != nil || !p.Time.Equal(r.Time) || p.Tag != r.Tag || p.Value != r.Value {
+		t.Errorf("ParseRecord: got %v, %v", p, err)
+	}
+	for _, bad := range []string{"", "2024-05-14T10:00:30Z IN", "yesterday IN 1", "2024-05-14T10:00:30Z IN x"} {
+		if _, err := ParseRecord(bad); err == nil {
+			t.Errorf("ParseRecord(%q): no error", bad)
+		}
+	}
+	// The element is declared before the first input to it.
+	fn := filepath.Join(t.TempDir(), "recording")
+	d := NewDatabase(nil)
+	if d.recorder, err = newRecorder(fn); err != nil {
+		t.Fatalf("recorder: %v", err)
+	}
+	d.Clock = NewSimClock(r.Time)
+	d.AddSubGauge("V", true)
+	d.AddAccum("IN", true)
+	for _, in := range []input{{"V/0", 240}, {"IN", 1}, {"V/0", 241}, {"X", 1}} {
+		d.processInput(in)
+	}
+	d.recorder.close()
+	b, _ := os.ReadFile(fn)
+	want := []string{
+		"# V/0 gauge average",
+		"2024-05-14T10:00:30.0000005+10:00 V/0 240",
+		"# IN accum resettable",
+		"2024-05-14T10:00:30.0000005+10:00 IN 1",
+		"2024-05-14T10:00:30.0000005+10:00 V/0 241",
+		"2024-05-14T10:00:30.0000005+10:00 X 1",
+	}
+	if got := strings.Split(strings.TrimSpace(string(b)), "\n"); !slices.Equal(got, want) {
+		t.Errorf("recording: got %q want %q", got, want)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	saved := features
+	defer func() { features = saved }()
+	features = nil
+	var exports []string
+	// The elements are created from the declarations in the recording.
+	RegisterInit(func(d *DB) error {
+		d.AddExport(time.Minute*5, 0, func(_ context.Context, now time.Time) {
+			exports = append(exports, fmt.Sprintf("%s G=%g A=%g S=%g", now.Format("15:04"),
+				d.GetElement("G").Get(), d.GetElement("A").Get(), d.GetElement("S").Get()))
+		})
+		return nil
+	})
+	// External modules are not started.
+	var external int
+	RegisterExternal("ext", func(d *DB) error {
+		external++
+		return nil
+	})
+	dir := t.TempDir()
+	// Record some inputs.
+	rec, err := newRecorder(filepath.Join(dir, "recording"))
+	if err != nil {
+		t.Fatalf("recorder: %v", err)
+	}
+	start := time.Date(2024, 5, 14, 10, 0, 30, 0, time.Local)
+	gauge := ElementDef{Type: CP_GAUGE}
+	accum := ElementDef{Type: CP_ACCUM}
+	avg := ElementDef{Type: CP_GAUGE, Average: true}
+	rec.record(start, "G", 1, gauge)
+	rec.record(start, "A", 100, accum)
+	rec.record(start, "S/0", 4, avg)
+	rec.record(start, "S/1", 6, avg)
+	rec.record(start.Add(time.Minute*4), "A", 101.5, accum)
+	rec.record(start.Add(time.Minute*6), "A", 102, accum)
+	rec.record(start.Add(time.Minute*4), "G", 2, gauge)
+	rec.record(start.Add(time.Minute*7), "G", 3, gauge)
+	rec.close()
+	r, err := ReadRecording(filepath.Join(dir, "recording"))
+	if err != nil || len(r.Records) != 8 || len(r.Elements) != 4 || r.Elements["S/1"] != avg {
+		t.Fatalf("ReadRecording: %d records, elements %v, %v", len(r.Records), r.Elements, err)
+	}
+	d := NewDatabase(nil)
+	d.Replay = filepath.Join(dir, "recording")
+	if err := d.Start(); err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	want := []string{"10:05 G=2 A=101.5 S=5", "10:10 G=3 A=102 S=5"}
+	if !slices.Equal(exports, want) {
+		t.Errorf("exports: got %v, want %v", exports, want)
+	}
+	// The replayed exports are the same on a second replay, and the
+	// configured checkpoint and history are not written.
+	exports = nil
+	cp, hist := filepath.Join(dir, "checkpoint"), filepath.Join(dir, "history")
+	d = NewDatabase([]byte(fmt.Sprintf("db:\n  checkpoint: %s\n  history: %s\next:\n  value: 1\n", cp, hist)))
+	d.Replay = filepath.Join(dir, "recording")
+	if err := d.Start(); err != nil {
+		t.Fatalf("second replay: %v", err)
+	}
+	if !slices.Equal(exports, want) {
+		t.Errorf("second replay exports: got %v, want %v", exports, want)
+	}
+	if external != 0 {
+		t.Errorf("external module started %d times", external)
+	}
+	for _, fn := range []string{cp, hist} {
+		if _, err := os.Stat(fn); err == nil {
+			t.Errorf("%s written by replay", fn)
+		}
+	}
+	// A recording with invalid data or declarations is rejected.
+	bad := filepath.Join(dir, "bad")
+	for _, s := range []string{
+		strings.Join([]string{start.Format(time.RFC3339), "G", "x"}, " "),
+		"# G meter",
+		"# G gauge sum",
+	} {
+		if err := os.WriteFile(bad, []byte(s), 0644); err != nil {
+			t.Fatalf("%s: %v", bad, err)
+		}
+		d = NewDatabase(nil)
+		d.Replay = bad
+		if err := d.Start(); err == nil {
+			t.Errorf("bad recording %q: no error", s)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright 2026 Andrew McRae
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"log"
+	"time"
+)
+
+// SumAccum is a derived accumulator that is the sum of other accumulators,
+// each of which may be added or subtracted.
+// The underlying accumulators perform the midnight and rollover processing.
+type SumAccum struct {
+	name  string
+	terms []sumTerm
+}
+
+type sumTerm struct {
+	acc      Acc
+	sign     float64
+	required bool // Must be fresh for the sum to be fresh
+}
+
+func NewSumAccum(name string) *SumAccum {
+	return &SumAccum{name: name}
+}
+
+// Add adds an accumulator to the sum. If subtract is set, the accumulator
+// is subtracted. If required is set, the sum is only fresh if the
+// accumulator is fresh. A nil accumulator is ignored.
+func (s *SumAccum) Add(a Acc, subtract, required bool) {
+	if a == nil {
+		return
+	}
+	t := sumTerm{acc: a, sign: 1, required: required}
+	if subtract {
+		t.sign = -1
+	}
+	s.terms = append(s.terms, t)
+}
+
+func (s *SumAccum) Update(v float64, ts time.Time) {
+	// No one should be updating a derived accumulator.
+	log.Printf("%s: update of derived accumulator ignored", s.name)
+}
+
+func (s *SumAccum) Get() float64 {
+	return s.sum(Acc.Get)
+}
+
+func (s *SumAccum) Midnight() {
+}
+
+func (s *SumAccum) Rollover(p Period) {
+}
+
+func (s *SumAccum) Daily() float64 {
+	return s.sum(Acc.Daily)
+}
+
+func (s *SumAccum) Monthly() float64 {
+	return s.sum(Acc.Monthly)
+}
+
+func (s *SumAccum) Yearly() float64 {
+	return s.sum(Acc.Yearly)
+}
+
+func (s *SumAccum) Billing() float64 {
+	return s.sum(Acc.Billing)
+}
+
+// Return the oldest timestamp of the required accumulators.
+func (s *SumAccum) Timestamp() time.Time {
+	var timestamp time.Time
+	for _, t := range s.terms {
+		if !t.required {
+			continue
+		}
+		ts := t.acc.Timestamp()
+		if timestamp.IsZero() || timestamp.After(ts) {
+			timestamp = ts
+		}
+	}
+	return timestamp
+}
+
+// Return true only if all the required accumulators are fresh.
+func (s *SumAccum) Fresh() bool {
+	for _, t := range s.terms {
+		if t.required && !t.acc.Fresh() {
+			return false
+		}
+	}
+	return len(s.terms) != 0
+}
+
+func (s *SumAccum) sum(f func(Acc) float64) float64 {
+	var v float64
+	for _, t := range s.terms {
+		v += t.sign * f(t.acc)
+	}
+	return v
+}
@@ -41,6 +41,16 @@ const (
 	G_VOLTS     = "VOLTS"   // Current AC voltage (V)
 	D_GEN_P     = "D-GEN-P" // Derived PV power (Kw)
 	G_MPTT      = "MPTT"    // Individual string (Kw)
+	// Detailed inverter values, with the inverter index and phase or string appended e.g AC-P-0-1, DC-V-0-A
+	G_AC_POWER   = "AC-P"     // Per-phase AC power (Kw)
+	G_AC_CURRENT = "AC-C"     // Per-phase AC current (A)
+	G_AC_VOLTS   = "AC-V"     // Per-phase AC voltage (V)
+	G_DC_VOLTS   = "DC-V"     // Per-string DC voltage (V)
+	G_DC_CURRENT = "DC-C"     // Per-string DC current (A)
+	G_INV_FREQ   = "INV-FREQ" // Grid frequency (Hz)
+	G_INV_TEMP   = "INV-TEMP" // Internal temperature (degrees C)
+	G_INV_STATUS = "INV-ST"   // Operating status (see enum)
+	G_INV_RISO   = "INV-RISO" // Insulation resistance (kOhm)
 	// Values read from battery,
 	A_CHARGE_TOTAL    = "CHARGE-T" // Lifetime total charge
 	A_DISCHARGE_TOTAL = "DISC-T"   // Lifetime total discharge
@@ -50,6 +60,23 @@ const (
 	G_BATT_STATUS     = "BATT-ST"  // Current battery status (see enum)
 	// Values read from weather service.
 	G_TEMP = "TEMP" // Current temperature (degrees C)
+	// Values derived from the energy balance.
+	G_CONS_POWER       = "CONS-P"      // Household consumption (Kw)
+	A_CONS_TOTAL       = "CONS"        // Household consumption (KwH)
+	G_SELF_POWER       = "SELF-P"      // PV power consumed on site (Kw)
+	G_PV_GRID_POWER    = "PV-GRID-P"   // PV power exported to the grid (Kw)
+	A_PV_GRID          = "PV-GRID"     // PV energy exported to the grid (KwH)
+	G_BATT_LOAD_POWER  = "BATT-LOAD-P" // Battery power used by the household (Kw)
+	A_BATT_LOAD        = "BATT-LOAD"   // Battery energy used by the household (KwH)
+	G_SELF_CONSUMPTION = "SELF-CONS"   // Daily PV energy consumed on site (percent)
+	G_SELF_SUFFICIENCY = "SELF-SUFF"   // Daily consumption not imported from the grid (percent)
+	// Values derived from tariff.
+	A_IMPORT_COST   = "IMP-COST"    // Cost of imported energy
+	A_EXPORT_CREDIT = "EXP-CREDIT"  // Credit for exported energy
+	A_SUPPLY_COST   = "SUPPLY-COST" // Supply charges
+	A_NET_COST      = "NET-COST"    // Net cost (import cost + supply charges - export credit)
+	G_IMPORT_RATE   = "IMP-RATE"    // Current import rate (per KwH)
+	G_FEEDIN_RATE   = "FEEDIN-RATE" // Current feed-in rate (per KwH)
 	// Special values.
 	C_TIME = "time" // Time checkpoint.
 )
@@ -15,35 +15,63 @@
 package core
 
 import (
+	"sync"
 	"sync/atomic"
 	"time"
 )
 
 // Ticker holds callbacks to be invoked at the specified period (e.g every 5 minutes)
 type Ticker struct {
+	clock     Clock
+	tick      time.Duration
+	offset    time.Duration
+	f         func(time.Time)
+	mu        sync.Mutex
+	stop      func() bool // Stops the current timer
 	cancelled atomic.Bool
 }
 
-// NewTicker creates and starts a new ticker.
+// NewTicker creates and starts a new ticker using the system clock.
 func NewTicker(tick, offset time.Duration, f func(time.Time)) *Ticker {
-	t := &Ticker{}
-	// Start a goroutine that invokes a callback for each ticker interval.
-	go func() {
-		for {
-			// Calculate the next time an event should be sent, and
-			// sleep until then.
-			now := time.Now()
-			target := now.Add(tick).Add(-offset).Truncate(tick).Add(offset)
-			time.Sleep(target.Sub(now))
-			if t.cancelled.Load() {
-				return
-			}
-			f(time.Now())
-		}
-	}()
+	return newTicker(SystemClock, tick, offset, f)
+}
+
+// newTicker creates and starts a new ticker using the clock.
+func newTicker(c Clock, tick, offset time.Duration, f func(time.Time)) *Ticker {
+	t := &Ticker{clock: c, tick: tick, offset: offset, f: f}
+	t.schedule()
 	return t
 }
 
+// schedule starts a timer for the next time an event should be sent.
+// When the timer expires, the callback is invoked, and then the next timer is started.
+func (t *Ticker) schedule() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.cancelled.Load() {
+		return
+	}
+	now := t.clock.Now()
+	target := now.Add(t.tick).Add(-t.offset).Truncate(t.tick).Add(t.offset)
+	t.stop = t.clock.AfterFunc(target.Sub(now), func() {
+		if t.cancelled.Load() {
+			return
+		}
+		t.f(t.clock.Now())
+		t.schedule()
+	})
+}
+
+// Cancel stops the ticker. No further callbacks are started.
 func (t *Ticker) Cancel() {
-	t.cancelled.Store(true)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.cancelled.Swap(true) && t.stop != nil {
+		t.stop()
+	}
+}
+
+// Cancelled returns true if the ticker has been cancelled.
+func (t *Ticker) Cancelled() bool {
+	return t.cancelled.Load()
 }
@@ -16,17 +16,24 @@
 // Under the base directory, year and month directories are
 // created, and a daily file named as 'yyyy-mm-dd' is written.
 // The package is configured as a section in the main YAML config file as:
-//  csv: <base directory>
+//  csv:
+//    base: <base directory>
+//    interval: <update interval in minutes>
+//    extra: [<tag>, ...]
 
 package csv
 
 import (
+	"bufio"
+	"context"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"path"
+	"slices"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -36,6 +43,7 @@ import (
 type CsvConfig struct {
 	Base     string
 	Interval int
+	Extra    []string // Additional tags to be written
 }
 
 type writer struct {
@@ -68,21 +76,30 @@ var fields []field = []field{
 	{"DISC-T", true},
 	{"BATT-P", false},
 	{"BATT-C", false},
+	{"CONS-P", false},
+	{"CONS", true},
+	{"IMP-COST", true},
+	{"EXP-CREDIT", true},
+	{"NET-COST", true},
 }
 
 const moduleName = "csv"
 
 type csv struct {
-	d      *core.DB
-	fpath  string
-	day    int
-	writer *writer
-	lines  int
-	status atomic.Value
+	d        *core.DB
+	fpath    string
+	interval time.Duration
+	fields   []field
+	day      int
+	mu       sync.Mutex     // Serialises writing to the files
+	wg       sync.WaitGroup // Pending writes
+	writer   *writer
+	lines    int
+	status   atomic.Value
 }
 
 func init() {
-	core.RegisterInit(csvInit)
+	core.RegisterModule(moduleName, csvInit)
 }
 
 // Returns a writer that writes daily CSV files in the form path/year/month/day
@@ -97,21 +114,27 @@ func csvInit(d *core.DB) error {
 		return err
 	}
 	interval := core.ConfigOrDefault(conf.Interval, 5) // Default of 5 minutes
-	c := &csv{d: d, fpath: conf.Base}
+	c := &csv{d: d, fpath: conf.Base, interval: time.Minute * time.Duration(interval), fields: fields[:len(fields):len(fields)]}
+	for _, t := range conf.Extra {
+		c.fields = append(c.fields, field{t, false})
+	}
 	c.status.Store("init")
 	if !d.Dryrun {
-		d.AddExport(time.Minute*time.Duration(interval), 0, c.Run)
+		d.AddExport(c.interval, 0, c.Run)
+		d.AddBackfillHandler(c.backfill)
+	}
+	if err := d.AddModule(moduleName, c); err != nil {
+		return err
 	}
-	d.AddStatusPrinter(moduleName, c.Status)
 	log.Printf("Registered CSV as writer, base directory %s, updating every %d minutes\n", conf.Base, interval)
 	return nil
 }
 
-func (c *csv) Run(now time.Time) {
+func (c *csv) Run(ctx context.Context, now time.Time) {
 	// Generate the line to be written.
 	var line strings.Builder
 	fmt.Fprint(&line, now.Format("2006-01-02,15:04"))
-	for _, f := range fields {
+	for _, f := range c.fields {
 		e := c.d.GetElement(f.name)
 		if e != nil && e.Fresh() {
 			fmt.Fprintf(&line, ",%s", core.FmtFloat(e.Get()))
@@ -127,12 +150,168 @@ func (c *csv) Run(now time.Time) {
 		}
 	}
 	// Delegate the file I/O to a goroutine.
-	go c.addCSV(now, line.String())
+	c.wg.Go(func() {
+		c.addCSV(now, line.String())
+	})
+}
+
+// backfill writes the historical records to the CSV files.
+// Values missing from the records are left empty.
+// The backfill usually runs after lines have been written by the export, so the
+// records are merged in time order with the lines already in the files. Only
+// records at the CSV interval are used, and existing lines are kept.
+func (c *csv) backfill(recs []core.BackfillRecord) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	days := make(map[string][]string)
+	var order []string
+	for _, r := range recs {
+		if !r.Time.Truncate(c.interval).Equal(r.Time) {
+			continue
+		}
+		var line strings.Builder
+		fmt.Fprint(&line, r.Time.Format("2006-01-02,15:04"))
+		for _, f := range c.fields {
+			fmtValue(&line, r.Values, f.name)
+			if f.accum {
+				fmtValue(&line, r.Daily, f.name)
+			}
+		}
+		day := r.Time.Format("2006-01-02")
+		if _, ok := days[day]; !ok {
+			order = append(order, day)
+		}
+		days[day] = append(days[day], line.String())
+	}
+	var added int
+	for _, day := range order {
+		n, err := c.merge(day, days[day])
+		if err != nil {
+			log.Printf("%s: backfill: %v", c.fpath, err)
+			return
+		}
+		added += n
+	}
+	log.Printf("csv: backfilled %d lines", added)
+}
+
+// merge merges the lines into the CSV file for the day, rewriting the file with the
+// lines in time order. Lines already in the file for the same time are kept.
+// The number of lines added is returned.
+func (c *csv) merge(day string, lines []string) (int, error) {
+	t, err := time.ParseInLocation("2006-01-02", day, time.Local)
+	if err != nil {
+		return 0, err
+	}
+	dir := path.Join(c.fpath, t.Format("2006"), t.Format("01"))
+	if err := os.MkdirAll(dir, 0775); err != nil {
+		return 0, err
+	}
+	fn := path.Join(dir, day)
+	hdr := c.headerLine()
+	// Lines keyed by the date and time.
+	byTime := make(map[string]string)
+	f, err := os.Open(fn)
+	if err == nil {
+		sc := bufio.NewScanner(f)
+		sc.Buffer(nil, 1024*1024)
+		for first := true; sc.Scan(); first = false {
+			l := sc.Text()
+			if strings.HasPrefix(l, "#") {
+				if first {
+					hdr = l
+				}
+				continue
+			}
+			byTime[lineTime(l)] = l
+		}
+		err = sc.Err()
+		f.Close()
+		if err != nil {
+			return 0, err
+		}
+	} else if !os.IsNotExist(err) {
+		return 0, err
+	}
+	var added int
+	for _, l := range lines {
+		if _, ok := byTime[lineTime(l)]; !ok {
+			byTime[lineTime(l)] = l
+			added++
+		}
+	}
+	if added == 0 {
+		return 0, nil
+	}
+	keys := make([]string, 0, len(byTime))
+	for k := range byTime {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	tmp := fn + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0664)
+	if err != nil {
+		return 0, err
+	}
+	w := bufio.NewWriter(out)
+	fmt.Fprintln(w, hdr)
+	for _, k := range keys {
+		fmt.Fprintln(w, byTime[k])
+	}
+	err = w.Flush()
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	// The current file is replaced, so it is reopened on the next write.
+	if c.writer != nil && c.writer.name == fn {
+		c.writer.Close()
+		c.writer = nil
+		c.day = 0
+	}
+	return added, os.Rename(tmp, fn)
+}
+
+// lineTime returns the date and time fields of a CSV line.
+func lineTime(l string) string {
+	if i := strings.IndexByte(l, ','); i >= 0 {
+		if j := strings.IndexByte(l[i+1:], ','); j >= 0 {
+			return l[:i+1+j]
+		}
+	}
+	return l
+}
+
+// fmtValue adds the value to the line, or an empty value if it is not present.
+func fmtValue(b *strings.Builder, m map[string]float64, tag string) {
+	if v, ok := m[tag]; ok {
+		fmt.Fprintf(b, ",%s", core.FmtFloat(v))
+	} else {
+		fmt.Fprint(b, ",")
+	}
+}
+
+// headerLine returns the CSV column header.
+func (c *csv) headerLine() string {
+	var h strings.Builder
+	fmt.Fprint(&h, header)
+	for _, f := range c.fields {
+		fmt.Fprintf(&h, ",%s", f.name)
+		if f.accum {
+			fmt.Fprintf(&h, ",%s-DAILY", f.name)
+		}
+	}
+	return h.String()
 }
 
 // addCSV writes the line to the CSV file, and if necessary
 // creating a new file.
 func (c *csv) addCSV(now time.Time, l string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	var b strings.Builder
 	defer func() { c.status.Store(b.String()) }()
 	fmt.Fprintf(&b, "%s: ", now.Format("2006-01-02 15:04"))
@@ -152,15 +331,7 @@ func (c *csv) addCSV(now time.Time, l string) {
 		}
 		if created {
 			// Add CSV column header
-			var h strings.Builder
-			fmt.Fprint(&h, header)
-			for _, f := range fields {
-				fmt.Fprintf(&h, ",%s", f.name)
-				if f.accum {
-					fmt.Fprintf(&h, ",%s-DAILY", f.name)
-				}
-			}
-			fmt.Fprintln(c.writer, h.String())
+			fmt.Fprintln(c.writer, c.headerLine())
 			c.lines++
 		}
 		c.day = now.YearDay()
@@ -174,6 +345,23 @@ func (c *csv) addCSV(now time.Time, l string) {
 	fmt.Fprintf(&b, "OK - file %s, lines %d", c.writer.name, c.lines)
 }
 
+func (c *csv) Start(ctx context.Context) error {
+	return nil
+}
+
+// Stop waits for pending writes, and closes the current file.
+func (c *csv) Stop(ctx context.Context) error {
+	err := core.Wait(ctx, &c.wg)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.writer != nil {
+		c.writer.Close()
+		c.writer = nil
+		c.day = 0
+	}
+	return err
+}
+
 func (c *csv) Status() string {
 	return c.status.Load().(string)
 }
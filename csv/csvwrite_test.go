@@ -0,0 +1,81 @@
+// Copyright 2026 Andrew McRae
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csv
+
+import (
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"testing"
+
+	"github.com/aamcrae/MeterMan/core"
+)
+
+func TestBackfill(t *testing.T) {
+	dir := t.TempDir()
+	c := &csv{d: core.NewDatabase(nil), fpath: dir, interval: time.Minute * 5, fields: []field{{"GEN-P", false}, {"GEN-T", true}}}
+	day := time.Date(2024, 5, 14, 0, 0, 0, 0, time.Local)
+	at := func(h, m int) time.Time {
+		return day.Add(time.Hour*time.Duration(h) + time.Minute*time.Duration(m))
+	}
+	// The export has already written lines when the backfill runs.
+	c.addCSV(at(10, 5), "2024-05-14,10:05,1.5,100,5")
+	c.addCSV(at(10, 10), "2024-05-14,10:10,1.6,100.1,5.1")
+	rec := func(tm time.Time, p, total, daily float64) core.BackfillRecord {
+		return core.BackfillRecord{Time: tm,
+			Values: map[string]float64{"GEN-P": p, "GEN-T": total},
+			Daily:  map[string]float64{"GEN-T": daily}}
+	}
+	c.backfill([]core.BackfillRecord{
+		rec(at(9, 50), 1.1, 99.7, 4.7),
+		rec(at(9, 52), 1.2, 99.75, 4.75), // Not at the CSV interval
+		rec(at(9, 55), 1.3, 99.8, 4.8),
+		rec(at(10, 0), 1.4, 99.9, 4.9),
+		rec(at(10, 5), 9, 999, 99), // Already written by the export
+		{Time: day.Add(-time.Minute * 5), Values: map[string]float64{"GEN-P": 0.5}},
+	})
+	// The export continues to write to the file.
+	c.addCSV(at(10, 15), "2024-05-14,10:15,1.7,100.2,5.2")
+	c.Stop(t.Context())
+	want := []string{
+		"#date,time,GEN-P,GEN-T,GEN-T-DAILY",
+		"2024-05-14,09:50,1.1,99.7,4.7",
+		"2024-05-14,09:55,1.3,99.8,4.8",
+		"2024-05-14,10:00,1.4,99.9,4.9",
+		"2024-05-14,10:05,1.5,100,5",
+		"2024-05-14,10:10,1.6,100.1,5.1",
+		"2024-05-14,10:15,1.7,100.2,5.2",
+	}
+	checkFile(t, path.Join(dir, "2024", "05", "2024-05-14"), want)
+	// A file is created for the previous day.
+	checkFile(t, path.Join(dir, "2024", "05", "2024-05-13"), []string{
+		"#date,time,GEN-P,GEN-T,GEN-T-DAILY",
+		"2024-05-13,23:55,0.5,,",
+	})
+}
+
+func checkFile(t *testing.T, fn string, want []string) {
+	t.Helper()
+	b, err := os.ReadFile(fn)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	got := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("%s:\ngot:\n%s\nwant:\n%s", fn, strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -6,11 +6,15 @@ require (
 	github.com/aamcrae/lcd v0.1.0
 	github.com/aamcrae/statusz v0.0.0-20260605074143-9fe5a40ed1b7
 	github.com/aldas/go-modbus-client v0.5.0
+	github.com/eclipse/paho.mqtt.golang v1.5.0
 	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	github.com/fogleman/gg v1.3.0 // indirect
 	github.com/golang/freetype v0.0.0-20170609003504-e2365dfdc4a0 // indirect
+	github.com/gorilla/websocket v1.5.3 // indirect
 	golang.org/x/image v0.38.0 // indirect
+	golang.org/x/net v0.27.0 // indirect
+	golang.org/x/sync v0.7.0 // indirect
 )
@@ -29,12 +29,14 @@ package hassi
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -52,6 +54,7 @@ type hassi struct {
 	client *http.Client
 	status atomic.Value
 	extra  map[string]string
+	wg     sync.WaitGroup // In-flight requests
 }
 
 // Config structure
@@ -63,7 +66,7 @@ type Hassi struct {
 }
 
 func init() {
-	core.RegisterInit(hassiInit)
+	core.RegisterExternal(moduleName, hassiInit)
 }
 
 func hassiInit(d *core.DB) error {
@@ -88,18 +91,29 @@ func hassiInit(d *core.DB) error {
 	if !d.Dryrun {
 		d.AddExport(intv, 0, h.send)
 	}
-	d.AddStatusPrinter(moduleName, h.Status)
+	if err := d.AddModule(moduleName, h); err != nil {
+		return err
+	}
 	log.Printf("Registered Home Assistant uploader (%d seconds interval)", interval)
 	return nil
 }
 
+func (h *hassi) Start(ctx context.Context) error {
+	return nil
+}
+
+// Stop waits for any in-flight request to complete.
+func (h *hassi) Stop(ctx context.Context) error {
+	return core.Wait(ctx, &h.wg)
+}
+
 // Status returns the current status
 func (h *hassi) Status() string {
 	return h.status.Load().(string)
 }
 
 // Upload any updated tags to Home Assistant.
-func (h *hassi) send(now time.Time) {
+func (h *hassi) send(ctx context.Context, now time.Time) {
 	type blk struct {
 		State string               `json:"state"`
 		Attr  map[string]jsonFloat `json:"attributes"`
@@ -119,17 +133,16 @@ func (h *hassi) send(now time.Time) {
 		} else {
 			b.State = "importing"
 		}
-		gen_p := h.d.GetElement(core.D_GEN_P)
-		consumption := in_p.Get() - out_p.Get()
-		if gen_p != nil && gen_p.Fresh() {
-			consumption += gen_p.Get()
-		}
-		bp := h.d.GetElement(core.G_BATT_POWER)
-		if bp.Fresh() {
-			consumption -= bp.Get()
-		}
-		b.Attr["consumption"] = jsonFloat(consumption)
 	}
+	// The consumption values are derived by the core energy balance.
+	h.add(core.G_CONS_POWER, "consumption", b.Attr)
+	h.daily(core.A_CONS_TOTAL, "consumption", b.Attr)
+	h.add(core.G_SELF_CONSUMPTION, "self_consumption", b.Attr)
+	h.add(core.G_SELF_SUFFICIENCY, "self_sufficiency", b.Attr)
+	h.add(core.G_PV_GRID_POWER, "pv_grid_power", b.Attr)
+	h.daily(core.A_PV_GRID, "pv_grid", b.Attr)
+	h.add(core.G_BATT_LOAD_POWER, "batt_load_power", b.Attr)
+	h.daily(core.A_BATT_LOAD, "batt_load", b.Attr)
 	h.add(core.G_BATT_POWER, "batt_power", b.Attr)
 	h.add(core.G_BATT_SIZE, "batt_size", b.Attr)
 	h.add(core.G_BATT_PERCENT, "batt_percent", b.Attr)
@@ -143,6 +156,11 @@ func (h *hassi) send(now time.Time) {
 	h.daily(core.A_EXPORT, "export", b.Attr)
 	h.daily(core.A_CHARGE_TOTAL, "batt_charge", b.Attr)
 	h.daily(core.A_DISCHARGE_TOTAL, "batt_discharge", b.Attr)
+	h.daily(core.A_IMPORT_COST, "import_cost", b.Attr)
+	h.daily(core.A_EXPORT_CREDIT, "export_credit", b.Attr)
+	h.daily(core.A_NET_COST, "net_cost", b.Attr)
+	h.add(core.G_IMPORT_RATE, "import_rate", b.Attr)
+	h.add(core.G_FEEDIN_RATE, "feedin_rate", b.Attr)
 	for ek, ev := range h.extra {
 		e := h.d.GetElement(ek)
 		if _, ok := e.(core.Acc); ok {
@@ -152,13 +170,13 @@ func (h *hassi) send(now time.Time) {
 		}
 	}
 	// Send request asynchronously.
-	go func() {
+	h.wg.Go(func() {
 		var str strings.Builder
 		defer func() { h.status.Store(str.String()) }()
 		fmt.Fprintf(&str, "%s: ", now.Format("2006-01-02 15:04"))
 		buf := new(bytes.Buffer)
 		json.NewEncoder(buf).Encode(&b)
-		req, err := http.NewRequest("POST", h.url, buf)
+		req, err := http.NewRequestWithContext(ctx, "POST", h.url, buf)
 		if err != nil {
 			log.Printf("NewRequest (%s) failed: %v", h.url, err)
 			fmt.Fprintf(&str, "Error: %v", err)
@@ -185,7 +203,7 @@ func (h *hassi) send(now time.Time) {
 		if h.d.Trace {
 			log.Printf("hassi: Sent url %s, resp %s", h.url, res.Status)
 		}
-	}()
+	})
 }
 
 func (h *hassi) add(tag, attr string, m map[string]jsonFloat) bool {
@@ -202,6 +220,9 @@ func (h *hassi) daily(tag, attr string, m map[string]jsonFloat) {
 	if e != nil && e.Fresh() {
 		m[attr+"_daily"] = jsonFloat(e.Daily())
 		m[attr+"_total"] = jsonFloat(e.Get())
+		m[attr+"_monthly"] = jsonFloat(e.Monthly())
+		m[attr+"_yearly"] = jsonFloat(e.Yearly())
+		m[attr+"_billing"] = jsonFloat(e.Billing())
 	}
 }
 
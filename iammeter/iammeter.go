@@ -32,6 +32,7 @@
 package iammeter
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -62,7 +63,7 @@ type imeter struct {
 
 // Register iamReader as a data source.
 func init() {
-	core.RegisterInit(iamReader)
+	core.RegisterExternal(moduleName, iamReader)
 }
 
 // Set up polling the energy meter, if the config exists for it.
@@ -106,18 +107,18 @@ func (im *imeter) Status() string {
 	return im.status.Load().(string)
 }
 
-func (im *imeter) poll() {
+func (im *imeter) poll(ctx context.Context) {
 	var err error
 	for _ = range retries {
-		err = im.fetch()
-		if err == nil {
+		err = im.fetch(ctx)
+		if err == nil || ctx.Err() != nil {
 			return
 		}
 	}
 	log.Printf("iammeter: %v", err)
 }
 
-func (im *imeter) fetch() error {
+func (im *imeter) fetch(ctx context.Context) error {
 	type Top struct {
 		Method  string    `json:"method"`
 		Mac     string    `json:"mac"`
@@ -129,7 +130,12 @@ func (im *imeter) fetch() error {
 	var b strings.Builder
 	defer func() { im.status.Store(b.String()) }()
 	fmt.Fprintf(&b, "%s: ", time.Now().Format("2006-01-02 15:04"))
-	resp, err := im.client.Get(im.url)
+	req, err := http.NewRequestWithContext(ctx, "GET", im.url, nil)
+	if err != nil {
+		fmt.Fprintf(&b, "NewRequest: %v", err)
+		return err
+	}
+	resp, err := im.client.Do(req)
 	if err != nil {
 		fmt.Fprintf(&b, "Get: %v", err)
 		return err
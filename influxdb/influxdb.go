@@ -0,0 +1,363 @@
+// Copyright 2026 Andrew McRae
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// package influxdb implements a writer that uploads the database
+// elements to an InfluxDB v2 server using the line protocol.
+//
+// The package is configured as a section in the YAML config file:
+//  influxdb:
+//    url: <InfluxDB server URL e.g http://influxdb:8086>
+//    org: <organisation>
+//    bucket: <bucket>
+//    token: <API token>
+//    measurement: <measurement name>
+//    interval: <upload interval in seconds>
+//    buffer: <directory for buffering data when the server is unreachable>
+//    buffersize: <maximum size of the buffered data in Mbytes>
+//
+// Each fresh element is written as a point of the form:
+//  meterman,tag=<tag> value=<value>[,daily=<daily value>] <timestamp>
+
+package influxdb
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aamcrae/MeterMan/core"
+)
+
+type Influxdb struct {
+	Url         string
+	Org         string
+	Bucket      string
+	Token       string
+	Measurement string
+	Interval    int
+	Buffer      string
+	Buffersize  int
+}
+
+const moduleName = "influxdb"
+
+// Name of file used to buffer data when the server is unreachable.
+const spoolFile = "influxdb.pending"
+
+// Maximum number of lines sent in a single request when replaying buffered data.
+const maxLines = 5000
+
+type influx struct {
+	d           *core.DB
+	url         string
+	token       string
+	measurement string
+	spool       string
+	maxSpool    int64 // Maximum size of the buffer file
+	client      *http.Client
+	mu          sync.Mutex     // Serialises writes to the server and buffer
+	wg          sync.WaitGroup // In-flight uploads
+	status      atomic.Value
+}
+
+// errRetry indicates that the request may be retried later.
+var errRetry = errors.New("server unavailable")
+
+func init() {
+	core.RegisterExternal(moduleName, influxInit)
+}
+
+func influxInit(d *core.DB) error {
+	var conf Influxdb
+	dec, ok := d.Config[moduleName]
+	if !ok {
+		return nil
+	}
+	err := dec.Decode(&conf)
+	if err != nil {
+		return err
+	}
+	if len(conf.Url) == 0 || len(conf.Bucket) == 0 {
+		return fmt.Errorf("influxdb: missing url or bucket")
+	}
+	interval := core.ConfigOrDefault(conf.Interval, 60) // Default upload of 60 seconds
+	q := url.Values{}
+	q.Set("org", conf.Org)
+	q.Set("bucket", conf.Bucket)
+	q.Set("precision", "s")
+	w := &influx{
+		d:           d,
+		url:         strings.TrimSuffix(conf.Url, "/") + "/api/v2/write?" + q.Encode(),
+		token:       conf.Token,
+		measurement: core.ConfigOrDefault(conf.Measurement, "meterman"),
+		client:      &http.Client{Timeout: time.Second * 30},
+	}
+	if len(conf.Buffer) != 0 {
+		w.spool = path.Join(conf.Buffer, spoolFile)
+		w.maxSpool = int64(core.ConfigOrDefault(conf.Buffersize, 10)) * 1024 * 1024 // Default of 10 Mbytes
+	}
+	w.status.Store("init")
+	if !d.Dryrun {
+		if len(conf.Buffer) != 0 {
+			if err := os.MkdirAll(conf.Buffer, 0775); err != nil {
+				return err
+			}
+		}
+		d.AddExport(time.Second*time.Duration(interval), 0, w.upload)
+	}
+	if err := d.AddModule(moduleName, w); err != nil {
+		return err
+	}
+	log.Printf("Registered InfluxDB uploader to %s, bucket %s (%d seconds interval)", conf.Url, conf.Bucket, interval)
+	return nil
+}
+
+func (w *influx) Start(ctx context.Context) error {
+	return nil
+}
+
+// Stop waits for any in-flight upload to complete.
+func (w *influx) Stop(ctx context.Context) error {
+	return core.Wait(ctx, &w.wg)
+}
+
+// Status returns the current status
+func (w *influx) Status() string {
+	return w.status.Load().(string)
+}
+
+// upload generates the line protocol for the fresh elements,
+// and sends it asynchronously.
+func (w *influx) upload(ctx context.Context, now time.Time) {
+	m := w.d.GetElements()
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b bytes.Buffer
+	for _, k := range keys {
+		e := m[k]
+		if !e.Fresh() {
+			continue
+		}
+		fmt.Fprintf(&b, "%s,tag=%s value=%s", w.measurement, escape(k), strconv.FormatFloat(e.Get(), 'g', -1, 64))
+		if a, ok := e.(core.Acc); ok {
+			fmt.Fprintf(&b, ",daily=%s", strconv.FormatFloat(a.Daily(), 'g', -1, 64))
+		}
+		fmt.Fprintf(&b, " %d\n", now.Unix())
+	}
+	w.wg.Go(func() {
+		w.send(ctx, now, b.Bytes())
+	})
+}
+
+// send writes the data to the server, first replaying any buffered data.
+// If the server is unavailable, the data is buffered.
+func (w *influx) send(ctx context.Context, now time.Time, data []byte) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var s strings.Builder
+	defer func() { w.status.Store(s.String()) }()
+	fmt.Fprintf(&s, "%s: ", now.Format("2006-01-02 15:04"))
+	replayed, err := w.replay(ctx)
+	if err == nil {
+		if replayed != 0 {
+			fmt.Fprintf(&s, "replayed %d buffered lines, ", replayed)
+		}
+		err = w.write(ctx, data)
+		if err == nil {
+			fmt.Fprintf(&s, "OK")
+			return
+		}
+	}
+	if errors.Is(err, errRetry) && len(w.spool) != 0 {
+		if berr := w.buffer(data); berr != nil {
+			log.Printf("influxdb: buffer: %v", berr)
+			fmt.Fprintf(&s, "Error: %v, buffering failed: %v", err, berr)
+		} else {
+			fmt.Fprintf(&s, "Error: %v, data buffered", err)
+		}
+		return
+	}
+	log.Printf("influxdb: %v", err)
+	fmt.Fprintf(&s, "Error: %v", err)
+}
+
+// write posts the line protocol data to the server.
+// Errors that may succeed if retried later are wrapped with errRetry.
+func (w *influx) write(ctx context.Context, data []byte) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", w.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if len(w.token) != 0 {
+		req.Header.Set("Authorization", "Token "+w.token)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errRetry, err)
+	}
+	defer resp.Body.Close()
+	if w.d.Trace {
+		log.Printf("influxdb: wrote %d bytes, response %s", len(data), resp.Status)
+	}
+	if resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return fmt.Errorf("%w: %s", errRetry, resp.Status)
+	}
+	return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(body))
+}
+
+// buffer appends the data to the buffer file, discarding
+// the oldest data if the file exceeds the maximum size.
+func (w *influx) buffer(data []byte) error {
+	f, err := os.OpenFile(w.spool, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0664)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	var size int64
+	if err == nil {
+		var fi os.FileInfo
+		if fi, err = f.Stat(); err == nil {
+			size = fi.Size()
+		}
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil || size <= w.maxSpool {
+		return err
+	}
+	return w.trim()
+}
+
+// trim discards the oldest lines of the buffer file so that it does not exceed the maximum size.
+func (w *influx) trim() error {
+	b, err := os.ReadFile(w.spool)
+	if err != nil || int64(len(b)) <= w.maxSpool {
+		return err
+	}
+	// Keep the most recent data, starting at a line boundary.
+	cut := len(b) - int(w.maxSpool)
+	if i := bytes.IndexByte(b[cut-1:], '\n'); i >= 0 {
+		cut += i
+	} else {
+		cut = len(b)
+	}
+	log.Printf("influxdb: buffer full, discarding %d oldest lines", bytes.Count(b[:cut], []byte("\n")))
+	return w.keep(b[cut:], bytes.NewReader(nil))
+}
+
+// replay sends any buffered data to the server, returning the number of lines sent.
+// If only some of the data can be sent, the remaining data is kept in the buffer file.
+// Data rejected by the server (as opposed to the server being unavailable) is discarded.
+func (w *influx) replay(ctx context.Context) (int, error) {
+	if len(w.spool) == 0 {
+		return 0, nil
+	}
+	f, err := os.Open(w.spool)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	var sent, discarded int
+	for {
+		var chunk bytes.Buffer
+		var lines int
+		for lines < maxLines {
+			l, err := r.ReadBytes('\n')
+			chunk.Write(l)
+			if len(l) != 0 {
+				lines++
+			}
+			if err != nil {
+				break
+			}
+		}
+		if lines == 0 {
+			break
+		}
+		err := w.write(ctx, chunk.Bytes())
+		if errors.Is(err, errRetry) {
+			// Keep the unsent data for the next attempt.
+			if sent != 0 || discarded != 0 {
+				if kerr := w.keep(chunk.Bytes(), r); kerr != nil {
+					return sent, kerr
+				}
+			}
+			return sent, err
+		} else if err != nil {
+			log.Printf("influxdb: discarding %d buffered lines: %v", lines, err)
+			discarded += lines
+		} else {
+			sent += lines
+		}
+	}
+	if w.d.Trace {
+		log.Printf("influxdb: replayed %d buffered lines", sent)
+	}
+	return sent, os.Remove(w.spool)
+}
+
+// keep rewrites the buffer file with the data not yet sent.
+func (w *influx) keep(chunk []byte, rest io.Reader) error {
+	tmp := w.spool + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(chunk)
+	if err == nil {
+		_, err = io.Copy(f, rest)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, w.spool)
+}
+
+var tagEscaper = strings.NewReplacer(",", "\\,", "=", "\\=", " ", "\\ ")
+
+// escape escapes a line protocol tag value.
+func escape(s string) string {
+	return tagEscaper.Replace(s)
+}
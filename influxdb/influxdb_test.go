@@ -0,0 +1,124 @@
+// Copyright 2026 Andrew McRae
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package influxdb
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"testing"
+
+	"github.com/aamcrae/MeterMan/core"
+)
+
+func TestEscape(t *testing.T) {
+	for _, v := range []struct {
+		tag  string
+		want string
+	}{
+		{"GEN-P/0", "GEN-P/0"},
+		{"a b", "a\\ b"},
+		{"a,b=c", "a\\,b\\=c"},
+		{"", ""},
+	} {
+		if got := escape(v.tag); got != v.want {
+			t.Errorf("escape(%q): got %q want %q", v.tag, got, v.want)
+		}
+	}
+}
+
+// spoolLines returns n buffered lines, numbered from start.
+func spoolLines(start, n int) string {
+	var b strings.Builder
+	for i := range n {
+		fmt.Fprintf(&b, "meterman,tag=T value=%d %d\n", start+i, 1715659200+start+i)
+	}
+	return b.String()
+}
+
+func TestReplay(t *testing.T) {
+	total := maxLines*2 + 10
+	all := spoolLines(0, total)
+	for _, v := range []struct {
+		name   string
+		status []int  // Response status of each request
+		sent   int    // Lines sent
+		err    bool   // Replay returns an error
+		kept   string // Buffer file contents after the replay
+	}{
+		{"all sent", []int{204, 204, 204}, total, false, ""},
+		{"unavailable", []int{503}, 0, true, all},
+		{"partly sent", []int{204, 503}, maxLines, true, spoolLines(maxLines, total-maxLines)},
+		{"discarded", []int{400, 503}, 0, true, spoolLines(maxLines, total-maxLines)},
+		{"discarded and sent", []int{204, 400, 204}, maxLines + 10, false, ""},
+	} {
+		var req int
+		var got strings.Builder
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			b, _ := io.ReadAll(r.Body)
+			st := v.status[min(req, len(v.status)-1)]
+			req++
+			if st == 204 {
+				got.Write(b)
+			}
+			w.WriteHeader(st)
+		}))
+		w := &influx{d: core.NewDatabase(nil), url: srv.URL, client: srv.Client(), spool: filepath.Join(t.TempDir(), spoolFile)}
+		if err := os.WriteFile(w.spool, []byte(all), 0644); err != nil {
+			t.Fatalf("%s: %v", w.spool, err)
+		}
+		sent, err := w.replay(context.Background())
+		srv.Close()
+		if sent != v.sent || (err != nil) != v.err {
+			t.Errorf("%s: sent %d, err %v, want %d", v.name, sent, err, v.sent)
+		}
+		if n := strings.Count(got.String(), "\n"); n != v.sent {
+			t.Errorf("%s: server received %d lines, want %d", v.name, n, v.sent)
+		}
+		b, err := os.ReadFile(w.spool)
+		if len(v.kept) == 0 {
+			if err == nil {
+				t.Errorf("%s: buffer file not removed", v.name)
+			}
+		} else if string(b) != v.kept {
+			t.Errorf("%s: kept %d lines, want %d", v.name, strings.Count(string(b), "\n"), strings.Count(v.kept, "\n"))
+		}
+	}
+}
+
+func TestBuffer(t *testing.T) {
+	line := len(spoolLines(0, 1))
+	w := &influx{d: core.NewDatabase(nil), spool: filepath.Join(t.TempDir(), spoolFile), maxSpool: int64(line*10 + 5)}
+	// Data is appended until the maximum size is exceeded.
+	if err := w.buffer([]byte(spoolLines(0, 10))); err != nil {
+		t.Fatalf("buffer: %v", err)
+	}
+	if b, _ := os.ReadFile(w.spool); string(b) != spoolLines(0, 10) {
+		t.Errorf("buffer: got %q", b)
+	}
+	// The oldest lines are discarded, keeping whole lines.
+	if err := w.buffer([]byte(spoolLines(10, 3))); err != nil {
+		t.Fatalf("buffer: %v", err)
+	}
+	if b, _ := os.ReadFile(w.spool); string(b) != spoolLines(3, 10) {
+		t.Errorf("trim: got %q want %q", b, spoolLines(3, 10))
+	}
+}
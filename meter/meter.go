@@ -44,9 +44,12 @@
 package meter
 
 import (
+	"context"
+	"fmt"
 	"image"
 	"log"
 	"net/http"
+	"sync/atomic"
 	"time"
 
 	"github.com/aamcrae/MeterMan/core"
@@ -83,9 +86,17 @@ var tagMap map[string][]string = map[string][]string{
 	"1NL2": {core.A_EXPORT + "/1"},
 }
 
+// meter is the module that reads the meter.
+type meter struct {
+	d      *core.DB
+	r      *Reader
+	conf   *MeterConfig
+	status atomic.Value
+}
+
 // Register meterReader as a data source.
 func init() {
-	core.RegisterInit(meterReader)
+	core.RegisterExternal("meter", meterReader)
 }
 
 // Create an instance of a meter reader, if there is
@@ -113,15 +124,34 @@ func meterReader(d *core.DB) error {
 	d.AddSubAccum(core.A_EXPORT, true)
 	d.AddSubAccum(core.A_EXPORT, true)
 	log.Printf("Registered meter LCD reader (%d digits)\n", len(conf.Digit))
-	if !d.Dryrun {
-		go runReader(d, r, &conf)
-	}
+	m := &meter{d: d, r: r, conf: &conf}
+	m.status.Store("init")
+	return d.AddModule("meter", m)
+}
+
+func (m *meter) Start(ctx context.Context) error {
+	go m.runReader(ctx)
+	return nil
+}
+
+func (m *meter) Stop(ctx context.Context) error {
 	return nil
 }
 
+func (m *meter) Status() string {
+	return m.status.Load().(string)
+}
+
+// setStatus sets the current status.
+func (m *meter) setStatus(format string, args ...any) {
+	m.status.Store(fmt.Sprintf("%s: %s", time.Now().Format("2006-01-02 15:04"), fmt.Sprintf(format, args...)))
+}
+
 // runReader is a loop that reads the image of the meter panel
-// from an image source, and decodes the LCD digits.
-func runReader(d *core.DB, r *Reader, conf *MeterConfig) {
+// from an image source, and decodes the LCD digits, until the
+// context is cancelled.
+func (m *meter) runReader(ctx context.Context) {
+	d, r, conf := m.d, m.r, m.conf
 	delay := time.Millisecond * time.Duration(core.ConfigOrDefault(conf.Sample, 4900))            // Sample rate in milliseconds
 	timeout := time.Second * time.Duration(core.ConfigOrDefault(time.Duration(conf.Timeout), 20)) // Timeout in seconds
 	lastTime := time.Now()
@@ -129,17 +159,26 @@ func runReader(d *core.DB, r *Reader, conf *MeterConfig) {
 		Timeout: timeout,
 	}
 	for {
-		time.Sleep(delay - time.Now().Sub(lastTime))
+		if !core.Sleep(ctx, delay-time.Now().Sub(lastTime)) {
+			return
+		}
 		lastTime = time.Now()
-		res, err := client.Get(conf.Source)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, conf.Source, nil)
+		if err != nil {
+			m.setStatus("Error - %v", err)
+			continue
+		}
+		res, err := client.Do(req)
 		if err != nil {
 			log.Printf("Failed to retrieve source image from %s: %v", conf.Source, err)
+			m.setStatus("Error - %v", err)
 			continue
 		}
 		img, _, err := image.Decode(res.Body)
 		res.Body.Close()
 		if err != nil {
 			log.Printf("Failed to decode image from %s: %v", conf.Source, err)
+			m.setStatus("Error - %v", err)
 			continue
 		}
 		if d.Trace {
@@ -151,6 +190,7 @@ func runReader(d *core.DB, r *Reader, conf *MeterConfig) {
 		// Decode the digits and get the label and value.
 		label, val, err := r.Read(img)
 		if err != nil {
+			m.setStatus("Read error - %v", err)
 			if d.Trace {
 				log.Printf("Read error: %v", err)
 			}
@@ -166,6 +206,7 @@ func runReader(d *core.DB, r *Reader, conf *MeterConfig) {
 				for _, tag := range tags {
 					d.Input(tag, val)
 				}
+				m.setStatus("OK - %s %s", label, core.FmtFloat(val))
 			}
 		}
 		// If required, recalibrate the reader.
@@ -27,11 +27,17 @@ import (
 	_ "github.com/aamcrae/MeterMan/csv"
 	_ "github.com/aamcrae/MeterMan/hassi"
 	_ "github.com/aamcrae/MeterMan/iammeter"
+	_ "github.com/aamcrae/MeterMan/influxdb"
 	_ "github.com/aamcrae/MeterMan/meter"
+	_ "github.com/aamcrae/MeterMan/modbus"
+	_ "github.com/aamcrae/MeterMan/mqtt"
 	_ "github.com/aamcrae/MeterMan/pv"
 	_ "github.com/aamcrae/MeterMan/server"
 	_ "github.com/aamcrae/MeterMan/sigenergy"
 	_ "github.com/aamcrae/MeterMan/sma"
+	_ "github.com/aamcrae/MeterMan/smaem"
+	_ "github.com/aamcrae/MeterMan/sunspec"
+	_ "github.com/aamcrae/MeterMan/tariff"
 	_ "github.com/aamcrae/MeterMan/weather"
 	"github.com/aamcrae/statusz"
 )
@@ -43,6 +49,7 @@ var logDate = flag.Bool("logtime", false, "Log date and time")
 var verbose = flag.Bool("verbose", false, "Verbose tracing")
 var dryrun = flag.Bool("dryrun", false, "Validate config only")
 var disable = flag.String("disable", "", "Disable features")
+var replay = flag.String("replay", "", "Replay the recorded inputs from this file")
 
 func main() {
 	flag.Parse()
@@ -61,11 +68,14 @@ func main() {
 		}()
 	}
 	d := core.NewDatabase(conf)
+	d.ConfigFile = *configFile
 	d.Trace = *verbose
 	d.Dryrun = *dryrun
+	d.Replay = *replay
 	for feat := range strings.SplitSeq(*disable, ",") {
 		d.Disable(feat)
 	}
-	err = d.Start()
-	log.Fatalf("Initialisation error: %v", err)
+	if err := d.Start(); err != nil {
+		log.Fatalf("Initialisation error: %v", err)
+	}
 }
@@ -0,0 +1,202 @@
+// Copyright 2026 Andrew McRae
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modbus
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/aldas/go-modbus-client"
+)
+
+// fieldTypes maps the configured register type to the modbus field type.
+var fieldTypes = map[string]modbus.FieldType{
+	"int16":   modbus.FieldTypeInt16,
+	"uint16":  modbus.FieldTypeUint16,
+	"int32":   modbus.FieldTypeInt32,
+	"uint32":  modbus.FieldTypeUint32,
+	"int64":   modbus.FieldTypeInt64,
+	"uint64":  modbus.FieldTypeUint64,
+	"float32": modbus.FieldTypeFloat32,
+	"float64": modbus.FieldTypeFloat64,
+}
+
+// register is a single register to be read from the device.
+type register struct {
+	tag          string  // Database tag
+	divisor      float64 // Divisor applied to the raw value
+	lowWordFirst bool    // Multi-word values have the low word first
+}
+
+// Device reads a set of registers from a Modbus TCP device.
+type Device struct {
+	Timeout time.Duration // Timeout
+	Trace   bool
+	addr    string
+
+	requests  []modbus.BuilderRequest
+	client    *modbus.Client
+	registers []register
+	Values    []float64 // Values from the last poll, indexed as the registers
+}
+
+// NewDevice creates a device that reads the registers listed.
+func NewDevice(addr string, unit uint8, regs []Register) (*Device, error) {
+	dev := &Device{
+		Timeout:   time.Second * 10,
+		addr:      addr,
+		client:    modbus.NewTCPClient(),
+		registers: make([]register, len(regs)),
+		Values:    make([]float64, len(regs)),
+	}
+	input := modbus.NewRequestBuilder(addr, unit)
+	holding := modbus.NewRequestBuilder(addr, unit)
+	var nInput, nHolding int
+	for i, r := range regs {
+		ft, ok := fieldTypes[r.Type]
+		if !ok {
+			return nil, fmt.Errorf("%s: register %d: unknown type '%s'", addr, r.Address, r.Type)
+		}
+		dev.registers[i] = register{tag: r.Tag, divisor: r.Divisor}
+		if dev.registers[i].divisor == 0 {
+			dev.registers[i].divisor = 1.0
+		}
+		switch r.Order {
+		case "", "high":
+		case "low":
+			dev.registers[i].lowWordFirst = true
+		default:
+			return nil, fmt.Errorf("%s: register %d: unknown word order '%s'", addr, r.Address, r.Order)
+		}
+		// The field name is the index of the register.
+		f := modbus.Field{Name: strconv.Itoa(i), Type: ft, Address: r.Address}
+		switch r.Function {
+		case "", "holding":
+			holding.AddField(f)
+			nHolding++
+		case "input":
+			input.AddField(f)
+			nInput++
+		default:
+			return nil, fmt.Errorf("%s: register %d: unknown function '%s'", addr, r.Address, r.Function)
+		}
+	}
+	if nHolding != 0 {
+		reqs, err := holding.ReadHoldingRegistersTCP()
+		if err != nil {
+			return nil, err
+		}
+		dev.requests = append(dev.requests, reqs...)
+	}
+	if nInput != 0 {
+		reqs, err := input.ReadInputRegistersTCP()
+		if err != nil {
+			return nil, err
+		}
+		dev.requests = append(dev.requests, reqs...)
+	}
+	return dev, nil
+}
+
+// Poll reads all the registers from the device, storing the scaled values.
+func (dev *Device) Poll() error {
+	ctx, cancel := context.WithTimeout(context.Background(), dev.Timeout)
+	defer cancel()
+	if err := dev.client.Connect(ctx, dev.addr); err != nil {
+		return fmt.Errorf("connect to %s: %w", dev.addr, err)
+	}
+	defer dev.client.Close()
+	for _, req := range dev.requests {
+		resp, err := dev.client.Do(ctx, req)
+		if err != nil {
+			return fmt.Errorf("req failed: %w", err)
+		}
+		results, err := req.ExtractFields(resp, true)
+		if err != nil {
+			return fmt.Errorf("extract fields: %w", err)
+		}
+		for _, f := range results {
+			if f.Error != nil {
+				return fmt.Errorf("register %d: %w", f.Field.Address, f.Error)
+			}
+			i, err := strconv.Atoi(f.Field.Name)
+			if err != nil || i < 0 || i >= len(dev.registers) {
+				return fmt.Errorf("unknown field name: %s", f.Field.Name)
+			}
+			r := &dev.registers[i]
+			v, err := getValue(f.Value, r.lowWordFirst)
+			if err != nil {
+				return fmt.Errorf("register %d: %w", f.Field.Address, err)
+			}
+			dev.Values[i] = v / r.divisor
+		}
+	}
+	return nil
+}
+
+// getValue converts the field value to a float64, swapping
+// the word order of multi-word values if required.
+func getValue(value any, lowWordFirst bool) (float64, error) {
+	switch v := value.(type) {
+	case int16:
+		return float64(v), nil
+	case uint16:
+		return float64(v), nil
+	case int32:
+		if lowWordFirst {
+			v = int32(swap32(uint32(v)))
+		}
+		return float64(v), nil
+	case uint32:
+		if lowWordFirst {
+			v = swap32(v)
+		}
+		return float64(v), nil
+	case int64:
+		if lowWordFirst {
+			v = int64(swap64(uint64(v)))
+		}
+		return float64(v), nil
+	case uint64:
+		if lowWordFirst {
+			v = swap64(v)
+		}
+		return float64(v), nil
+	case float32:
+		if lowWordFirst {
+			v = math.Float32frombits(swap32(math.Float32bits(v)))
+		}
+		return float64(v), nil
+	case float64:
+		if lowWordFirst {
+			v = math.Float64frombits(swap64(math.Float64bits(v)))
+		}
+		return v, nil
+	}
+	return 0, fmt.Errorf("unhandled value type %T", value)
+}
+
+// swap32 swaps the 16 bit words of a 32 bit value.
+func swap32(v uint32) uint32 {
+	return v<<16 | v>>16
+}
+
+// swap64 reverses the order of the 16 bit words of a 64 bit value.
+func swap64(v uint64) uint64 {
+	return v<<48 | (v<<16)&0xFFFF00000000 | (v>>16)&0xFFFF0000 | v>>48
+}
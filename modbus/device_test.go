@@ -0,0 +1,62 @@
+// Copyright 2026 Andrew McRae
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modbus
+
+import (
+	"math"
+
+	"testing"
+)
+
+func TestSwap(t *testing.T) {
+	if got := swap32(0x11223344); got != 0x33441122 {
+		t.Errorf("swap32: got %#x want %#x", got, 0x33441122)
+	}
+	if got := swap64(0x1122334455667788); got != 0x7788556633441122 {
+		t.Errorf("swap64: got %#x want %#x", got, uint64(0x7788556633441122))
+	}
+}
+
+func TestGetValue(t *testing.T) {
+	for _, v := range []struct {
+		value        any
+		lowWordFirst bool
+		want         float64
+	}{
+		{int16(-5), false, -5},
+		{int16(-5), true, -5},
+		{uint16(65535), true, 65535},
+		{int32(0x00010002), false, 65538},
+		{int32(0x00010002), true, 131073},
+		{int32(-65537), true, -2}, // 0xFFFEFFFF
+		{uint32(0x00010002), true, 131073},
+		{int64(1 << 48), true, 1},
+		{int64(-281474976710657), true, -2}, // 0xFFFEFFFFFFFFFFFF
+		{uint64(1), true, 1 << 48},
+		{uint64(1), false, 1},
+		{math.Float32frombits(0x00003FC0), true, 1.5},
+		{float32(1.5), false, 1.5},
+		{math.Float64frombits(0x3FF8), true, 1.5},
+		{float64(1.5), false, 1.5},
+	} {
+		got, err := getValue(v.value, v.lowWordFirst)
+		if err != nil || got != v.want {
+			t.Errorf("getValue(%T %v, %v): got %g, %v want %g", v.value, v.value, v.lowWordFirst, got, err, v.want)
+		}
+	}
+	if _, err := getValue("1", false); err == nil {
+		t.Errorf("getValue(string): no error")
+	}
+}
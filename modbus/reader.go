@@ -0,0 +1,226 @@
+// Copyright 2026 Andrew McRae
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// package modbus implements a generic reader for Modbus TCP devices,
+// with the registers to be read described in the configuration.
+//
+// The package is configured as a section in the YAML config file:
+//  modbus:
+//    - name: <device name>
+//      addr: <host:port>
+//      unit: <modbus unit id>
+//      poll: <poll interval in seconds>
+//      timeout: <timeout in seconds>
+//      trace: <true/false>
+//      registers:
+//        - address: <register address>
+//          type: <int16, uint16, int32, uint32, int64, uint64, float32, float64>
+//          function: <holding or input>
+//          divisor: <divisor applied to value>
+//          order: <word order, high or low>
+//          tag: <database tag>
+//          element: <gauge, accum or sub-gauge>
+//        ...
+//    ...
+
+package modbus
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aamcrae/MeterMan/core"
+)
+
+const retries = 3
+
+type Modbus []struct {
+	Name      string
+	Addr      string
+	Unit      int
+	Poll      int
+	Timeout   int
+	Trace     bool
+	Registers []Register
+}
+
+// Register describes a register and the database element it is stored in.
+type Register struct {
+	Address    uint16
+	Type       string  // Register type e.g int16, uint32, float32
+	Function   string  // holding (default) or input registers
+	Divisor    float64 // Value is divided by this, default 1
+	Order      string  // Word order, high (default) or low word first
+	Tag        string  // Database tag, or base tag for sub-gauges
+	Element    string  // Element type: gauge, accum or sub-gauge
+	Resettable bool    // Accumulator may be reset
+	Average    bool    // Sub-gauges are averaged rather than summed
+}
+
+// ModbusReader polls a Modbus device.
+type ModbusReader struct {
+	d        *core.DB           // Database
+	name     string             // Device name
+	dev      *Device            // Device object
+	tags     []string           // Tags for each register
+	interval time.Duration      // Independent poll interval, if set
+	status   atomic.Value       // Current status
+	cancel   context.CancelFunc // Cancels the independent polling
+	wg       sync.WaitGroup     // Independent polling goroutine
+}
+
+func init() {
+	core.RegisterExternal("modbus", modbusReader)
+}
+
+// Initialise Modbus reader(s).
+func modbusReader(d *core.DB) error {
+	var conf Modbus
+	c, ok := d.Config["modbus"]
+	if !ok {
+		return nil
+	}
+	err := c.Decode(&conf)
+	if err != nil {
+		return err
+	}
+	added := make(map[string]struct{})
+	for _, e := range conf {
+		if len(e.Addr) == 0 {
+			return fmt.Errorf("modbus: missing device address")
+		}
+		name := core.ConfigOrDefault(e.Name, strings.Split(e.Addr, ":")[0])
+		unit := uint8(core.ConfigOrDefault(e.Unit, 1)) // Default unit id of 1
+		dev, err := NewDevice(e.Addr, unit, e.Registers)
+		if err != nil {
+			return err
+		}
+		dev.Timeout = core.ConfigOrDefault(time.Second*time.Duration(e.Timeout), dev.Timeout)
+		dev.Trace = e.Trace
+		m := &ModbusReader{d: d, name: name, dev: dev, interval: time.Second * time.Duration(e.Poll)}
+		// Allocate the elements for the registers.
+		for _, r := range e.Registers {
+			if len(r.Tag) == 0 {
+				return fmt.Errorf("modbus: %s: register %d: missing tag", name, r.Address)
+			}
+			tag := r.Tag
+			switch r.Element {
+			case "gauge", "accum":
+				if _, ok := added[r.Tag]; ok {
+					return fmt.Errorf("modbus: %s: duplicate tag %s", name, r.Tag)
+				}
+				added[r.Tag] = struct{}{}
+				if r.Element == "gauge" {
+					d.AddGauge(r.Tag)
+				} else {
+					d.AddAccum(r.Tag, r.Resettable)
+				}
+			case "sub-gauge":
+				tag = d.AddSubGauge(r.Tag, r.Average)
+			default:
+				return fmt.Errorf("modbus: %s: register %d: unknown element type '%s'", name, r.Address, r.Element)
+			}
+			m.tags = append(m.tags, tag)
+		}
+		m.status.Store("init")
+		if e.Poll != 0 {
+			log.Printf("Registered Modbus reader for %s (%s), %d registers, polling every %d seconds\n", name, e.Addr, len(m.tags), e.Poll)
+		} else {
+			log.Printf("Registered Modbus reader for %s (%s), %d registers\n", name, e.Addr, len(m.tags))
+		}
+		// If a poll interval is set, the module polls independently,
+		// otherwise poll before the exports are run.
+		if !d.Dryrun && e.Poll == 0 {
+			d.AddPoll(m.cbPoll)
+		}
+		if err := d.AddModule(fmt.Sprintf("Modbus-%s", name), m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Status returns a string status for this device
+func (m *ModbusReader) Status() string {
+	return m.status.Load().(string)
+}
+
+// Start starts polling the device if a poll interval is set.
+func (m *ModbusReader) Start(ctx context.Context) error {
+	if m.interval != 0 {
+		ctx, m.cancel = context.WithCancel(ctx)
+		m.wg.Go(func() {
+			m.reader(ctx)
+		})
+	}
+	return nil
+}
+
+// Stop cancels the independent polling, and waits for any poll in progress to complete.
+func (m *ModbusReader) Stop(ctx context.Context) error {
+	if m.cancel != nil {
+		m.cancel()
+	}
+	return core.Wait(ctx, &m.wg)
+}
+
+// reader polls the device at the selected interval until the context is cancelled.
+func (m *ModbusReader) reader(ctx context.Context) {
+	for {
+		m.cbPoll(ctx)
+		if !core.Sleep(ctx, m.interval) {
+			return
+		}
+	}
+}
+
+func (m *ModbusReader) cbPoll(ctx context.Context) {
+	var err error
+	for range retries {
+		err = m.poll()
+		if err == nil || ctx.Err() != nil {
+			return
+		}
+	}
+	log.Printf("Modbus poll error: %s - %v", m.name, err)
+}
+
+func (m *ModbusReader) poll() error {
+	if m.d.Trace {
+		log.Printf("Polling modbus device %s", m.name)
+	}
+	var b strings.Builder
+	defer func() { m.status.Store(b.String()) }()
+	fmt.Fprintf(&b, "%s: ", time.Now().Format("2006-01-02 15:04"))
+	err := m.dev.Poll()
+	if err != nil {
+		fmt.Fprintf(&b, "Error - %v", err)
+		return err
+	}
+	fmt.Fprintf(&b, "OK")
+	for i, tag := range m.tags {
+		v := m.dev.Values[i]
+		if m.dev.Trace {
+			log.Printf("modbus: %s: tag %s = %g", m.name, tag, v)
+		}
+		m.d.Input(tag, v)
+		fmt.Fprintf(&b, ", %s %s", tag, core.FmtFloat(v))
+	}
+	return nil
+}
@@ -0,0 +1,384 @@
+// Copyright 2026 Andrew McRae
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// package mqtt implements a writer that publishes the database elements
+// to a MQTT broker, and a reader that subscribes to topics to
+// provide values to the database.
+//
+// The package is configured as a section in the YAML config file:
+//  mqtt:
+//    broker: <broker URL e.g tcp://mosquitto:1883>
+//    clientid: <client ID>
+//    username: <user name>
+//    password: <password>
+//    prefix: <topic prefix>
+//    interval: <publish interval in seconds>
+//    qos: <QoS level>
+//    discovery: <true/false>
+//    discoveryprefix: <Home Assistant discovery prefix>
+//    currency: <currency code for cost values e.g AUD>
+//    subscribe:
+//      - topic: <topic>
+//        path: <optional JSON path to value>
+//        scale: <optional multiplier>
+//        tag: <database tag>
+//        type: <gauge, accum, sub-gauge>
+//      ...
+//
+// Each fresh element is published as a retained JSON message to the
+// topic <prefix>/<tag> e.g meterman/GEN-P
+
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aamcrae/MeterMan/core"
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+type Mqtt struct {
+	Broker          string
+	Clientid        string
+	Username        string
+	Password        string
+	Prefix          string
+	Interval        int
+	Qos             int
+	Discovery       bool
+	Discoveryprefix string
+	Currency        string
+	Subscribe       []Subscription
+}
+
+const moduleName = "mqtt"
+
+const publishTimeout = time.Second * 10
+
+type mqtt struct {
+	d          *core.DB
+	client     paho.Client
+	prefix     string
+	qos        byte
+	discovery  bool
+	dPrefix    string
+	currency   string
+	node       string
+	announced  map[string]struct{} // Tags that have discovery config published
+	rediscover atomic.Bool         // Set on (re)connection to republish discovery config
+	subs       []*subscriber       // Subscribed topics
+	status     atomic.Value
+	wg         sync.WaitGroup // In-flight publishing
+}
+
+// message is a single MQTT message to be published.
+type message struct {
+	topic   string
+	payload []byte
+}
+
+// Payload is the JSON published for each element.
+type Payload struct {
+	Value     float64  `json:"value"`
+	Daily     *float64 `json:"daily,omitempty"`
+	Timestamp int64    `json:"timestamp"`
+}
+
+func init() {
+	core.RegisterExternal(moduleName, mqttInit)
+}
+
+func mqttInit(d *core.DB) error {
+	var conf Mqtt
+	dec, ok := d.Config[moduleName]
+	if !ok {
+		return nil
+	}
+	err := dec.Decode(&conf)
+	if err != nil {
+		return err
+	}
+	if len(conf.Broker) == 0 {
+		return fmt.Errorf("mqtt: missing broker")
+	}
+	if conf.Qos < 0 || conf.Qos > 2 {
+		return fmt.Errorf("mqtt: invalid QoS %d", conf.Qos)
+	}
+	interval := core.ConfigOrDefault(conf.Interval, 60) // Default publish interval of 60 seconds
+	m := &mqtt{
+		d:         d,
+		prefix:    core.ConfigOrDefault(conf.Prefix, "meterman"),
+		qos:       byte(conf.Qos),
+		discovery: conf.Discovery,
+		dPrefix:   core.ConfigOrDefault(conf.Discoveryprefix, "homeassistant"),
+		currency:  conf.Currency,
+		node:      core.ConfigOrDefault(conf.Clientid, "meterman"),
+		announced: make(map[string]struct{}),
+	}
+	m.status.Store("init")
+	if err := m.addSubscriptions(conf.Subscribe); err != nil {
+		return err
+	}
+	opts := paho.NewClientOptions()
+	opts.AddBroker(conf.Broker)
+	opts.SetClientID(m.node)
+	opts.SetUsername(conf.Username)
+	opts.SetPassword(conf.Password)
+	opts.SetAutoReconnect(true)
+	opts.SetConnectRetry(true)
+	opts.SetWill(m.availability(), "offline", m.qos, true)
+	opts.SetOnConnectHandler(m.connected)
+	opts.SetConnectionLostHandler(func(c paho.Client, err error) {
+		log.Printf("mqtt: connection to %s lost: %v", conf.Broker, err)
+		m.status.Store(fmt.Sprintf("%s: connection lost: %v", time.Now().Format("2006-01-02 15:04"), err))
+	})
+	m.client = paho.NewClient(opts)
+	if !d.Dryrun {
+		d.AddExport(time.Second*time.Duration(interval), 0, m.publish)
+	}
+	if err := d.AddModule(moduleName, m); err != nil {
+		return err
+	}
+	log.Printf("Registered MQTT publisher to %s, topic prefix %s (%d seconds interval)", conf.Broker, m.prefix, interval)
+	return nil
+}
+
+// Start connects to the broker.
+func (m *mqtt) Start(ctx context.Context) error {
+	// Connection is retried in the background until successful.
+	m.client.Connect()
+	return nil
+}
+
+// Stop waits for publishing to complete, marks MeterMan as offline
+// and disconnects from the broker.
+func (m *mqtt) Stop(ctx context.Context) error {
+	err := core.Wait(ctx, &m.wg)
+	if m.client.IsConnectionOpen() {
+		m.client.Publish(m.availability(), m.qos, true, "offline").WaitTimeout(publishTimeout)
+	}
+	m.client.Disconnect(250)
+	return err
+}
+
+// Status returns the current status
+func (m *mqtt) Status() string {
+	return m.status.Load().(string)
+}
+
+// connected is called by the client when a connection is (re)established.
+func (m *mqtt) connected(c paho.Client) {
+	log.Printf("mqtt: connected to broker")
+	c.Publish(m.availability(), m.qos, true, "online")
+	m.rediscover.Store(true)
+	m.subscribe(c)
+}
+
+// availability returns the topic used to indicate whether MeterMan is online.
+func (m *mqtt) availability() string {
+	return m.prefix + "/status"
+}
+
+// publish sends the fresh elements to the broker.
+// This is called from the main thread, so the messages are prepared here
+// and sent from a separate goroutine.
+func (m *mqtt) publish(ctx context.Context, now time.Time) {
+	if m.rediscover.Swap(false) {
+		m.announced = make(map[string]struct{})
+	}
+	var msgs []message
+	for tag, e := range m.d.GetElements() {
+		if !e.Fresh() {
+			continue
+		}
+		p := Payload{Value: e.Get(), Timestamp: e.Timestamp().Unix()}
+		acc, isAcc := e.(core.Acc)
+		if isAcc {
+			daily := acc.Daily()
+			p.Daily = &daily
+		}
+		b, err := json.Marshal(&p)
+		if err != nil {
+			log.Printf("mqtt: %s: marshal: %v", tag, err)
+			continue
+		}
+		msgs = append(msgs, message{m.prefix + "/" + tag, b})
+		if _, ok := m.announced[tag]; m.discovery && !ok {
+			msgs = append(msgs, m.discoveryConfig(tag, isAcc)...)
+			m.announced[tag] = struct{}{}
+		}
+	}
+	m.wg.Go(func() {
+		m.send(ctx, now, msgs)
+	})
+}
+
+// send publishes the messages and waits for completion.
+func (m *mqtt) send(ctx context.Context, now time.Time, msgs []message) {
+	var b strings.Builder
+	defer func() { m.status.Store(b.String()) }()
+	fmt.Fprintf(&b, "%s: ", now.Format("2006-01-02 15:04"))
+	if !m.client.IsConnectionOpen() {
+		fmt.Fprintf(&b, "Not connected")
+		return
+	}
+	var errors int
+	for _, msg := range msgs {
+		if ctx.Err() != nil {
+			fmt.Fprintf(&b, "Stopped")
+			return
+		}
+		t := m.client.Publish(msg.topic, m.qos, true, msg.payload)
+		if !t.WaitTimeout(publishTimeout) {
+			errors++
+			if m.d.Trace {
+				log.Printf("mqtt: %s: publish timeout", msg.topic)
+			}
+		} else if err := t.Error(); err != nil {
+			errors++
+			log.Printf("mqtt: %s: %v", msg.topic, err)
+		}
+	}
+	if m.d.Trace {
+		log.Printf("mqtt: published %d messages, %d errors", len(msgs), errors)
+	}
+	if errors != 0 {
+		fmt.Fprintf(&b, "%d of %d messages failed", errors, len(msgs))
+	} else {
+		fmt.Fprintf(&b, "OK - %d messages", len(msgs))
+	}
+}
+
+// discoveryConfig generates the Home Assistant MQTT discovery messages for a tag.
+// Accumulators have an additional sensor for the daily value.
+func (m *mqtt) discoveryConfig(tag string, acc bool) []message {
+	type device struct {
+		Identifiers  []string `json:"identifiers"`
+		Name         string   `json:"name"`
+		Manufacturer string   `json:"manufacturer"`
+	}
+	type config struct {
+		Name              string `json:"name"`
+		UniqueId          string `json:"unique_id"`
+		StateTopic        string `json:"state_topic"`
+		AvailabilityTopic string `json:"availability_topic"`
+		ValueTemplate     string `json:"value_template"`
+		Unit              string `json:"unit_of_measurement,omitempty"`
+		DeviceClass       string `json:"device_class,omitempty"`
+		StateClass        string `json:"state_class,omitempty"`
+		Device            device `json:"device"`
+	}
+	id := objectId(tag)
+	c := config{
+		Name:              tag,
+		UniqueId:          m.node + "_" + id,
+		StateTopic:        m.prefix + "/" + tag,
+		AvailabilityTopic: m.availability(),
+		ValueTemplate:     "{{ value_json.value }}",
+		Device:            device{[]string{m.node}, "MeterMan", "MeterMan"},
+	}
+	if acc {
+		c.Unit, c.DeviceClass, c.StateClass = accUnit(tag, m.currency)
+	} else {
+		c.Unit, c.DeviceClass = gaugeUnit(tag)
+		c.StateClass = "measurement"
+	}
+	var msgs []message
+	add := func(id string, c *config) {
+		b, err := json.Marshal(c)
+		if err != nil {
+			log.Printf("mqtt: %s: marshal: %v", tag, err)
+			return
+		}
+		msgs = append(msgs, message{fmt.Sprintf("%s/sensor/%s/%s/config", m.dPrefix, m.node, id), b})
+	}
+	add(id, &c)
+	if acc {
+		c.Name = tag + " daily"
+		c.UniqueId += "_daily"
+		c.ValueTemplate = "{{ value_json.daily }}"
+		add(id+"_daily", &c)
+	}
+	return msgs
+}
+
+// objectId converts a tag into a form suitable for a Home Assistant object ID.
+func objectId(tag string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		default:
+			return '_'
+		}
+	}, tag)
+}
+
+// accUnit returns the unit, Home Assistant device class and state class
+// of an accumulator. The cost accumulators are monetary values, and
+// the net cost may decrease.
+func accUnit(tag, currency string) (string, string, string) {
+	base, _, _ := strings.Cut(tag, "/")
+	switch base {
+	case core.A_IMPORT_COST, core.A_EXPORT_CREDIT, core.A_SUPPLY_COST, core.A_NET_COST:
+		return currency, "monetary", "total"
+	}
+	return "kWh", "energy", "total_increasing"
+}
+
+// gaugeUnit returns the unit and Home Assistant device class of a gauge.
+func gaugeUnit(tag string) (string, string) {
+	base, _, _ := strings.Cut(tag, "/")
+	switch base {
+	case core.G_IN_POWER, core.G_OUT_POWER, core.G_GEN_P, core.D_GEN_P, core.G_BATT_POWER:
+		return "kW", "power"
+	case core.G_VOLTS:
+		return "V", "voltage"
+	case core.G_IN_CURRENT, core.G_OUT_CURRENT:
+		return "A", "current"
+	case core.G_FREQ:
+		return "Hz", "frequency"
+	case core.G_TEMP:
+		return "°C", "temperature"
+	case core.G_BATT_PERCENT:
+		return "%", "battery"
+	case core.G_BATT_SIZE:
+		return "kWh", "energy_storage"
+	}
+	// Tags with an inverter index and phase or string appended.
+	for _, u := range []struct{ prefix, unit, class string }{
+		{core.G_MPTT, "kW", "power"},
+		{core.G_AC_POWER, "kW", "power"},
+		{core.G_AC_CURRENT, "A", "current"},
+		{core.G_AC_VOLTS, "V", "voltage"},
+		{core.G_DC_CURRENT, "A", "current"},
+		{core.G_DC_VOLTS, "V", "voltage"},
+		{core.G_INV_FREQ, "Hz", "frequency"},
+		{core.G_INV_TEMP, "°C", "temperature"},
+	} {
+		if strings.HasPrefix(base, u.prefix+"-") {
+			return u.unit, u.class
+		}
+	}
+	return "", ""
+}
@@ -0,0 +1,221 @@
+// Copyright 2026 Andrew McRae
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"testing"
+
+	"github.com/aamcrae/MeterMan/core"
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+func TestObjectId(t *testing.T) {
+	for _, v := range []struct {
+		tag  string
+		want string
+	}{
+		{"IN-P", "in_p"},
+		{"GEN-P/0", "gen_p_0"},
+		{"MPTT-1-A", "mptt_1_a"},
+		{"cost.daily", "cost_daily"},
+	} {
+		if got := objectId(v.tag); got != v.want {
+			t.Errorf("objectId(%s): got %s want %s", v.tag, got, v.want)
+		}
+	}
+}
+
+func TestGaugeUnit(t *testing.T) {
+	for _, v := range []struct {
+		tag   string
+		unit  string
+		class string
+	}{
+		{core.G_IN_POWER, "kW", "power"},
+		{core.G_GEN_P + "/1", "kW", "power"},
+		{core.G_VOLTS + "/0", "V", "voltage"},
+		{core.G_BATT_PERCENT, "%", "battery"},
+		{core.G_MPTT + "-0-A", "kW", "power"},
+		{core.G_AC_VOLTS + "-1-3", "V", "voltage"},
+		{core.G_INV_TEMP + "-0", "°C", "temperature"},
+		{core.G_MPTT, "", ""},
+		{"UNKNOWN", "", ""},
+	} {
+		if unit, class := gaugeUnit(v.tag); unit != v.unit || class != v.class {
+			t.Errorf("gaugeUnit(%s): got %q, %q want %q, %q", v.tag, unit, class, v.unit, v.class)
+		}
+	}
+}
+
+func TestAccUnit(t *testing.T) {
+	for _, v := range []struct {
+		tag   string
+		unit  string
+		class string
+		state string
+	}{
+		{core.A_IN_TOTAL, "kWh", "energy", "total_increasing"},
+		{core.A_GEN_TOTAL + "/0", "kWh", "energy", "total_increasing"},
+		{core.A_IMPORT_COST, "AUD", "monetary", "total"},
+		{core.A_EXPORT_CREDIT, "AUD", "monetary", "total"},
+		{core.A_SUPPLY_COST, "AUD", "monetary", "total"},
+		{core.A_NET_COST, "AUD", "monetary", "total"},
+	} {
+		if unit, class, state := accUnit(v.tag, "AUD"); unit != v.unit || class != v.class || state != v.state {
+			t.Errorf("accUnit(%s): got %q, %q, %q want %q, %q, %q", v.tag, unit, class, state, v.unit, v.class, v.state)
+		}
+	}
+}
+
+func TestDiscoveryConfig(t *testing.T) {
+	m := &mqtt{prefix: "meterman", dPrefix: "homeassistant", node: "host", currency: "AUD"}
+	for _, v := range []struct {
+		tag  string
+		acc  bool
+		want []string // Topic, unique ID, unit and value template of each message
+	}{
+		{core.G_VOLTS, false, []string{
+			fmt.Sprintf("homeassistant/sensor/host/%s/config host_%s V {{ value_json.value }}", objectId(core.G_VOLTS), objectId(core.G_VOLTS)),
+		}},
+		{"GEN-T/0", true, []string{
+			"homeassistant/sensor/host/gen_t_0/config host_gen_t_0 kWh {{ value_json.value }}",
+			"homeassistant/sensor/host/gen_t_0_daily/config host_gen_t_0_daily kWh {{ value_json.daily }}",
+		}},
+		{core.A_NET_COST, true, []string{
+			"homeassistant/sensor/host/net_cost/config host_net_cost AUD {{ value_json.value }}",
+			"homeassistant/sensor/host/net_cost_daily/config host_net_cost_daily AUD {{ value_json.daily }}",
+		}},
+	} {
+		msgs := m.discoveryConfig(v.tag, v.acc)
+		if len(msgs) != len(v.want) {
+			t.Errorf("%s: got %d messages want %d", v.tag, len(msgs), len(v.want))
+			continue
+		}
+		for i, msg := range msgs {
+			var c map[string]any
+			if err := json.Unmarshal(msg.payload, &c); err != nil {
+				t.Fatalf("%s: %v", msg.topic, err)
+			}
+			got := fmt.Sprintf("%s %s %s %s", msg.topic, c["unique_id"], c["unit_of_measurement"], c["value_template"])
+			if got != v.want[i] {
+				t.Errorf("%s: got %s want %s", v.tag, got, v.want[i])
+			}
+			if c["state_topic"] != "meterman/"+v.tag || c["availability_topic"] != "meterman/status" {
+				t.Errorf("%s: state topic %s, availability %s", v.tag, c["state_topic"], c["availability_topic"])
+			}
+		}
+	}
+}
+
+// fakeClient records the messages published.
+type fakeClient struct {
+	paho.Client
+	msgs map[string]string
+}
+
+func (c *fakeClient) IsConnectionOpen() bool {
+	return true
+}
+
+func (c *fakeClient) Publish(topic string, qos byte, retained bool, payload interface{}) paho.Token {
+	c.msgs[topic] = string(payload.([]byte))
+	return &fakeToken{}
+}
+
+type fakeToken struct{}
+
+func (t *fakeToken) Wait() bool {
+	return true
+}
+
+func (t *fakeToken) WaitTimeout(time.Duration) bool {
+	return true
+}
+
+func (t *fakeToken) Done() <-chan struct{} {
+	c := make(chan struct{})
+	close(c)
+	return c
+}
+
+func (t *fakeToken) Error() error {
+	return nil
+}
+
+func TestPublish(t *testing.T) {
+	now := time.Unix(1715608200, 0)
+	d := core.NewDatabase(nil)
+	d.Clock = core.NewSimClock(now)
+	d.AddGauge(core.G_IN_POWER)
+	d.AddGauge(core.G_OUT_POWER)
+	d.AddAccum(core.A_IN_TOTAL, false)
+	d.GetElement(core.G_IN_POWER).Update(1.5, now)
+	d.GetElement(core.A_IN_TOTAL).Update(100, now)
+	d.GetAccum(core.A_IN_TOTAL).Midnight()
+	d.GetElement(core.A_IN_TOTAL).Update(102.5, now)
+	c := &fakeClient{msgs: make(map[string]string)}
+	m := &mqtt{d: d, client: c, prefix: "meterman", discovery: true, dPrefix: "homeassistant", node: "host", announced: make(map[string]struct{})}
+	publish := func() {
+		c.msgs = make(map[string]string)
+		m.publish(context.Background(), now)
+		m.wg.Wait()
+	}
+	publish()
+	for _, v := range []struct {
+		topic string
+		want  string
+	}{
+		{"meterman/IN-P", `{"value":1.5,"timestamp":1715608200}`},
+		{"meterman/IN", `{"value":102.5,"daily":2.5,"timestamp":1715608200}`},
+	} {
+		if got := c.msgs[v.topic]; got != v.want {
+			t.Errorf("%s: got %s want %s", v.topic, got, v.want)
+		}
+	}
+	// The stale gauge is not published.
+	if _, ok := c.msgs["meterman/OUT-P"]; ok {
+		t.Errorf("stale OUT-P published")
+	}
+	for _, topic := range []string{
+		"homeassistant/sensor/host/in_p/config",
+		"homeassistant/sensor/host/in/config",
+		"homeassistant/sensor/host/in_daily/config",
+	} {
+		if _, ok := c.msgs[topic]; !ok {
+			t.Errorf("%s: not published", topic)
+		}
+	}
+	if len(c.msgs) != 5 {
+		t.Errorf("published %d messages want 5", len(c.msgs))
+	}
+	if got, want := m.Status(), now.Format("2006-01-02 15:04")+": OK - 5 messages"; got != want {
+		t.Errorf("status: got %s want %s", got, want)
+	}
+	// The discovery config is only republished after a reconnection.
+	publish()
+	if len(c.msgs) != 2 {
+		t.Errorf("republished %d messages want 2", len(c.msgs))
+	}
+	m.rediscover.Store(true)
+	publish()
+	if len(c.msgs) != 5 {
+		t.Errorf("rediscovery published %d messages want 5", len(c.msgs))
+	}
+}
@@ -0,0 +1,171 @@
+// Copyright 2026 Andrew McRae
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mqtt
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/aamcrae/MeterMan/core"
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Subscription maps a MQTT topic to a database element.
+type Subscription struct {
+	Topic      string
+	Path       string  // Optional JSON path to value e.g ENERGY.Power
+	Scale      float64 // Multiplier applied to value, default 1
+	Tag        string  // Database tag, or base tag for sub-gauges
+	Type       string  // Element type: gauge, accum or sub-gauge
+	Resettable bool    // Accumulator may be reset
+	Average    bool    // Sub-gauges are averaged rather than summed
+}
+
+// subscriber holds a single subscription.
+type subscriber struct {
+	m     *mqtt
+	topic string
+	path  []string
+	scale float64
+	tag   string
+}
+
+// addSubscriptions creates the database elements for the subscriptions.
+func (m *mqtt) addSubscriptions(subs []Subscription) error {
+	added := make(map[string]struct{})
+	for _, s := range subs {
+		if len(s.Topic) == 0 || len(s.Tag) == 0 {
+			return fmt.Errorf("mqtt: subscription requires topic and tag")
+		}
+		sub := &subscriber{m: m, topic: s.Topic, scale: core.ConfigOrDefault(s.Scale, 1.0), tag: s.Tag}
+		if len(s.Path) != 0 {
+			sub.path = strings.Split(s.Path, ".")
+		}
+		switch s.Type {
+		case "gauge", "accum":
+			if _, ok := added[s.Tag]; ok {
+				return fmt.Errorf("mqtt: %s: duplicate tag %s", s.Topic, s.Tag)
+			}
+			added[s.Tag] = struct{}{}
+			if s.Type == "gauge" {
+				m.d.AddGauge(s.Tag)
+			} else {
+				m.d.AddAccum(s.Tag, s.Resettable)
+			}
+		case "sub-gauge":
+			sub.tag = m.d.AddSubGauge(s.Tag, s.Average)
+		default:
+			return fmt.Errorf("mqtt: %s: unknown element type '%s'", s.Topic, s.Type)
+		}
+		m.subs = append(m.subs, sub)
+		log.Printf("mqtt: subscribing to %s for tag %s", s.Topic, sub.tag)
+	}
+	return nil
+}
+
+// subscribe (re)subscribes to the topics. Called when the client connects.
+// Subscribing to a topic again replaces the handler of the previous subscription,
+// so each topic is subscribed once, and the messages are passed to all
+// of the subscribers to that topic (e.g each selecting a different JSON path).
+func (m *mqtt) subscribe(c paho.Client) {
+	topics, byTopic := groupByTopic(m.subs)
+	for _, topic := range topics {
+		subs := byTopic[topic]
+		t := c.Subscribe(topic, m.qos, func(c paho.Client, msg paho.Message) {
+			for _, s := range subs {
+				s.receive(c, msg)
+			}
+		})
+		go func() {
+			if t.WaitTimeout(publishTimeout) && t.Error() != nil {
+				log.Printf("mqtt: subscribe %s: %v", topic, t.Error())
+			}
+		}()
+	}
+}
+
+// groupByTopic returns the topics in the order first subscribed, and the subscribers for each topic.
+func groupByTopic(subs []*subscriber) ([]string, map[string][]*subscriber) {
+	var topics []string
+	byTopic := make(map[string][]*subscriber)
+	for _, s := range subs {
+		if _, ok := byTopic[s.topic]; !ok {
+			topics = append(topics, s.topic)
+		}
+		byTopic[s.topic] = append(byTopic[s.topic], s)
+	}
+	return topics, byTopic
+}
+
+// receive is the handler for a received message.
+func (s *subscriber) receive(c paho.Client, msg paho.Message) {
+	v, err := extract(msg.Payload(), s.path)
+	if err != nil {
+		log.Printf("mqtt: %s: %v", msg.Topic(), err)
+		return
+	}
+	v *= s.scale
+	if s.m.d.Trace {
+		log.Printf("mqtt: %s: tag %s = %g", msg.Topic(), s.tag, v)
+	}
+	s.m.d.Input(s.tag, v)
+}
+
+// extract retrieves the numeric value from the payload.
+// If the path is empty, the payload is expected to be a number, otherwise
+// the payload is decoded as JSON and the path is used to select the value.
+// Numeric path components are used as array indices.
+func extract(payload []byte, path []string) (float64, error) {
+	if len(path) == 0 {
+		return strconv.ParseFloat(strings.TrimSpace(string(payload)), 64)
+	}
+	var j any
+	if err := json.Unmarshal(payload, &j); err != nil {
+		return 0, err
+	}
+	for _, p := range path {
+		switch n := j.(type) {
+		case map[string]any:
+			v, ok := n[p]
+			if !ok {
+				return 0, fmt.Errorf("missing key '%s'", p)
+			}
+			j = v
+		case []any:
+			i, err := strconv.Atoi(p)
+			if err != nil || i < 0 || i >= len(n) {
+				return 0, fmt.Errorf("invalid array index '%s'", p)
+			}
+			j = n[i]
+		default:
+			return 0, fmt.Errorf("cannot select '%s' from value", p)
+		}
+	}
+	switch v := j.(type) {
+	case float64:
+		return v, nil
+	case string:
+		return strconv.ParseFloat(strings.TrimSpace(v), 64)
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	}
+	return 0, fmt.Errorf("value is not a number")
+}
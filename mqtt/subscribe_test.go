@@ -0,0 +1,55 @@
+// Copyright 2026 Andrew McRae
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mqtt
+
+import (
+	"strings"
+
+	"testing"
+)
+
+func TestExtract(t *testing.T) {
+	for _, v := range []struct {
+		payload string
+		path    string
+		want    float64
+		err     bool
+	}{
+		{" 123.5\n", "", 123.5, false},
+		{"on", "", 0, true},
+		{`{"ENERGY":{"Power":450}}`, "ENERGY.Power", 450, false},
+		{`{"ENERGY":{"Power":"12.5"}}`, "ENERGY.Power", 12.5, false},
+		{`{"state":true}`, "state", 1, false},
+		{`{"state":false}`, "state", 0, false},
+		{`{"phases":[{"v":240},{"v":241.5}]}`, "phases.1.v", 241.5, false},
+		{`{"phases":[{"v":240}]}`, "phases.1.v", 0, true},
+		{`{"phases":[{"v":240}]}`, "phases.-1.v", 0, true},
+		{`{"phases":[{"v":240}]}`, "phases.a.v", 0, true},
+		{`{"ENERGY":{"Power":450}}`, "ENERGY.Total", 0, true},
+		{`{"ENERGY":{"Power":450}}`, "ENERGY.Power.x", 0, true},
+		{`{"ENERGY":{"Power":null}}`, "ENERGY.Power", 0, true},
+		{`{"ENERGY":{"Power":"n/a"}}`, "ENERGY.Power", 0, true},
+		{`{"ENERGY":`, "ENERGY", 0, true},
+	} {
+		var path []string
+		if len(v.path) != 0 {
+			path = strings.Split(v.path, ".")
+		}
+		got, err := extract([]byte(v.payload), path)
+		if (err != nil) != v.err || got != v.want {
+			t.Errorf("extract(%s, %s): got %g, %v want %g", v.payload, v.path, got, err, v.want)
+		}
+	}
+}
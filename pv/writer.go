@@ -34,16 +34,19 @@
 //    apikey: <apikey from pvoutput.org>
 //    systemid: <systemid from pvoutput.org>
 //    pvurl: <URL API endpoint to use>
+//    batchurl: <URL batch API endpoint to use>
 
 package pv
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -54,24 +57,33 @@ type Pvoutput struct {
 	Apikey   string
 	Systemid string
 	Pvurl    string
+	Batchurl string
 	Interval int
 	Trace    bool
 }
 
 const moduleName = "pvoutput"
 
+// Maximum number of statuses in a batch upload.
+const batchSize = 30
+
+// Maximum age of statuses accepted by pvoutput.org.
+const maxBatchAge = time.Hour * 24 * 14
+
 type pvWriter struct {
 	d      *core.DB
 	pvurl  string
+	batch  string
 	id     string
 	key    string
 	client *http.Client
 	trace  bool
 	status atomic.Value
+	wg     sync.WaitGroup // In-flight uploads
 }
 
 func init() {
-	core.RegisterInit(pvoutputInit)
+	core.RegisterModule(moduleName, pvoutputInit)
 }
 
 func pvoutputInit(d *core.DB) error {
@@ -86,26 +98,30 @@ func pvoutputInit(d *core.DB) error {
 	}
 	interval := core.ConfigOrDefault(conf.Interval, 5) // Default update of 5 minutes
 	url := core.ConfigOrDefault(conf.Pvurl, "https://pvoutput.org/service/r2/addstatus.jsp")
-	p := &pvWriter{d: d, pvurl: url, id: conf.Systemid, key: conf.Apikey, client: &http.Client{}, trace: conf.Trace || d.Trace}
+	batch := core.ConfigOrDefault(conf.Batchurl, "https://pvoutput.org/service/r2/addbatchstatus.jsp")
+	p := &pvWriter{d: d, pvurl: url, batch: batch, id: conf.Systemid, key: conf.Apikey, client: &http.Client{}, trace: conf.Trace || d.Trace}
 	p.status.Store("Init")
 	if !d.Dryrun {
 		d.AddExport(time.Minute*time.Duration(interval), 0, p.upload)
+		d.AddBackfillHandler(p.backfill)
+	}
+	if err := d.AddModule(moduleName, p); err != nil {
+		return err
 	}
-	d.AddStatusPrinter(moduleName, p.Status)
 	log.Printf("Registered pvoutput uploader (%d minute intervals)\n", interval)
 	return nil
 }
 
 // Run creates a post request to pvoutput.org to upload the current data.
-func (p *pvWriter) upload(now time.Time) {
+func (p *pvWriter) upload(ctx context.Context, now time.Time) {
 	var b strings.Builder
 	fmt.Fprintf(&b, "%s: ", now.Format("2006-01-02 15:04"))
 	pv_power, pv_power_ok := p.getPVPower()
 	pv_daily, pv_daily_ok := p.getPVDaily()
 	temp := p.d.GetElement(core.G_TEMP)
 	volts := p.d.GetElement(core.G_VOLTS)
-	imp := p.d.GetAccum(core.A_IN_TOTAL)
-	exp := p.d.GetAccum(core.A_OUT_TOTAL)
+	cons := p.d.GetAccum(core.A_CONS_TOTAL)
+	cons_p := p.d.GetElement(core.G_CONS_POWER)
 	b_charge := p.d.GetAccum(core.A_CHARGE_TOTAL)
 	b_discharge := p.d.GetAccum(core.A_DISCHARGE_TOTAL)
 	b_status := p.d.GetElement(core.G_BATT_STATUS)
@@ -152,54 +168,22 @@ func (p *pvWriter) upload(now time.Time) {
 	} else if p.trace {
 		log.Printf("pvoutput: No Voltage, v6 not updated\n")
 	}
-	if isValid(imp) && isValid(exp) {
-		consumption := imp.Daily() - exp.Daily()
-		// Daily PV generation may be out of date, but it is used regardless.
-		consumption += pv_daily
-		// Add in battery charge/discharge, don't count battery charging as consumption
-		consumption += b_discharge.Daily() - b_charge.Daily()
-		val.Add("v3", fmt.Sprintf("%d", int(consumption*1000)))
+	// The consumption values are derived by the core energy balance.
+	if isValid(cons) {
+		val.Add("v3", fmt.Sprintf("%d", int(cons.Daily()*1000)))
 		if p.trace {
-			log.Printf("v3 = %g, imp = %g, exp = %g", consumption, imp.Daily(), exp.Daily())
-			log.Printf("daily = %g", pv_daily)
-			if !pv_daily_ok {
-				log.Printf("Using old generation data")
-			}
+			log.Printf("v3 = %g", cons.Daily())
 		}
 	} else if p.trace {
-		if exp == nil {
-			log.Printf("pvoutput: No export data\n")
-		} else if !isValid(exp) {
-			log.Printf("pvoutput: Export data not fresh\n")
-		}
-		if imp == nil {
-			log.Printf("pvoutput: No import data\n")
-		} else if !isValid(imp) {
-			log.Printf("pvoutput: Import data not fresh\n")
-		}
 		log.Printf("pvoutput: No consumption data, v3 not updated\n")
 	}
-	tp, err := p.getPower()
-	if err == nil {
-		var g float64
-		if pv_power_ok {
-			g = pv_power
-		}
-		// Add in battery power (-ve, discharging)
-		if isValid(b_power) {
-			tp -= b_power.Get() * 1000.0
-		}
-		cp := int(g*1000 + tp)
-		if cp < 0 {
-			log.Printf("pvoutput: Negative power consumption (%d), v4 set to 0, gen = %d, meter = %d\n", cp, int(g*1000), int(tp))
-			cp = 0
-		}
-		val.Add("v4", fmt.Sprintf("%d", cp))
+	if isValid(cons_p) {
+		val.Add("v4", fmt.Sprintf("%d", int(cons_p.Get()*1000)))
 		if p.trace {
-			log.Printf("v4 = %d", cp)
+			log.Printf("v4 = %g", cons_p.Get())
 		}
 	} else {
-		log.Printf("pvoutput: Invalid total power, v4 not sent: %v\n", err)
+		log.Printf("pvoutput: No consumption power, v4 not sent\n")
 	}
 
 	// Add battery values
@@ -222,7 +206,14 @@ func (p *pvWriter) upload(now time.Time) {
 		val.Add("b6", fmt.Sprintf("%d", int(b_status.Get())))
 	}
 
-	req, err := http.NewRequest("POST", p.pvurl, strings.NewReader(val.Encode()))
+	if p.d.Replaying() {
+		// The replayed values are logged rather than uploaded.
+		log.Printf("pvoutput: replay: %s", val.Encode())
+		fmt.Fprintf(&b, "Replay: %v", val)
+		p.status.Store(b.String())
+		return
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", p.pvurl, strings.NewReader(val.Encode()))
 	if err != nil {
 		log.Printf("pvoutput: NewRequest failed: %v", err)
 		fmt.Fprintf(&b, "NewRequest err: %v", err)
@@ -240,7 +231,18 @@ func (p *pvWriter) upload(now time.Time) {
 		log.Printf("PV req: %s (size %d)", val.Encode(), req.ContentLength)
 	}
 	// Asynchronously send request to avoid blocking.
-	go p.send(req, &b)
+	p.wg.Go(func() {
+		p.send(req, &b)
+	})
+}
+
+func (p *pvWriter) Start(ctx context.Context) error {
+	return nil
+}
+
+// Stop waits for any in-flight upload to complete.
+func (p *pvWriter) Stop(ctx context.Context) error {
+	return core.Wait(ctx, &p.wg)
 }
 
 func (p *pvWriter) Status() string {
@@ -269,6 +271,62 @@ func (p *pvWriter) send(req *http.Request, b *strings.Builder) {
 	}
 }
 
+// backfill uploads the historical PV generation records using the batch API.
+// Only records during daylight hours, and that are recent enough to
+// be accepted by pvoutput.org, are uploaded.
+func (p *pvWriter) backfill(recs []core.BackfillRecord) {
+	oldest := p.d.Clock.Now().Add(-maxBatchAge)
+	var data []string
+	for _, r := range recs {
+		daily, ok := r.Daily[core.A_GEN_TOTAL]
+		hour := r.Time.Hour()
+		if !ok || r.Time.Before(oldest) || hour < p.d.StartHour || hour >= p.d.EndHour {
+			continue
+		}
+		// Each status is date,time,energy generation,power generation
+		var power string
+		if pwr, ok := r.Values[core.D_GEN_P]; ok {
+			power = fmt.Sprintf("%d", int(pwr*1000))
+		}
+		data = append(data, fmt.Sprintf("%s,%s,%d,%s", r.Time.Format("20060102"), r.Time.Format("15:04"), int(daily*1000), power))
+	}
+	for len(data) != 0 {
+		n := min(len(data), batchSize)
+		if err := p.sendBatch(data[:n]); err != nil {
+			log.Printf("pvoutput: backfill: %v", err)
+			return
+		}
+		data = data[n:]
+	}
+}
+
+// sendBatch uploads a batch of statuses.
+func (p *pvWriter) sendBatch(data []string) error {
+	val := url.Values{}
+	val.Add("data", strings.Join(data, ";"))
+	req, err := http.NewRequest("POST", p.batch, strings.NewReader(val.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Add("X-Pvoutput-Apikey", p.key)
+	req.Header.Add("X-Pvoutput-SystemId", p.id)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if p.trace {
+		log.Printf("PV batch upload: %s", val.Encode())
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, body)
+	}
+	log.Printf("pvoutput: backfilled %d statuses", len(data))
+	return nil
+}
+
 // getPVPower returns the current PV power.
 // If it is not valid, an attempt is made to derive it from any
 // valid sub-values.
@@ -327,20 +385,6 @@ func (p *pvWriter) getPVDaily() (float64, bool) {
 	return 0, false
 }
 
-// getPower returns the current import/export power (as Watts)
-func (p *pvWriter) getPower() (float64, error) {
-	d_in := p.d.GetElement(core.G_IN_POWER)
-	d_out := p.d.GetElement(core.G_OUT_POWER)
-	if p.trace {
-		log.Printf("IN-P  = %g, valid = %v", d_in.Get(), isValid(d_in))
-		log.Printf("OUT-P = %g, valid = %v", d_out.Get(), isValid(d_out))
-	}
-	if isValid(d_in) && isValid(d_out) {
-		return (d_in.Get() - d_out.Get()) * 1000.0, nil
-	}
-	return 0.0, fmt.Errorf("no valid power reading")
-}
-
 // isValid will return true if the element is not nil and is fresh
 func isValid(e core.Element) bool {
 	return e != nil && e.Fresh()
@@ -0,0 +1,239 @@
+// Copyright 2026 Andrew McRae
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aamcrae/MeterMan/core"
+)
+
+type HistorySample struct {
+	Time  int64    `json:"time"`
+	Value float64  `json:"value"`
+	Daily *float64 `json:"daily,omitempty"`
+}
+
+type HistoryData struct {
+	Tag     string          `json:"tag"`
+	From    int64           `json:"from"`
+	To      int64           `json:"to"`
+	Step    int64           `json:"step"`
+	Samples []HistorySample `json:"samples"`
+}
+
+type DailyData struct {
+	Date   string             `json:"date"`
+	Totals map[string]float64 `json:"totals"`
+}
+
+// Handler for history requests, of the form:
+//
+//	/api/history?tag=<tag>&from=<time>&to=<time>&step=<duration>
+//
+// The default range is the last 24 hours. If step is not specified,
+// all the samples in the range are returned.
+// The history store is safe for concurrent access, so this handler
+// is not run in the main thread.
+func (s *apiServer) history(w http.ResponseWriter, req *http.Request) {
+	if s.d.Trace {
+		log.Printf("API: Request: %s", req.URL.String())
+	}
+	h := s.d.History()
+	if h == nil {
+		http.Error(w, "history not configured", http.StatusNotFound)
+		return
+	}
+	q := req.URL.Query()
+	tag := q.Get("tag")
+	if len(tag) == 0 {
+		http.Error(w, "missing tag", http.StatusBadRequest)
+		return
+	}
+	from, to, err := timeRange(q.Get("from"), q.Get("to"), s.d.Clock.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var step time.Duration
+	if st := q.Get("step"); len(st) != 0 {
+		step, err = time.ParseDuration(st)
+		if err != nil || step < 0 {
+			http.Error(w, fmt.Sprintf("step: invalid duration %s", st), http.StatusBadRequest)
+			return
+		}
+	}
+	samples, err := h.Query(tag, from, to, step)
+	if err != nil {
+		log.Printf("api: history: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if wantCSV(req) {
+		w.Header().Set("Content-Type", "text/csv")
+		fmt.Fprintln(w, "#time,value,daily")
+		for _, smp := range samples {
+			fmt.Fprintf(w, "%s,%s,", smp.Time.Format(time.RFC3339), core.FmtFloat(smp.Value))
+			if smp.Accum {
+				fmt.Fprint(w, core.FmtFloat(smp.Daily))
+			}
+			fmt.Fprintln(w)
+		}
+		return
+	}
+	hd := HistoryData{Tag: tag, From: from.Unix(), To: to.Unix(), Step: int64(step.Seconds())}
+	hd.Samples = []HistorySample{}
+	for _, smp := range samples {
+		hs := HistorySample{Time: smp.Time.Unix(), Value: smp.Value}
+		if smp.Accum {
+			daily := smp.Daily
+			hs.Daily = &daily
+		}
+		hd.Samples = append(hd.Samples, hs)
+	}
+	writeJSON(w, hd)
+}
+
+// Handler for daily total requests, of the form:
+//
+//	/api/daily?from=<time>&to=<time>
+//
+// The default range is the last 24 hours.
+func (s *apiServer) dailyTotals(w http.ResponseWriter, req *http.Request) {
+	if s.d.Trace {
+		log.Printf("API: Request: %s", req.URL.String())
+	}
+	h := s.d.History()
+	if h == nil {
+		http.Error(w, "history not configured", http.StatusNotFound)
+		return
+	}
+	q := req.URL.Query()
+	from, to, err := timeRange(q.Get("from"), q.Get("to"), s.d.Clock.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	days, err := h.DailyTotals(from, to)
+	if err != nil {
+		log.Printf("api: daily: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if wantCSV(req) {
+		// Collect all the tags for the column headers.
+		tm := make(map[string]struct{})
+		for _, d := range days {
+			for t := range d.Totals {
+				tm[t] = struct{}{}
+			}
+		}
+		var tags []string
+		for t := range tm {
+			tags = append(tags, t)
+		}
+		sort.Strings(tags)
+		w.Header().Set("Content-Type", "text/csv")
+		fmt.Fprintf(w, "#date,%s\n", strings.Join(tags, ","))
+		for _, d := range days {
+			fmt.Fprint(w, d.Day.Format("2006-01-02"))
+			for _, t := range tags {
+				if v, ok := d.Totals[t]; ok {
+					fmt.Fprintf(w, ",%s", core.FmtFloat(v))
+				} else {
+					fmt.Fprint(w, ",")
+				}
+			}
+			fmt.Fprintln(w)
+		}
+		return
+	}
+	dd := []DailyData{}
+	for _, d := range days {
+		dd = append(dd, DailyData{Date: d.Day.Format("2006-01-02"), Totals: d.Totals})
+	}
+	writeJSON(w, dd)
+}
+
+// wantCSV returns true if the request accepts CSV output.
+func wantCSV(req *http.Request) bool {
+	return strings.Contains(req.Header.Get("Accept"), "text/csv")
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	m, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("api: marshal: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(m)
+}
+
+// maxRange is the longest time range that may be requested.
+const maxRange = time.Hour * 24 * 366
+
+// timeRange parses the from and to parameters.
+// If to is empty, the current time is used, and if from is
+// empty, 24 hours before the to time is used.
+func timeRange(f, t string, now time.Time) (time.Time, time.Time, error) {
+	to := now
+	if len(t) != 0 {
+		var err error
+		to, err = parseTime(t)
+		if err != nil {
+			return to, to, fmt.Errorf("to: %v", err)
+		}
+	}
+	from := to.Add(-time.Hour * 24)
+	if len(f) != 0 {
+		var err error
+		from, err = parseTime(f)
+		if err != nil {
+			return from, to, fmt.Errorf("from: %v", err)
+		}
+	}
+	if !from.Before(to) {
+		return from, to, fmt.Errorf("from must be before to")
+	}
+	if to.Sub(from) > maxRange {
+		return from, to, fmt.Errorf("time range is limited to %d days", maxRange/(time.Hour*24))
+	}
+	return from, to, nil
+}
+
+// parseTime accepts a time as Unix seconds, RFC3339, or a local date and optional time.
+func parseTime(s string) (time.Time, error) {
+	if sec, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unknown time format: %s", s)
+}
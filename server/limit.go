@@ -0,0 +1,135 @@
+// Copyright 2026 Andrew McRae
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aamcrae/MeterMan/core"
+)
+
+type LimitResult struct {
+	Inverter string `json:"inverter"`
+	Status   string `json:"status"`
+}
+
+// Handler for power limit requests, of the form:
+//
+//	POST /api/limit
+//	Authorization: Bearer <token>
+//	watts=<limit>|percent=<limit>[&inverter=<name>]
+//
+// The limit is applied to the named inverter, or all inverters if no name is provided.
+// Setting the limit requires communicating with the inverters, so this handler
+// is not run in the main thread.
+func (s *apiServer) limit(w http.ResponseWriter, req *http.Request) {
+	if s.d.Trace {
+		log.Printf("API: Request: %s", req.URL.String())
+	}
+	if !s.authorise(w, req, "power limit") {
+		return
+	}
+	watts, percent := req.Form.Get("watts"), req.Form.Get("percent")
+	if (len(watts) == 0) == (len(percent) == 0) {
+		http.Error(w, "one of watts or percent required", http.StatusBadRequest)
+		return
+	}
+	val := watts + percent
+	v, err := strconv.ParseFloat(val, 64)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid limit %s", val), http.StatusBadRequest)
+		return
+	}
+	// The limiters may be removed when the config is reloaded, so take a copy in the main thread.
+	var lm map[string]core.PowerLimiter
+	s.d.Execute(func() {
+		lm = maps.Clone(s.d.GetPowerLimiters())
+	})
+	var names []string
+	if inv := req.Form.Get("inverter"); len(inv) != 0 {
+		if _, ok := lm[inv]; !ok {
+			http.Error(w, fmt.Sprintf("%s: unknown inverter", inv), http.StatusNotFound)
+			return
+		}
+		names = append(names, inv)
+	} else {
+		for k := range lm {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+	}
+	if len(names) == 0 {
+		http.Error(w, "no inverters support power limits", http.StatusNotFound)
+		return
+	}
+	results := []LimitResult{}
+	status := http.StatusOK
+	for _, n := range names {
+		var err error
+		if len(watts) != 0 {
+			err = lm[n].SetPowerLimit(v)
+		} else {
+			err = lm[n].SetPowerLimitPercent(v)
+		}
+		r := LimitResult{Inverter: n, Status: "OK"}
+		if err != nil {
+			log.Printf("api: limit: %s: %v", n, err)
+			r.Status = err.Error()
+			status = http.StatusBadGateway
+		}
+		results = append(results, r)
+	}
+	m, err := json.Marshal(results)
+	if err != nil {
+		log.Printf("api: marshal: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(m)
+}
+
+// authorise checks that a control request is a POST with a valid token, and
+// parses the form. If the request is rejected, an error is returned to the client.
+func (s *apiServer) authorise(w http.ResponseWriter, req *http.Request, control string) bool {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	if len(s.token) == 0 {
+		http.Error(w, control+" control not enabled", http.StatusForbidden)
+		return false
+	}
+	auth, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(auth), []byte(s.token)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	if err := req.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
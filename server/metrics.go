@@ -0,0 +1,138 @@
+// Copyright 2026 Andrew McRae
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aamcrae/MeterMan/core"
+)
+
+const metricPrefix = "meterman_"
+
+// metric holds the values of a single metric family.
+type metric struct {
+	name  string
+	help  string
+	mType string
+	lines []string
+}
+
+// Handler for Prometheus metrics requests.
+// The metrics are generated in the Prometheus text exposition format.
+func (s *apiServer) metrics(w http.ResponseWriter, req *http.Request) {
+	if s.d.Trace {
+		log.Printf("Metrics: Request: %s", req.URL.String())
+	}
+	value := &metric{name: "value", help: "Current value of the element.", mType: "gauge"}
+	total := &metric{name: "total", help: "Lifetime total of the accumulator.", mType: "gauge"}
+	daily := &metric{name: "daily", help: "Daily total of the accumulator.", mType: "gauge"}
+	monthly := &metric{name: "monthly", help: "Month-to-date total of the accumulator.", mType: "gauge"}
+	yearly := &metric{name: "yearly", help: "Year-to-date total of the accumulator.", mType: "gauge"}
+	billing := &metric{name: "billing", help: "Billing period total of the accumulator.", mType: "gauge"}
+	fresh := &metric{name: "fresh", help: "1 if the element value is fresh, 0 if stale.", mType: "gauge"}
+	age := &metric{name: "age_seconds", help: "Seconds since the element was last updated.", mType: "gauge"}
+	info := &metric{name: "module_info", help: "Always 1, for each module.", mType: "gauge"}
+	m := s.d.GetElements()
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	now := s.d.Clock.Now()
+	for _, k := range keys {
+		e := m[k]
+		label := fmt.Sprintf("tag=\"%s\"", escapeLabel(k))
+		if a, ok := e.(core.Acc); ok {
+			total.add(label, a.Get())
+			daily.add(label, a.Daily())
+			monthly.add(label, a.Monthly())
+			yearly.add(label, a.Yearly())
+			billing.add(label, a.Billing())
+		} else {
+			value.add(label, e.Get())
+		}
+		if e.Fresh() {
+			fresh.add(label, 1)
+		} else {
+			fresh.add(label, 0)
+		}
+		if ts := e.Timestamp(); !ts.IsZero() {
+			age.add(label, now.Sub(ts).Truncate(time.Second).Seconds())
+		}
+	}
+	sm := s.d.GetStatus()
+	keys = []string{}
+	for k := range sm {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	// The status is not included as a label, since it changes (e.g includes the time), and
+	// each different label value creates a new time series.
+	for _, k := range keys {
+		info.add(fmt.Sprintf("module=\"%s\"", escapeLabel(k)), 1)
+	}
+	var b bytes.Buffer
+	for _, mt := range []*metric{value, total, daily, monthly, yearly, billing, fresh, age, info} {
+		mt.write(&b)
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(b.Bytes())
+}
+
+// add adds a labelled value to the metric.
+func (m *metric) add(labels string, v float64) {
+	m.lines = append(m.lines, fmt.Sprintf("%s%s{%s} %s", metricPrefix, m.name, labels, fmtMetric(v)))
+}
+
+// write outputs the metric family, if it has any values.
+func (m *metric) write(b *bytes.Buffer) {
+	if len(m.lines) == 0 {
+		return
+	}
+	fmt.Fprintf(b, "# HELP %s%s %s\n", metricPrefix, m.name, m.help)
+	fmt.Fprintf(b, "# TYPE %s%s %s\n", metricPrefix, m.name, m.mType)
+	for _, l := range m.lines {
+		fmt.Fprintln(b, l)
+	}
+}
+
+// fmtMetric formats a value as required by the exposition format.
+func fmtMetric(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var labelEscaper = strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n")
+
+// escapeLabel escapes a label value.
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
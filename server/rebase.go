@@ -0,0 +1,85 @@
+// Copyright 2026 Andrew McRae
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/aamcrae/MeterMan/core"
+)
+
+type RebaseResult struct {
+	Tag     string  `json:"tag"`
+	Value   float64 `json:"value"`
+	Offset  float64 `json:"offset"`
+	Pending bool    `json:"pending"`
+}
+
+// Handler for accumulator rebase requests, of the form:
+//
+//	POST /api/rebase
+//	Authorization: Bearer <token>
+//	tag=<tag>[&value=<device value>]
+//
+// The accumulator is rebased so that its value continues from the current value,
+// using the current value of the new device, or if no value is provided,
+// the next value received from the device.
+func (s *apiServer) rebase(w http.ResponseWriter, req *http.Request) {
+	if s.d.Trace {
+		log.Printf("API: Request: %s", req.URL.String())
+	}
+	if !s.authorise(w, req, "rebase") {
+		return
+	}
+	tag, val := req.Form.Get("tag"), req.Form.Get("value")
+	if len(tag) == 0 {
+		http.Error(w, "tag required", http.StatusBadRequest)
+		return
+	}
+	var v float64
+	if len(val) != 0 {
+		var err error
+		v, err = strconv.ParseFloat(val, 64)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid value %s", val), http.StatusBadRequest)
+			return
+		}
+	}
+	var r RebaseResult
+	var err error
+	s.d.Execute(func() {
+		if err = s.d.Rebase(tag, v, len(val) == 0); err != nil {
+			return
+		}
+		a := s.d.GetElement(tag).(*core.Accum)
+		r = RebaseResult{Tag: tag, Value: a.Get(), Offset: a.Offset(), Pending: len(val) == 0}
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	m, err := json.Marshal(r)
+	if err != nil {
+		log.Printf("api: marshal: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(m)
+}
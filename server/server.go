@@ -12,16 +12,20 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-// package server implements a HTTP API server and status server.
+// package server implements a HTTP API server, status server and
+// Prometheus metrics endpoint.
 
 package server
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"sort"
+	"sync/atomic"
 	"time"
 
 	"github.com/aamcrae/MeterMan/core"
@@ -29,30 +33,72 @@ import (
 )
 
 type ApiConfig struct {
-	Port int // HTTP port
+	Port  int    // HTTP port
+	Token string // Token required for control requests
 }
 
 type apiServer struct {
-	d *core.DB
+	d     *core.DB
+	token string
+	srv   *http.Server
+	state atomic.Value
 }
 
 type Item struct {
 	Total     int   `json:"daily"`
 	Daily     int   `json:"total"`
+	Monthly   int   `json:"monthly"`
+	Yearly    int   `json:"yearly"`
+	Billing   int   `json:"billing"`
 	Power     int   `json:"power"`
 	Timestamp int64 `json:"timestamp"`
 	Fresh     bool  `json:"fresh"`
 }
 
+type CostItem struct {
+	Daily   float64 `json:"daily"`
+	Monthly float64 `json:"monthly"`
+	Billing float64 `json:"billing"`
+	Total   float64 `json:"total"`
+}
+
+type Cost struct {
+	Import     CostItem `json:"import"`
+	Export     CostItem `json:"export"`
+	Supply     CostItem `json:"supply"`
+	Net        CostItem `json:"net"`
+	ImportRate float64  `json:"import_rate"`
+	FeedinRate float64  `json:"feedin_rate"`
+}
+
+type EventItem struct {
+	Time    int64  `json:"time"`
+	Source  string `json:"source"`
+	Code    int    `json:"code"`
+	Level   string `json:"level"`
+	Message string `json:"message"`
+}
+
 type Data struct {
-	Power       int  `json:"power"`
-	Available   int  `json:"available"`
-	Import      Item `json:"import"`
-	Export      Item `json:"export"`
-	Generated   Item `json:"generated"`
-	Consumption Item `json:"consumption"`
+	Power       int      `json:"power"`
+	Available   int      `json:"available"`
+	Import      Item     `json:"import"`
+	Export      Item     `json:"export"`
+	Generated   Item     `json:"generated"`
+	Consumption Item     `json:"consumption"`
+	Balance     *Balance `json:"balance,omitempty"`
+	Cost        *Cost    `json:"cost,omitempty"`
+}
+
+type Balance struct {
+	SelfConsumption float64 `json:"self_consumption"`
+	SelfSufficiency float64 `json:"self_sufficiency"`
+	PVGrid          Item    `json:"pv_grid"`
+	BatteryLoad     Item    `json:"battery_load"`
 }
 
+// The handlers are registered with the default mux, which does not allow
+// them to be removed, so the server is not restarted when the configuration is reloaded.
 func init() {
 	core.RegisterInit(serverInit)
 }
@@ -71,7 +117,8 @@ func serverInit(d *core.DB) error {
 		return err
 	}
 	port := core.ConfigOrDefault(conf.Port, 8080) // Default port is 8080
-	s := &apiServer{d: d}
+	s := &apiServer{d: d, token: conf.Token, srv: &http.Server{Addr: fmt.Sprintf(":%d", port)}}
+	s.state.Store("init")
 	apih := func(w http.ResponseWriter, req *http.Request) {
 		s.d.Execute(func() {
 			s.api(w, req)
@@ -79,20 +126,50 @@ func serverInit(d *core.DB) error {
 	}
 	http.HandleFunc("/api", apih)
 	http.HandleFunc("/api/", apih)
+	http.HandleFunc("/api/history", s.history)
+	http.HandleFunc("/api/daily", s.dailyTotals)
+	http.HandleFunc("/api/limit", s.limit)
+	http.HandleFunc("/api/rebase", s.rebase)
+	http.HandleFunc("/api/events", func(w http.ResponseWriter, req *http.Request) {
+		s.d.Execute(func() {
+			s.events(w, req)
+		})
+	})
+	http.HandleFunc("/metrics", func(w http.ResponseWriter, req *http.Request) {
+		s.d.Execute(func() {
+			s.metrics(w, req)
+		})
+	})
 	statusz.RegisterExtension(func(w http.ResponseWriter, req *http.Request) {
 		s.d.Execute(func() {
 			s.status(w, req)
 		})
 	})
-	if !d.Dryrun {
-		go func() {
-			log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", port), nil))
-		}()
-	}
 	log.Printf("Registered HTTP API and status server on port %d\n", port)
+	return d.AddModule("api", s)
+}
+
+func (s *apiServer) Start(ctx context.Context) error {
+	go func() {
+		s.state.Store(fmt.Sprintf("%s: Listening on %s", time.Now().Format("2006-01-02 15:04"), s.srv.Addr))
+		err := s.srv.ListenAndServe()
+		if !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
 	return nil
 }
 
+// Stop closes the server. The active requests are not waited for,
+// since the handlers run on the main thread.
+func (s *apiServer) Stop(ctx context.Context) error {
+	return s.srv.Close()
+}
+
+func (s *apiServer) Status() string {
+	return s.state.Load().(string)
+}
+
 // Handler for API requests.
 func (s *apiServer) api(w http.ResponseWriter, req *http.Request) {
 	if s.d.Trace {
@@ -103,15 +180,14 @@ func (s *apiServer) api(w http.ResponseWriter, req *http.Request) {
 	s.daily(&c.Import, core.A_IMPORT, core.G_IN_POWER, 1000)
 	s.daily(&c.Export, core.A_EXPORT, core.G_OUT_POWER, 1000)
 	s.daily(&c.Generated, core.A_GEN_TOTAL, core.D_GEN_P, 1000)
-	c.Consumption.Daily = c.Generated.Daily + c.Import.Daily - c.Export.Daily
-	c.Consumption.Total = c.Generated.Total + c.Import.Total - c.Export.Total
+	// The consumption values are derived by the core energy balance.
+	s.daily(&c.Consumption, core.A_CONS_TOTAL, core.G_CONS_POWER, 1000)
 	c.Power = c.Import.Power - c.Export.Power
-	c.Consumption.Power = c.Generated.Power + c.Power
-	c.Consumption.Timestamp = c.Import.Timestamp
-	c.Consumption.Fresh = c.Import.Fresh
+	c.Balance = s.balance()
 	if c.Power < 0 {
 		c.Available = -c.Power
 	}
+	c.Cost = s.cost()
 	m, err := json.Marshal(c)
 	if err != nil {
 		log.Printf("api: marshal: %v", err)
@@ -122,6 +198,79 @@ func (s *apiServer) api(w http.ResponseWriter, req *http.Request) {
 	w.Write(m)
 }
 
+// Handler for event requests.
+func (s *apiServer) events(w http.ResponseWriter, req *http.Request) {
+	if s.d.Trace {
+		log.Printf("API: Request: %s", req.URL.String())
+	}
+	items := []EventItem{}
+	for _, e := range s.d.GetEvents() {
+		items = append(items, EventItem{
+			Time:    e.Time.Unix(),
+			Source:  e.Source,
+			Code:    e.Code,
+			Level:   e.Level.String(),
+			Message: e.Message,
+		})
+	}
+	m, err := json.Marshal(items)
+	if err != nil {
+		log.Printf("api: marshal: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(m)
+}
+
+// balance returns the derived energy balance values, or nil if not available.
+func (s *apiServer) balance() *Balance {
+	if s.d.GetElement(core.G_CONS_POWER) == nil {
+		return nil
+	}
+	var b Balance
+	if e := s.d.GetElement(core.G_SELF_CONSUMPTION); e.Fresh() {
+		b.SelfConsumption = e.Get()
+	}
+	if e := s.d.GetElement(core.G_SELF_SUFFICIENCY); e.Fresh() {
+		b.SelfSufficiency = e.Get()
+	}
+	s.daily(&b.PVGrid, core.A_PV_GRID, core.G_PV_GRID_POWER, 1000)
+	s.daily(&b.BatteryLoad, core.A_BATT_LOAD, core.G_BATT_LOAD_POWER, 1000)
+	return &b
+}
+
+// cost returns the tariff costs, or nil if no tariff is configured.
+func (s *apiServer) cost() *Cost {
+	if s.d.GetAccum(core.A_NET_COST) == nil {
+		return nil
+	}
+	var c Cost
+	for _, v := range []struct {
+		i   *CostItem
+		tag string
+	}{
+		{&c.Import, core.A_IMPORT_COST},
+		{&c.Export, core.A_EXPORT_CREDIT},
+		{&c.Supply, core.A_SUPPLY_COST},
+		{&c.Net, core.A_NET_COST},
+	} {
+		if a := s.d.GetAccum(v.tag); a != nil {
+			v.i.Daily = a.Daily()
+			v.i.Monthly = a.Monthly()
+			v.i.Billing = a.Billing()
+			v.i.Total = a.Get()
+		}
+	}
+	if e := s.d.GetElement(core.G_IMPORT_RATE); e != nil {
+		c.ImportRate = e.Get()
+	}
+	if e := s.d.GetElement(core.G_FEEDIN_RATE); e != nil {
+		c.FeedinRate = e.Get()
+	}
+	return &c
+}
+
 // Fill in item from the daily value of the accumulator
 func (s *apiServer) daily(i *Item, n, p string, scale float64) {
 	e := s.d.GetAccum(n)
@@ -130,6 +279,9 @@ func (s *apiServer) daily(i *Item, n, p string, scale float64) {
 	}
 	i.Daily = int(e.Daily() * scale)
 	i.Total = int(e.Get() * scale)
+	i.Monthly = int(e.Monthly() * scale)
+	i.Yearly = int(e.Yearly() * scale)
+	i.Billing = int(e.Billing() * scale)
 	ep := s.d.GetElement(p)
 	if ep != nil {
 		i.Power = int(ep.Get() * scale)
@@ -155,8 +307,18 @@ func (s *apiServer) status(w http.ResponseWriter, req *http.Request) {
 		fmt.Fprintf(w, "<tr><td>%s</td><td>%s</td></tr>", k, sm[k])
 	}
 	fmt.Fprintf(w, "</table>")
+	if evs := s.d.GetEvents(); len(evs) != 0 {
+		fmt.Fprintf(w, "<h1>Events</h1>")
+		fmt.Fprintf(w, "<table border=\"1\"><tr><th>Time</th><th>Source</th><th>Level</th><th>Code</th><th>Message</th></tr>")
+		for i := len(evs) - 1; i >= 0; i-- {
+			e := evs[i]
+			fmt.Fprintf(w, "<tr><td>%s</td><td>%s</td><td>%s</td><td>%d</td><td>%s</td></tr>",
+				e.Time.Format(time.UnixDate), e.Source, e.Level, e.Code, e.Message)
+		}
+		fmt.Fprintf(w, "</table>")
+	}
 	fmt.Fprintf(w, "<h1>Database</h1>")
-	fmt.Fprintf(w, "<table border=\"1\"><tr><th>Tag</th><th>Value</th><th>Daily</th><th>Fresh</th><th>Timestamp</th><th>Age</tr>")
+	fmt.Fprintf(w, "<table border=\"1\"><tr><th>Tag</th><th>Value</th><th>Daily</th><th>Offset</th><th>Fresh</th><th>Timestamp</th><th>Age</tr>")
 	m := s.d.GetElements()
 	// Sort in key order.
 	keys = []string{}
@@ -164,7 +326,7 @@ func (s *apiServer) status(w http.ResponseWriter, req *http.Request) {
 		keys = append(keys, k)
 	}
 	sort.Strings(keys)
-	now := time.Now()
+	now := s.d.Clock.Now()
 	for _, k := range keys {
 		v := m[k]
 		fmt.Fprintf(w, "<tr><td><bold>%s</bold></td>", k)
@@ -175,6 +337,12 @@ func (s *apiServer) status(w http.ResponseWriter, req *http.Request) {
 		default:
 			fmt.Fprintf(w, "<td> </td>")
 		}
+		// Show the offset of rebased accumulators.
+		if a, ok := v.(*core.Accum); ok && a.Offset() != 0 {
+			fmt.Fprintf(w, "<td style=\"text-align:right\">%s</td>", core.FmtFloat(a.Offset()))
+		} else {
+			fmt.Fprintf(w, "<td> </td>")
+		}
 		if v.Fresh() {
 			fmt.Fprintf(w, "<td>Yes</td>")
 		} else {
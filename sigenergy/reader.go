@@ -17,6 +17,7 @@
 package sigenergy
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"strings"
@@ -45,7 +46,7 @@ type SigenergyReader struct {
 }
 
 func init() {
-	core.RegisterInit(batteryReader)
+	core.RegisterExternal("sigenergy", batteryReader)
 }
 
 // Initialise Sigenergy reader(s).
@@ -88,11 +89,11 @@ func (s *SigenergyReader) Status() string {
 	return s.status.Load().(string)
 }
 
-func (s *SigenergyReader) cbPoll() {
+func (s *SigenergyReader) cbPoll(ctx context.Context) {
 	var err error
 	for _ = range retries {
 		err = s.poll()
-		if err == nil {
+		if err == nil || ctx.Err() != nil {
 			return
 		}
 	}
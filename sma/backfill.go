@@ -0,0 +1,175 @@
+// Copyright 2026 Andrew McRae
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sma
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aamcrae/MeterMan/core"
+)
+
+// Minimum downtime before a backfill is attempted.
+const backfillMinimum = time.Minute * 10
+
+// Maximum period that is backfilled.
+const maxBackfill = time.Hour * 24 * 14
+
+// Delay between attempts to retrieve the archive.
+const backfillRetry = time.Minute
+
+// Maximum gap between archive entries used to derive the power.
+const maxArchiveGap = time.Minute * 10
+
+// backfiller is a module that backfills the period that MeterMan was not running.
+type backfiller struct {
+	d       *core.DB
+	readers []*InverterReader
+	last    time.Time // Time the checkpoint was saved
+	status  atomic.Value
+	cancel  context.CancelFunc // Cancels the running backfill
+	wg      sync.WaitGroup     // Running backfill
+}
+
+func newBackfiller(d *core.DB, readers []*InverterReader) *backfiller {
+	b := &backfiller{d: d, readers: readers, last: d.LastCheckpoint()}
+	b.status.Store("init")
+	return b
+}
+
+func (b *backfiller) Start(ctx context.Context) error {
+	ctx, b.cancel = context.WithCancel(ctx)
+	b.wg.Go(func() {
+		b.status.Store(fmt.Sprintf("%s: %s", time.Now().Format("2006-01-02 15:04"), b.backfill(ctx)))
+	})
+	return nil
+}
+
+// Stop cancels any running backfill and waits for it to complete.
+func (b *backfiller) Stop(ctx context.Context) error {
+	if b.cancel != nil {
+		b.cancel()
+	}
+	return core.Wait(ctx, &b.wg)
+}
+
+func (b *backfiller) Status() string {
+	return b.status.Load().(string)
+}
+
+// backfill retrieves the yield archive of the inverters for the period
+// since the checkpoint was saved, and passes the combined values to the
+// core backfill handlers. The result is returned as a status string.
+func (b *backfiller) backfill(ctx context.Context) string {
+	last := b.last
+	if last.IsZero() {
+		log.Printf("sma: no checkpoint time, backfill skipped")
+		return "No checkpoint time, skipped"
+	}
+	now := b.d.Clock.Now()
+	if now.Sub(last) < backfillMinimum {
+		return "Not required"
+	}
+	from := last
+	if now.Sub(from) > maxBackfill {
+		from = now.Add(-maxBackfill)
+	}
+	// Start from midnight so that the daily yield can be calculated.
+	start := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, from.Location())
+	log.Printf("sma: backfilling from %s to %s", last.Format(time.UnixDate), now.Format(time.UnixDate))
+	totals := make(map[int64]float64)
+	counts := make(map[int64]int)
+	for _, s := range b.readers {
+		var ys []Yield
+		var err error
+		for range retries {
+			ys, err = s.archive(start, now)
+			if err == nil {
+				break
+			}
+			if !core.Sleep(ctx, backfillRetry) {
+				return "Cancelled"
+			}
+		}
+		if err != nil {
+			log.Printf("sma:%s: backfill: %v", s.name, err)
+			return fmt.Sprintf("Error - %v", err)
+		}
+		for _, y := range ys {
+			totals[y.Time.Unix()] += y.Total
+			counts[y.Time.Unix()]++
+		}
+	}
+	// Only use the intervals that are present for all the inverters.
+	var times []int64
+	for t, c := range counts {
+		if c == len(b.readers) {
+			times = append(times, t)
+		}
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+	var recs []core.BackfillRecord
+	var day, prev time.Time
+	var dayStart, prevTotal float64
+	for _, ts := range times {
+		t := time.Unix(ts, 0)
+		total := totals[ts]
+		if y, m, dd := t.Date(); day.IsZero() || y != day.Year() || m != day.Month() || dd != day.Day() {
+			day = t
+			dayStart = total
+		}
+		if t.After(last) && t.Before(now) {
+			r := core.BackfillRecord{
+				Time:   t,
+				Values: map[string]float64{core.A_GEN_TOTAL: total},
+				Daily:  map[string]float64{core.A_GEN_TOTAL: total - dayStart},
+			}
+			if gap := t.Sub(prev); !prev.IsZero() && gap <= maxArchiveGap {
+				r.Values[core.D_GEN_P] = (total - prevTotal) / gap.Hours()
+			}
+			recs = append(recs, r)
+		}
+		prev = t
+		prevTotal = total
+	}
+	log.Printf("sma: backfill of %d intervals", len(recs))
+	if len(recs) != 0 {
+		b.d.Backfill(ctx, recs)
+	}
+	return fmt.Sprintf("OK - %d intervals from %s", len(recs), last.Format("2006-01-02 15:04"))
+}
+
+// archive retrieves the 5 minute yield archive from the inverter.
+func (s *InverterReader) archive(from, to time.Time) ([]Yield, error) {
+	s.mu.Lock()
+	defer s.unlock()
+	if s.sma == nil {
+		return nil, errNotFound
+	}
+	if _, _, err := s.sma.Logon(); err != nil {
+		return nil, err
+	}
+	defer s.sma.Logoff()
+	ys, err := s.sma.Archive(from, to)
+	if err != nil {
+		return nil, fmt.Errorf("archive: %v", err)
+	}
+	return ys, nil
+}
@@ -0,0 +1,110 @@
+// Copyright 2026 Andrew McRae
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sma
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"time"
+)
+
+// Speedwire multicast group and port.
+const multicastAddr = "239.12.255.254:9522"
+const speedwirePort = 9522
+
+// Discovery request sent to the multicast group.
+var discoveryRequest = []byte{'S', 'M', 'A', 0, 0, 0x04, 0x02, 0xA0, 0xFF, 0xFF, 0xFF, 0xFF, 0, 0, 0, 0x20, 0, 0, 0, 0}
+
+// Device is a SMA device found via discovery.
+type Device struct {
+	Addr   string // Address and port of device
+	Susyid uint16 // System ID
+	Serial uint32 // Serial number
+}
+
+// Discover sends a discovery request to the Speedwire multicast group,
+// and returns the addresses of the devices that respond within the timeout.
+func Discover(timeout time.Duration) ([]string, error) {
+	maddr, err := net.ResolveUDPAddr("udp4", multicastAddr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if _, err := conn.WriteToUDP(discoveryRequest, maddr); err != nil {
+		return nil, fmt.Errorf("discovery request: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	found := make(map[string]struct{})
+	var addrs []string
+	b := make([]byte, maxPacketSize)
+	for {
+		n, from, err := conn.ReadFromUDP(b)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				break
+			}
+			return nil, fmt.Errorf("discovery response: %v", err)
+		}
+		if n < 4 || !bytes.Equal(b[:4], packet_header[:4]) {
+			continue
+		}
+		addr := fmt.Sprintf("%s:%d", from.IP.String(), speedwirePort)
+		if _, ok := found[addr]; !ok {
+			found[addr] = struct{}{}
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs, nil
+}
+
+// DiscoverInverters finds the inverters on the local network,
+// and logs on to each to retrieve the system ID and serial number.
+// Devices that cannot be logged on to (e.g energy meters) are skipped.
+// The inverters are returned sorted by serial number.
+func DiscoverInverters(password string, timeout time.Duration) ([]Device, error) {
+	addrs, err := Discover(timeout)
+	if err != nil {
+		return nil, err
+	}
+	var devs []Device
+	for _, a := range addrs {
+		s, err := NewSMA(a, password)
+		if err != nil {
+			log.Printf("sma: discovered %s: %v", a, err)
+			continue
+		}
+		s.Timeout = timeout
+		susyid, serial, err := s.Logon()
+		if err != nil {
+			log.Printf("sma: discovered %s: %v - skipped", a, err)
+			s.Close()
+			continue
+		}
+		s.Logoff()
+		s.Close()
+		devs = append(devs, Device{Addr: a, Susyid: susyid, Serial: serial})
+	}
+	sort.Slice(devs, func(i, j int) bool {
+		return devs[i].Serial < devs[j].Serial
+	})
+	return devs, nil
+}
@@ -18,124 +18,569 @@
 //     - addr: <inverter-name:udp-port>
 //       password: <password>
 //       retry: <poll-retry-seconds>
+//       installer: <true/false>
+//       telemetry: <true/false>
+//       phases: <number of AC phases>
+//       events: <true/false>
+//       backfill: <true/false>
 //     - ...
+//
+// or, to discover the inverters on the local network:
+//   sma:
+//     discover: true
+//     password: <password>
+//     backfill: <true/false>
+//     inverters:
+//       - addr: <inverter-name:udp-port>
+//       - ...
 
 package sma
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/aamcrae/MeterMan/core"
+	"gopkg.in/yaml.v3"
 )
 
 const retries = 3
 
-type Sma []struct {
-	Addr     string
-	Password string
-	Timeout  int
-	Volts    bool
-	Trace    bool
-	Dump     bool
+// Timeout for discovery responses.
+const discoveryTimeout = time.Second * 5
+
+// Interval between discovery when an inverter is not responding or has not been found.
+const rediscoverInterval = time.Minute * 15
+
+// Interval between discovery when all the inverters are responding.
+const discoverInterval = time.Hour
+
+// Checkpoint state key for the serial numbers of the discovered inverters.
+const serialsKey = "sma-serials"
+
+var errNotFound = errors.New("inverter not found")
+
+// Interval between reading the event log.
+const eventInterval = time.Minute * 5
+
+// Inverter is the configuration for a single inverter.
+type Inverter struct {
+	Addr      string
+	Password  string
+	Timeout   int
+	Volts     bool
+	Trace     bool
+	Dump      bool
+	Installer bool
+	Telemetry bool
+	Phases    int
+	Events    bool
+	Backfill  bool
+}
+
+type Sma []Inverter
+
+// SmaDiscover is the configuration when discovery is used.
+// The settings apply to all discovered inverters.
+type SmaDiscover struct {
+	Discover  bool
+	Password  string
+	Timeout   int
+	Volts     bool
+	Trace     bool
+	Dump      bool
+	Installer bool
+	Telemetry bool
+	Phases    int
+	Events    bool
+	Backfill  bool
+	Inverters Sma // Inverters with fixed addresses
 }
 
 // InverterReader polls the inverter(s)
 type InverterReader struct {
-	d   *core.DB // Database
-	sma *SMA     // Inverter object
+	d       *core.DB    // Database
+	sma     *SMA        // Inverter object
+	mu      sync.Mutex  // Serialises access to the inverter
+	conf    Inverter    // Inverter configuration
+	name    string      // Name used for status and events
+	serial  uint32      // Serial number, if discovered
+	stopped atomic.Bool // Set when the reader is stopped
+	failed  atomic.Bool // Set when the inverter is not responding
 	// Database element names. These are dynamically allocated.
-	genP     string       // Gauge for current power (Kw)
-	genDP    string       // Derived for daily yield (KwH)
-	volts    string       // Gauge for current voltage (V)
-	genDaily string       // Accum for daily yield (KwH)
-	genT     string       // Accum for lifetime yield (KwH)
-	mpttA    string       // MPTT A string
-	mpttB    string       // MPTT B string
-	status   atomic.Value // Current status
+	genP     string // Gauge for current power (Kw)
+	genDP    string // Derived for daily yield (KwH)
+	volts    string // Gauge for current voltage (V)
+	genDaily string // Accum for daily yield (KwH)
+	genT     string // Accum for lifetime yield (KwH)
+	mpttA    string // MPTT A string
+	mpttB    string // MPTT B string
+	// Detailed telemetry, if enabled.
+	telemetry bool
+	acP       []string // Per-phase AC power
+	acC       []string // Per-phase AC current
+	acV       []string // Per-phase AC voltage
+	dcV       []string // Per-string DC voltage
+	dcC       []string // Per-string DC current
+	freq      string   // Grid frequency
+	temp      string   // Internal temperature
+	invStatus string   // Operating status
+	riso      string   // Insulation resistance
+	// Event log, if enabled.
+	events    bool
+	lastEvent time.Time    // Time of most recent event read
+	lastRead  time.Time    // Time event log was last read
+	fault     string       // Most recent fault
+	status    atomic.Value // Current status
 }
 
 func init() {
-	core.RegisterInit(inverterReader)
+	core.RegisterExternal("sma", inverterReader)
 }
 
 // Initialise SMA reader(s).
-func inverterReader(d *core.DB) error {
-	var conf Sma
+func inverterReader(d *core.DB) (err error) {
 	c, ok := d.Config["sma"]
 	if !ok {
 		return nil
 	}
-	err := c.Decode(&conf)
+	// The config is either a list of inverters, or a discovery configuration.
+	var node yaml.Node
+	err = c.Decode(&node)
 	if err != nil {
 		return err
 	}
-	for index, e := range conf {
-		sma, err := NewSMA(e.Addr, e.Password)
+	var conf SmaDiscover
+	if node.Kind == yaml.MappingNode {
+		err = decode(&node, &conf)
+	} else {
+		err = decode(&node, &conf.Inverters)
+	}
+	if err != nil {
+		return err
+	}
+	var index int
+	var bf []*InverterReader // Inverters to be backfilled
+	defer func() {
+		if len(bf) != 0 && err == nil {
+			err = d.AddModule("SMA-backfill", newBackfiller(d, bf))
+		}
+	}()
+	for _, e := range conf.Inverters {
+		s, err := addInverter(d, index, e, 0)
 		if err != nil {
 			return err
 		}
-		sma.Timeout = core.ConfigOrDefault(time.Second*time.Duration(e.Timeout), sma.Timeout)
-		sma.Trace = e.Trace
-		sma.PktDump = e.Dump
-		s := &InverterReader{d: d, sma: sma}
-		// Allocate gauges etc. for the inverter.
-		s.genP = d.AddSubGauge(core.G_GEN_P, false)
-		if e.Volts {
-			s.volts = d.AddSubGauge(core.G_VOLTS, true)
-		}
-		s.genDaily = d.AddSubAccum(core.A_GEN_DAILY, true)
-		s.genT = d.AddSubAccum(core.A_GEN_TOTAL, false)
-		s.genDP = d.AddSubDiff(core.D_GEN_P, false)
-		mptt := fmt.Sprintf("%s-%d", core.G_MPTT, index)
-		s.mpttA = fmt.Sprintf("%s-A", mptt)
-		s.mpttB = fmt.Sprintf("%s-B", mptt)
-		s.status.Store("init")
-		d.AddGauge(s.mpttA)
-		d.AddGauge(s.mpttB)
-		nm := strings.Split(e.Addr, ":")[0]
-		d.AddStatusPrinter(fmt.Sprintf("SMA-%s", nm), s.Status)
-		log.Printf("Registered SMA inverter reader for %s (timeout %s)\n", s.sma.Name(), s.sma.Timeout.String())
-		if !d.Dryrun {
-			d.AddPoll(s.cbPoll)
+		if e.Backfill {
+			bf = append(bf, s)
 		}
+		index++
+	}
+	if !conf.Discover {
+		return nil
+	}
+	// The serial numbers of the discovered inverters are saved in the checkpoint
+	// in the order that they were first found, so that the tags allocated to each
+	// inverter (e.g MPTT-<n>-A) are stable even if the inverters are not
+	// found in later discoveries (e.g because they are asleep).
+	serials, err := loadSerials(d)
+	if err != nil {
+		return err
+	}
+	var devs []Device
+	if d.Dryrun {
+		log.Printf("SMA discovery skipped for dry run")
+	} else {
+		if !found.valid() {
+			// Discovery failures are not fatal, since discovery is retried.
+			devs, err := DiscoverInverters(conf.Password, discoveryTimeout)
+			if err != nil {
+				log.Printf("SMA discovery: %v", err)
+			} else {
+				found.save(devs)
+			}
+		}
+		devs = found.devices()
+	}
+	// New inverters are added in serial number order.
+	for _, dev := range devs {
+		if !slices.Contains(serials, dev.Serial) {
+			log.Printf("SMA discovery: new inverter at %s, susyid %d, serial %d", dev.Addr, dev.Susyid, dev.Serial)
+			serials = append(serials, dev.Serial)
+		}
+	}
+	saveSerials(d, serials)
+	disc := &discoverer{d: d, password: conf.Password, readers: make(map[uint32]*InverterReader), last: d.Clock.Now()}
+	for _, serial := range serials {
+		// Inverters that have not been found are added without an address,
+		// and are located when the discovery is retried.
+		var addr string
+		if i := slices.IndexFunc(devs, func(dev Device) bool { return dev.Serial == serial }); i >= 0 {
+			addr = devs[i].Addr
+		} else {
+			log.Printf("SMA discovery: inverter %d not found", serial)
+		}
+		e := Inverter{
+			Addr:      addr,
+			Password:  conf.Password,
+			Timeout:   conf.Timeout,
+			Volts:     conf.Volts,
+			Trace:     conf.Trace,
+			Dump:      conf.Dump,
+			Installer: conf.Installer,
+			Telemetry: conf.Telemetry,
+			Phases:    conf.Phases,
+			Events:    conf.Events,
+			Backfill:  conf.Backfill,
+		}
+		s, err := addInverter(d, index, e, serial)
+		if err != nil {
+			return err
+		}
+		if e.Backfill {
+			bf = append(bf, s)
+		}
+		disc.readers[serial] = s
+		index++
+	}
+	if !d.Dryrun {
+		d.AddPoll(disc.poll)
 	}
 	return nil
 }
 
+// loadSerials returns the serial numbers of the discovered inverters saved in the checkpoint.
+func loadSerials(d *core.DB) ([]uint32, error) {
+	st, _ := d.GetState(serialsKey)
+	var serials []uint32
+	for _, f := range strings.Fields(st) {
+		v, err := strconv.ParseUint(f, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("sma: checkpoint %s: %v", serialsKey, err)
+		}
+		serials = append(serials, uint32(v))
+	}
+	return serials, nil
+}
+
+// saveSerials saves the serial numbers of the discovered inverters in the checkpoint.
+func saveSerials(d *core.DB, serials []uint32) {
+	if len(serials) == 0 {
+		return
+	}
+	var l []string
+	for _, v := range serials {
+		l = append(l, strconv.FormatUint(uint64(v), 10))
+	}
+	d.SetState(serialsKey, strings.Join(l, " "))
+}
+
+// discovered holds the inverters found by the most recent discovery, so that
+// the readers can be created without repeating the discovery when the feature is restarted.
+type discovered struct {
+	mu   sync.Mutex
+	devs []Device
+	ok   bool // Set once a discovery has succeeded
+}
+
+var found discovered
+
+func (f *discovered) save(devs []Device) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.devs = devs
+	f.ok = true
+}
+
+func (f *discovered) devices() []Device {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.devs
+}
+
+func (f *discovered) valid() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.ok
+}
+
+// discoverer repeats the discovery to locate the inverters that have not been
+// found or are not responding, and to add new inverters.
+type discoverer struct {
+	d        *core.DB
+	password string
+	readers  map[uint32]*InverterReader // Readers of the discovered inverters, by serial number
+	running  atomic.Bool                // Set while a discovery is in progress
+	last     time.Time                  // Time of last discovery
+}
+
+// poll starts a discovery if the discovery interval has passed.
+// The discovery runs separately so that the export is not delayed.
+func (disc *discoverer) poll(ctx context.Context) {
+	if !disc.running.CompareAndSwap(false, true) {
+		return
+	}
+	interval := discoverInterval
+	for _, s := range disc.readers {
+		if s.failed.Load() {
+			interval = rediscoverInterval
+		}
+	}
+	now := disc.d.Clock.Now()
+	if now.Sub(disc.last) < interval {
+		disc.running.Store(false)
+		return
+	}
+	disc.last = now
+	go func() {
+		defer disc.running.Store(false)
+		disc.discover(ctx)
+	}()
+}
+
+// discover finds the inverters, updating the addresses of the known inverters.
+// If new inverters are found, the feature is restarted to add readers for them.
+func (disc *discoverer) discover(ctx context.Context) {
+	devs, err := DiscoverInverters(disc.password, discoveryTimeout)
+	if err != nil {
+		log.Printf("SMA discovery: %v", err)
+		return
+	}
+	found.save(devs)
+	var added bool
+	for _, dev := range devs {
+		if s, ok := disc.readers[dev.Serial]; ok {
+			s.relocate(dev.Addr)
+		} else {
+			added = true
+		}
+	}
+	if added {
+		disc.d.Execute(func() {
+			// The feature may have been stopped or restarted during the discovery.
+			if ctx.Err() == nil {
+				disc.d.Restart("sma")
+			}
+		})
+	}
+}
+
+// decode decodes the YAML node, rejecting unknown fields.
+func decode(node *yaml.Node, v any) error {
+	b, err := yaml.Marshal(node)
+	if err != nil {
+		return err
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	return dec.Decode(v)
+}
+
+// addInverter creates a reader for the inverter.
+// If the inverter was discovered, the serial number is used to name the inverter.
+// A discovered inverter that has not been found has no address.
+func addInverter(d *core.DB, index int, e Inverter, serial uint32) (*InverterReader, error) {
+	s := &InverterReader{d: d, conf: e, serial: serial}
+	if len(e.Addr) != 0 {
+		sma, err := e.newSMA()
+		if err != nil {
+			return nil, err
+		}
+		s.sma = sma
+	}
+	// Allocate gauges etc. for the inverter.
+	s.genP = d.AddSubGauge(core.G_GEN_P, false)
+	if e.Volts {
+		s.volts = d.AddSubGauge(core.G_VOLTS, true)
+	}
+	s.genDaily = d.AddSubAccum(core.A_GEN_DAILY, true)
+	s.genT = d.AddSubAccum(core.A_GEN_TOTAL, false)
+	s.genDP = d.AddSubDiff(core.D_GEN_P, false)
+	mptt := fmt.Sprintf("%s-%d", core.G_MPTT, index)
+	s.mpttA = fmt.Sprintf("%s-A", mptt)
+	s.mpttB = fmt.Sprintf("%s-B", mptt)
+	s.status.Store("init")
+	d.AddGauge(s.mpttA)
+	d.AddGauge(s.mpttB)
+	if e.Telemetry {
+		phases := core.ConfigOrDefault(e.Phases, 1) // Default of single phase
+		if phases < 1 || phases > 3 {
+			return nil, fmt.Errorf("sma: %s: invalid number of phases %d", e.Addr, phases)
+		}
+		s.telemetry = true
+		for p := range phases {
+			s.acP = append(s.acP, fmt.Sprintf("%s-%d-%d", core.G_AC_POWER, index, p+1))
+			s.acC = append(s.acC, fmt.Sprintf("%s-%d-%d", core.G_AC_CURRENT, index, p+1))
+			s.acV = append(s.acV, fmt.Sprintf("%s-%d-%d", core.G_AC_VOLTS, index, p+1))
+		}
+		for _, str := range []string{"A", "B"} {
+			s.dcV = append(s.dcV, fmt.Sprintf("%s-%d-%s", core.G_DC_VOLTS, index, str))
+			s.dcC = append(s.dcC, fmt.Sprintf("%s-%d-%s", core.G_DC_CURRENT, index, str))
+		}
+		s.freq = fmt.Sprintf("%s-%d", core.G_INV_FREQ, index)
+		s.temp = fmt.Sprintf("%s-%d", core.G_INV_TEMP, index)
+		s.invStatus = fmt.Sprintf("%s-%d", core.G_INV_STATUS, index)
+		s.riso = fmt.Sprintf("%s-%d", core.G_INV_RISO, index)
+		for _, l := range [][]string{s.acP, s.acC, s.acV, s.dcV, s.dcC, {s.freq, s.temp, s.invStatus, s.riso}} {
+			for _, tag := range l {
+				d.AddGauge(tag)
+			}
+		}
+	}
+	// Only events occurring after startup are reported.
+	s.events = e.Events
+	s.lastEvent = d.Clock.Now()
+	nm := strings.Split(e.Addr, ":")[0]
+	if serial != 0 {
+		nm = fmt.Sprint(serial)
+	}
+	s.name = fmt.Sprintf("SMA-%s", nm)
+	d.AddPowerLimiter(s.name, s)
+	log.Printf("Registered SMA inverter reader for %s (address %s)\n", s.name, core.ConfigOrDefault(e.Addr, "unknown"))
+	if !d.Dryrun {
+		d.AddPoll(s.cbPoll)
+	}
+	return s, d.AddModule(s.name, s)
+}
+
+// newSMA creates the inverter object from the configuration.
+func (e *Inverter) newSMA() (*SMA, error) {
+	sma, err := NewSMA(e.Addr, e.Password)
+	if err != nil {
+		return nil, err
+	}
+	sma.Timeout = core.ConfigOrDefault(time.Second*time.Duration(e.Timeout), sma.Timeout)
+	sma.Trace = e.Trace
+	sma.PktDump = e.Dump
+	sma.Installer = e.Installer
+	return sma, nil
+}
+
 // Status returns a string status for this inverter
 func (s *InverterReader) Status() string {
 	return s.status.Load().(string)
 }
 
-func (s *InverterReader) cbPoll() {
-	hour := time.Now().Hour()
+func (s *InverterReader) Start(ctx context.Context) error {
+	return nil
+}
+
+// Stop closes the connection to the inverter. If the inverter is in use,
+// the connection is closed once the poll (or other request) in progress completes,
+// so that the main thread is not blocked.
+func (s *InverterReader) Stop(ctx context.Context) error {
+	s.stopped.Store(true)
+	if s.mu.TryLock() {
+		s.close()
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+// unlock releases the inverter, closing the connection
+// if the reader was stopped while the inverter was in use.
+func (s *InverterReader) unlock() {
+	s.mu.Unlock()
+	if s.stopped.Load() && s.mu.TryLock() {
+		s.close()
+		s.mu.Unlock()
+	}
+}
+
+// close closes the connection to the inverter. Must be called with the lock held.
+func (s *InverterReader) close() {
+	if s.sma != nil {
+		s.sma.Close()
+		s.sma = nil
+	}
+}
+
+func (s *InverterReader) cbPoll(ctx context.Context) {
+	hour := s.d.Clock.Now().Hour()
 	daytime := hour >= s.d.StartHour && hour < s.d.EndHour
 	var err error
-	for _ = range retries {
-		err = s.poll(daytime)
+	for range retries {
+		var evs []core.Event
+		evs, err = s.poll(daytime)
+		// The events are posted once the inverter is released, since
+		// posting waits for the main thread, which may be stopping the reader.
+		for _, e := range evs {
+			if ctx.Err() == nil {
+				s.d.PostEvent(e)
+			}
+		}
 		if err == nil {
+			s.failed.Store(false)
 			return
 		}
-		time.Sleep(time.Second * 5)
+		if err == errNotFound {
+			break
+		}
+		if !core.Sleep(ctx, time.Second*5) {
+			return
+		}
+	}
+	// The discoverer retries sooner when an inverter is failing.
+	s.failed.Store(true)
+	if err != errNotFound {
+		log.Printf("Inverter poll error:%s - %v", s.name, err)
 	}
-	log.Printf("Inverter poll error:%s - %v", s.sma.Name(), err)
 }
 
-func (s *InverterReader) poll(daytime bool) error {
+// relocate sets the address of a discovered inverter, if the
+// inverter had not been found, or the address has changed.
+func (s *InverterReader) relocate(addr string) {
+	s.mu.Lock()
+	defer s.unlock()
+	if s.stopped.Load() || (s.sma != nil && s.conf.Addr == addr) {
+		return
+	}
+	if s.sma == nil {
+		log.Printf("sma: inverter %d found at %s", s.serial, addr)
+	} else {
+		log.Printf("sma: inverter %d moved from %s to %s", s.serial, s.conf.Addr, addr)
+	}
+	conf := s.conf
+	conf.Addr = addr
+	sma, err := conf.newSMA()
+	if err != nil {
+		log.Printf("sma: %s: %v", addr, err)
+		return
+	}
+	if s.sma != nil {
+		s.sma.Close()
+	}
+	s.sma = sma
+	s.conf = conf
+}
+
+// poll reads the values from the inverter, returning any new events.
+func (s *InverterReader) poll(daytime bool) (evs []core.Event, err error) {
 	if s.d.Trace {
-		log.Printf("Polling inverter %s", s.sma.Name())
+		log.Printf("Polling inverter %s", s.name)
+	}
+	s.mu.Lock()
+	defer s.unlock()
+	if s.sma == nil {
+		s.status.Store("Not found")
+		return nil, errNotFound
 	}
 	var b strings.Builder
 	defer func() { s.status.Store(b.String()) }()
-	fmt.Fprintf(&b, "%s: ", time.Now().Format("2006-01-02 15:04"))
-	_, _, err := s.sma.Logon()
+	fmt.Fprintf(&b, "%s: ", s.d.Clock.Now().Format("2006-01-02 15:04"))
+	_, _, err = s.sma.Logon()
 	if err != nil {
 		fmt.Fprintf(&b, "Error - %v", err)
-		return err
+		return evs, err
 	}
 	defer s.sma.Logoff()
 	fmt.Fprintf(&b, "OK")
@@ -164,13 +609,16 @@ func (s *InverterReader) poll(daytime bool) error {
 		s.d.Input(s.genT, t)
 		s.d.Input(s.genDP, t)
 	}
+	if s.events {
+		evs = s.pollEvents(&b)
+	}
 	if !daytime {
-		return nil // Some values are not available at night
+		return evs, nil // Some values are not available at night
 	}
 	if len(s.volts) != 0 {
 		v, err := s.sma.Voltage()
 		if err != nil {
-			return err
+			return evs, err
 		}
 		if v != 0 {
 			if s.d.Trace {
@@ -182,7 +630,7 @@ func (s *InverterReader) poll(daytime bool) error {
 	}
 	p, err := s.sma.Power()
 	if err != nil {
-		return err
+		return evs, err
 	}
 	pf := float64(p) / 1000
 	if s.d.Trace {
@@ -193,7 +641,7 @@ func (s *InverterReader) poll(daytime bool) error {
 
 	mptts, err := s.sma.MPTT()
 	if err != nil {
-		return err
+		return evs, err
 	}
 	if len(mptts) != 2 {
 		log.Printf("sma:%s: wrong len of mptt: %d - ignored", s.sma.Name(), len(mptts))
@@ -205,5 +653,166 @@ func (s *InverterReader) poll(daytime bool) error {
 		s.d.Input(s.mpttB, mptts[1])
 		fmt.Fprintf(&b, ", MPPT-A %s, MPTT-B %s", core.FmtFloat(mptts[0]), core.FmtFloat(mptts[1]))
 	}
+	if s.telemetry {
+		s.pollTelemetry(&b)
+	}
+	return evs, nil
+}
+
+// pollTelemetry retrieves the detailed telemetry values.
+// Values that cannot be retrieved are skipped.
+func (s *InverterReader) pollTelemetry(b *strings.Builder) {
+	st, err := s.sma.OperatingStatus()
+	if err != nil {
+		s.missing(s.invStatus, err)
+	} else {
+		var inv core.InvStatus
+		switch st {
+		case STATUS_OK:
+			inv = core.INV_OK
+		case STATUS_WARNING:
+			inv = core.INV_WARNING
+		case STATUS_FAULT:
+			inv = core.INV_FAULT
+		case STATUS_OFF:
+			inv = core.INV_OFF
+		}
+		s.input(s.invStatus, float64(inv))
+		if inv != core.INV_OK {
+			fmt.Fprintf(b, ", Status %d", st)
+		}
+	}
+	s.inputList(s.acP, s.sma.PhasePower)
+	s.inputList(s.acC, s.sma.PhaseCurrent)
+	s.inputList(s.acV, s.sma.PhaseVoltage)
+	s.inputList(s.dcV, s.sma.DCVoltage)
+	s.inputList(s.dcC, s.sma.DCCurrent)
+	for _, v := range []struct {
+		tag string
+		get func() (float64, error)
+	}{
+		{s.freq, s.sma.Frequency},
+		{s.temp, s.sma.Temperature},
+		{s.riso, s.sma.Insulation},
+	} {
+		val, err := v.get()
+		if err != nil {
+			s.missing(v.tag, err)
+			continue
+		}
+		s.input(v.tag, val)
+	}
+}
+
+// pollEvents reads the event log, and returns the new events.
+// The log is read at most every eventInterval.
+func (s *InverterReader) pollEvents(b *strings.Builder) []core.Event {
+	var events []core.Event
+	now := s.d.Clock.Now()
+	if now.Sub(s.lastRead) >= eventInterval {
+		s.lastRead = now
+		evs, err := s.sma.Events(s.lastEvent.Add(time.Second))
+		if err != nil {
+			log.Printf("sma:%s: events: %v", s.sma.Name(), err)
+		}
+		// Events are returned newest first, so report them in reverse order.
+		for i := len(evs) - 1; i >= 0; i-- {
+			e := &evs[i]
+			if e.Time.After(s.lastEvent) {
+				s.lastEvent = e.Time
+			}
+			ce := core.Event{
+				Time:    e.Time,
+				Source:  s.name,
+				Code:    int(e.Code),
+				Level:   core.EV_INFO,
+				Message: fmt.Sprintf("event %d", e.Code),
+			}
+			switch {
+			case e.Fault():
+				ce.Level = core.EV_FAULT
+			case e.Warning():
+				ce.Level = core.EV_WARNING
+			}
+			if e.Cleared() {
+				ce.Message += " cleared"
+			}
+			if ce.Level == core.EV_FAULT {
+				if e.Cleared() {
+					s.fault = ""
+				} else {
+					s.fault = fmt.Sprintf("%d at %s", e.Code, e.Time.Format("2006-01-02 15:04"))
+				}
+			}
+			events = append(events, ce)
+		}
+	}
+	if len(s.fault) != 0 {
+		fmt.Fprintf(b, ", Fault %s", s.fault)
+	}
+	return events
+}
+
+// inputList retrieves a set of values, and inputs them to the matching tags.
+func (s *InverterReader) inputList(tags []string, get func() ([]float64, error)) {
+	vals, err := get()
+	if err != nil {
+		s.missing(tags[0], err)
+		return
+	}
+	for i, tag := range tags {
+		if i < len(vals) {
+			s.input(tag, vals[i])
+		}
+	}
+}
+
+func (s *InverterReader) input(tag string, v float64) {
+	if s.d.Trace {
+		log.Printf("Tag %s = %g", tag, v)
+	}
+	s.d.Input(tag, v)
+}
+
+func (s *InverterReader) missing(tag string, err error) {
+	if s.d.Trace {
+		log.Printf("%s: Missing record for tag %s: %v", s.sma.Name(), tag, err)
+	}
+}
+
+// SetPowerLimit sets the active power limit of the inverter in watts.
+func (s *InverterReader) SetPowerLimit(watts float64) error {
+	return s.setLimit(func() error {
+		return s.sma.SetPowerLimit(watts)
+	})
+}
+
+// SetPowerLimitPercent sets the active power limit of the inverter
+// as a percentage of the nominal power.
+func (s *InverterReader) SetPowerLimitPercent(percent float64) error {
+	return s.setLimit(func() error {
+		return s.sma.SetPowerLimitPercent(percent)
+	})
+}
+
+// setLimit logs on to the inverter and sets the power limit.
+func (s *InverterReader) setLimit(set func() error) error {
+	s.mu.Lock()
+	defer s.unlock()
+	if s.sma == nil {
+		return errNotFound
+	}
+	if _, _, err := s.sma.Logon(); err != nil {
+		return err
+	}
+	defer s.sma.Logoff()
+	if err := set(); err != nil {
+		return err
+	}
+	limit, err := s.sma.PowerLimit()
+	if err != nil {
+		return err
+	}
+	log.Printf("sma:%s: active power limit set to %s W", s.sma.Name(), core.FmtFloat(limit))
 	return nil
 }
@@ -21,8 +21,10 @@ import (
 	"encoding/binary"
 	"fmt"
 	"log"
+	"math"
 	"math/rand"
 	"net"
+	"sort"
 	"sync/atomic"
 	"time"
 )
@@ -61,6 +63,61 @@ const (
 	CMD_AC_28     = 0x51000200 // AC spot values, 28 byte records
 	CMD_AC_40     = 0x51800200 // AC spot values, 40 byte records
 	CMD_DC_28     = 0x53800200 // DC spot values, 28 byte records
+	CMD_SET_PARAM = 0x6A000200 // Write parameter
+	CMD_EVENTS    = 0x70100200 // User event log
+	CMD_EVENTS_I  = 0x70120200 // Installer event log
+	CMD_ARCHIVE   = 0x70000200 // 5 minute yield archive
+	CMD_ARCHIVE_D = 0x70200200 // Daily yield archive
+)
+
+// Record IDs of parameters.
+const (
+	REC_NOMINAL_POWER = 0x411E // Nominal AC power (W)
+	REC_POWER_LIMIT   = 0x832A // Active power limit (W)
+)
+
+// Record IDs of detailed values.
+const (
+	REC_STATUS     = 0x2148 // Operating status
+	REC_TEMP       = 0x2377 // Internal temperature (C * 100)
+	REC_INSULATION = 0x254F // Insulation resistance (Ohm)
+	REC_DC_VOLTS   = 0x451F // DC voltage per string (V * 100)
+	REC_DC_CURRENT = 0x4521 // DC current per string (mA)
+	REC_AC_POWER   = 0x4640 // AC power of first phase (W)
+	REC_AC_VOLTS   = 0x4648 // AC voltage of first phase (V * 100)
+	REC_AC_CURRENT = 0x4653 // AC current of first phase (mA)
+	REC_FREQ       = 0x4657 // Grid frequency (Hz * 100)
+)
+
+// Operating status attributes.
+const (
+	STATUS_FAULT   = 35
+	STATUS_OFF     = 303
+	STATUS_OK      = 307
+	STATUS_WARNING = 455
+)
+
+// Event flags.
+const (
+	EVT_TYPE_MASK  = 0x0003 // Type of event
+	EVT_INCOMING   = 0x0000 // Event has occurred
+	EVT_OUTGOING   = 0x0001 // Event has cleared
+	EVT_LEVEL_MASK = 0x3000 // Severity of event
+	EVT_INFO       = 0x0000
+	EVT_WARNING    = 0x1000
+	EVT_ERROR      = 0x2000
+)
+
+// Size of an event log record.
+const eventRecSize = 48
+
+// Size of an archive record.
+const archiveRecSize = 12
+
+// Logon user groups.
+const (
+	GROUP_USER      = 0x7
+	GROUP_INSTALLER = 0xA
 )
 
 // Map of commands to record size.
@@ -88,9 +145,10 @@ type record struct {
 
 // SMA represents a single SMA SunnyBoy inverter.
 type SMA struct {
-	Timeout time.Duration // Timeout
-	Trace   bool          // Trace packets
-	PktDump bool          // Dump packet contents
+	Timeout   time.Duration // Timeout
+	Trace     bool          // Trace packets
+	PktDump   bool          // Dump packet contents
+	Installer bool          // Logon as installer, required to write some parameters
 
 	name      string       // device name or IP address
 	password  []byte       // device password
@@ -100,6 +158,41 @@ type SMA struct {
 	serial    uint32       // Serial number of device
 }
 
+// Event is an entry from the inverter event log.
+type Event struct {
+	Time      time.Time // Time of event
+	Entry     uint16    // Event log entry number
+	Code      uint16    // Event number
+	Flags     uint16    // Event flags
+	Group     uint32    // Event group
+	Tag       uint32    // Message ID
+	Counter   uint32    // Event counter
+	Parameter uint32    // Changed parameter, if any
+	NewValue  uint32    // New value of parameter
+	OldValue  uint32    // Old value of parameter
+}
+
+// Fault returns true if the event is an error.
+func (e *Event) Fault() bool {
+	return e.Flags&EVT_LEVEL_MASK == EVT_ERROR
+}
+
+// Warning returns true if the event is a warning.
+func (e *Event) Warning() bool {
+	return e.Flags&EVT_LEVEL_MASK == EVT_WARNING
+}
+
+// Cleared returns true if the event marks the end of a condition.
+func (e *Event) Cleared() bool {
+	return e.Flags&EVT_TYPE_MASK == EVT_OUTGOING
+}
+
+// Yield is an entry from the yield archive.
+type Yield struct {
+	Time  time.Time // Time of entry
+	Total float64   // Lifetime yield (KwH)
+}
+
 type request struct {
 	packet_id uint16
 	buf       *bytes.Buffer
@@ -156,7 +249,11 @@ func (s *SMA) Logon() (uint16, uint32, error) {
 	// logon to the inverter.
 	r := s.packet(14, 0xA0, 0x100)
 	binary.Write(r.buf, binary.LittleEndian, uint32(0xFFFD040C))
-	binary.Write(r.buf, binary.LittleEndian, uint32(0x7))               // group = USER
+	group := uint32(GROUP_USER)
+	if s.Installer {
+		group = GROUP_INSTALLER
+	}
+	binary.Write(r.buf, binary.LittleEndian, group)                     // group
 	binary.Write(r.buf, binary.LittleEndian, uint32(900))               // Timeout
 	binary.Write(r.buf, binary.LittleEndian, uint32(time.Now().Unix())) // Time
 	binary.Write(r.buf, binary.LittleEndian, uint32(0))                 // ?
@@ -204,14 +301,14 @@ func (s *SMA) Close() {
 
 // Retrieve the current status of the inverter.
 func (s *SMA) DeviceStatus() (string, error) {
-	recs, err := s.getRecords(CMD_AC_40, 0x00214800, 0x002148FF)
+	recs, err := s.getRecords(CMD_AC_40, uint32(REC_STATUS)<<8, uint32(REC_STATUS)<<8|0xFF)
 	if err != nil {
 		return "", fmt.Errorf("device status: %v", err)
 	}
 	if s.Trace {
 		dumpRecords("Device status", recs)
 	}
-	rl, ok := recs[0x2148]
+	rl, ok := recs[REC_STATUS]
 	if !ok {
 		return "", fmt.Errorf("device status: missing record")
 	}
@@ -219,13 +316,13 @@ func (s *SMA) DeviceStatus() (string, error) {
 	var status string
 	for i, at := range r.attribute {
 		switch at {
-		case 35:
+		case STATUS_FAULT:
 			status = status + fmt.Sprintf("[Alarm: %d]", r.attrVal[i])
-		case 303:
+		case STATUS_OFF:
 			status = status + fmt.Sprintf("[Off: %d]", r.attrVal[i])
-		case 307:
+		case STATUS_OK:
 			status = status + fmt.Sprintf("[OK: %d]", r.attrVal[i])
-		case 455:
+		case STATUS_WARNING:
 			status = status + fmt.Sprintf("[Warning: %d]", r.attrVal[i])
 		default:
 			status = status + fmt.Sprintf("Unknown(%d): %d]", at, r.attrVal[i])
@@ -234,8 +331,67 @@ func (s *SMA) DeviceStatus() (string, error) {
 	return status, nil
 }
 
+// Return the operating status attribute (e.g STATUS_OK) that is currently set.
+func (s *SMA) OperatingStatus() (uint32, error) {
+	recs, err := s.getRecords(CMD_AC_40, uint32(REC_STATUS)<<8, uint32(REC_STATUS)<<8|0xFF)
+	if err != nil {
+		return 0, fmt.Errorf("operating status: %v", err)
+	}
+	rl, ok := recs[REC_STATUS]
+	if !ok {
+		return 0, fmt.Errorf("operating status: missing record")
+	}
+	r := rl[0]
+	for i, at := range r.attribute {
+		if r.attrVal[i] == 1 {
+			return at, nil
+		}
+	}
+	return 0, fmt.Errorf("operating status: no status selected")
+}
+
 func (s *SMA) Voltage() (float64, error) {
-	return s.getValue(CMD_AC_28, 0x4648, 100.0)
+	return s.getValue(CMD_AC_28, REC_AC_VOLTS, 100.0)
+}
+
+// Return per-phase AC power in Kw.
+func (s *SMA) PhasePower() ([]float64, error) {
+	return s.getRange(CMD_AC_28, REC_AC_POWER, 3, 1000.0)
+}
+
+// Return per-phase AC voltage.
+func (s *SMA) PhaseVoltage() ([]float64, error) {
+	return s.getRange(CMD_AC_28, REC_AC_VOLTS, 3, 100.0)
+}
+
+// Return per-phase AC current in amps.
+func (s *SMA) PhaseCurrent() ([]float64, error) {
+	return s.getRange(CMD_AC_28, REC_AC_CURRENT, 3, 1000.0)
+}
+
+// Return grid frequency in Hz.
+func (s *SMA) Frequency() (float64, error) {
+	return s.getValue(CMD_AC_28, REC_FREQ, 100.0)
+}
+
+// Return DC strings voltage.
+func (s *SMA) DCVoltage() ([]float64, error) {
+	return s.getValues(CMD_DC_28, REC_DC_VOLTS, 100.0)
+}
+
+// Return DC strings current in amps.
+func (s *SMA) DCCurrent() ([]float64, error) {
+	return s.getValues(CMD_DC_28, REC_DC_CURRENT, 1000.0)
+}
+
+// Return internal temperature in degrees C.
+func (s *SMA) Temperature() (float64, error) {
+	return s.getValue(CMD_SPOT_28, REC_TEMP, 100.0)
+}
+
+// Return insulation resistance in kOhm.
+func (s *SMA) Insulation() (float64, error) {
+	return s.getValue(CMD_DC_28, REC_INSULATION, 1000.0)
 }
 
 // Return daily yield in KwH
@@ -258,6 +414,140 @@ func (s *SMA) MPTT() ([]float64, error) {
 	return s.getValues(CMD_DC_28, 0x251E, 1000.0)
 }
 
+// Return nominal power in watts.
+func (s *SMA) NominalPower() (float64, error) {
+	return s.getValue(CMD_AC_28, REC_NOMINAL_POWER, 1.0)
+}
+
+// Return the active power limit in watts.
+func (s *SMA) PowerLimit() (float64, error) {
+	return s.getValue(CMD_AC_28, REC_POWER_LIMIT, 1.0)
+}
+
+// Set the active power limit in watts.
+func (s *SMA) SetPowerLimit(watts float64) error {
+	if watts < 0 || watts > math.MaxInt32 {
+		return fmt.Errorf("set power limit: invalid limit %g", watts)
+	}
+	if err := s.setParam(REC_POWER_LIMIT, uint32(math.Round(watts))); err != nil {
+		return fmt.Errorf("set power limit: %v", err)
+	}
+	return nil
+}
+
+// Set the active power limit as a percentage of the nominal power.
+func (s *SMA) SetPowerLimitPercent(percent float64) error {
+	if percent < 0 || percent > 100 {
+		return fmt.Errorf("set power limit: invalid percentage %g", percent)
+	}
+	nominal, err := s.NominalPower()
+	if err != nil {
+		return fmt.Errorf("nominal power: %v", err)
+	}
+	return s.SetPowerLimit(nominal * percent / 100)
+}
+
+// Events retrieves the entries in the event log that occurred since the time given.
+// The installer event log is read if the SMA is configured for installer logon.
+// The events are returned in the order they are reported by the inverter (newest first).
+func (s *SMA) Events(since time.Time) ([]Event, error) {
+	cmd := uint32(CMD_EVENTS)
+	if s.Installer {
+		cmd = CMD_EVENTS_I
+	}
+	r := s.packet(9, 0xE0, 0)
+	binary.Write(r.buf, binary.LittleEndian, cmd)
+	binary.Write(r.buf, binary.LittleEndian, uint32(since.Unix()))      // Start time
+	binary.Write(r.buf, binary.LittleEndian, uint32(time.Now().Unix())) // End time
+	if s.Trace {
+		log.Printf("%s: Requesting events since %s", s.name, since.Format(time.RFC822))
+	}
+	if err := s.send(r); err != nil {
+		return nil, fmt.Errorf("events: %v", err)
+	}
+	bList, err := s.response(r)
+	if err != nil {
+		return nil, fmt.Errorf("events: %v", err)
+	}
+	var evs []Event
+	for _, b := range bList {
+		// Skip headers.
+		b.Next(54)
+		for b.Len() >= eventRecSize {
+			rec := b.Next(eventRecSize)
+			t := binary.LittleEndian.Uint32(rec[0:])
+			if t == 0 {
+				break
+			}
+			e := Event{
+				Time:      time.Unix(int64(t), 0),
+				Entry:     binary.LittleEndian.Uint16(rec[4:]),
+				Code:      binary.LittleEndian.Uint16(rec[12:]),
+				Flags:     binary.LittleEndian.Uint16(rec[14:]),
+				Group:     binary.LittleEndian.Uint32(rec[16:]),
+				Tag:       binary.LittleEndian.Uint32(rec[24:]),
+				Counter:   binary.LittleEndian.Uint32(rec[28:]),
+				Parameter: binary.LittleEndian.Uint32(rec[36:]),
+				NewValue:  binary.LittleEndian.Uint32(rec[40:]),
+				OldValue:  binary.LittleEndian.Uint32(rec[44:]),
+			}
+			// The time range is not always honoured.
+			if e.Time.Before(since) {
+				continue
+			}
+			evs = append(evs, e)
+		}
+	}
+	return evs, nil
+}
+
+// Archive retrieves the 5 minute yield archive entries between the times given.
+func (s *SMA) Archive(from, to time.Time) ([]Yield, error) {
+	return s.getArchive(CMD_ARCHIVE, from, to)
+}
+
+// DailyArchive retrieves the daily yield archive entries between the times given.
+func (s *SMA) DailyArchive(from, to time.Time) ([]Yield, error) {
+	return s.getArchive(CMD_ARCHIVE_D, from, to)
+}
+
+// getArchive requests the archive entries, returning them in time order.
+// Entries without a valid value are skipped.
+func (s *SMA) getArchive(cmd uint32, from, to time.Time) ([]Yield, error) {
+	r := s.packet(9, 0xE0, 0)
+	binary.Write(r.buf, binary.LittleEndian, cmd)
+	binary.Write(r.buf, binary.LittleEndian, uint32(from.Unix()))
+	binary.Write(r.buf, binary.LittleEndian, uint32(to.Unix()))
+	if s.Trace {
+		log.Printf("%s: Requesting archive 0x%08x from %s to %s", s.name, cmd, from.Format(time.RFC822), to.Format(time.RFC822))
+	}
+	if err := s.send(r); err != nil {
+		return nil, fmt.Errorf("archive: %v", err)
+	}
+	bList, err := s.response(r)
+	if err != nil {
+		return nil, fmt.Errorf("archive: %v", err)
+	}
+	var ys []Yield
+	for _, b := range bList {
+		// Skip headers.
+		b.Next(54)
+		for b.Len() >= archiveRecSize {
+			rec := b.Next(archiveRecSize)
+			t := binary.LittleEndian.Uint32(rec[0:])
+			v := binary.LittleEndian.Uint64(rec[4:])
+			if t == 0 || v == 0 || v == nan64 || v == nanu64 {
+				continue
+			}
+			ys = append(ys, Yield{Time: time.Unix(int64(t), 0), Total: float64(v) / 1000})
+		}
+	}
+	sort.Slice(ys, func(i, j int) bool {
+		return ys[i].Time.Before(ys[j].Time)
+	})
+	return ys, nil
+}
+
 // Debug code to retrieve all records.
 func (s *SMA) GetAll() error {
 	codes := []uint32{CMD_AC_28, CMD_AC_40, CMD_INV_40, CMD_DC_28, CMD_AC_16, CMD_INV_40}
@@ -301,6 +591,28 @@ func (s *SMA) getValues(cmd uint32, id uint16, scale float64) ([]float64, error)
 	}
 }
 
+// Get scaled float values from a range of consecutive record IDs.
+// The first value of each record is returned.
+func (s *SMA) getRange(cmd uint32, id uint16, count int, scale float64) ([]float64, error) {
+	last := id + uint16(count-1)
+	recs, err := s.getRecords(cmd, uint32(id)<<8, uint32(last)<<8|0xFF)
+	if err != nil {
+		return nil, err
+	}
+	var vals []float64
+	for i := range count {
+		vr, ok := recs[id+uint16(i)]
+		if !ok {
+			if s.Trace {
+				log.Printf("%s: getRange: missing record (0x%04x)", s.name, id+uint16(i))
+			}
+			return nil, fmt.Errorf("getRange: missing record")
+		}
+		vals = append(vals, float64(vr[0].value)/scale)
+	}
+	return vals, nil
+}
+
 // getRecords retrieves the requested records from the inverter,
 // returning the records in a map keyed by the record ID.
 func (s *SMA) getRecords(code, a1, a2 uint32) (map[uint16][]*record, error) {
@@ -322,6 +634,37 @@ func (s *SMA) getRecords(code, a1, a2 uint32) (map[uint16][]*record, error) {
 	return m, nil
 }
 
+// setParam writes a parameter value to the inverter.
+// The value is written as a 28 byte record, with the
+// value repeated in each of the value fields.
+func (s *SMA) setParam(id uint16, value uint32) error {
+	r := s.packet(14, 0xA0, 0x100)
+	binary.Write(r.buf, binary.LittleEndian, uint32(CMD_SET_PARAM))
+	r.buf.WriteByte(0x01)                                               // Class
+	binary.Write(r.buf, binary.LittleEndian, id)                        // Record ID
+	r.buf.WriteByte(DT_ULONG)                                           // Data type
+	binary.Write(r.buf, binary.LittleEndian, uint32(time.Now().Unix())) // Time
+	for range 4 {
+		binary.Write(r.buf, binary.LittleEndian, value)
+	}
+	binary.Write(r.buf, binary.LittleEndian, uint32(1))
+	if s.Trace {
+		log.Printf("%s: Setting parameter 0x%04x to %d", s.name, id, value)
+	}
+	if err := s.send(r); err != nil {
+		return err
+	}
+	b, err := s.response(r)
+	if err != nil {
+		return err
+	}
+	retCode := binary.LittleEndian.Uint16(b[0].Bytes()[36:])
+	if retCode != 0 {
+		return fmt.Errorf("parameter 0x%04x: write failed, retCode = %04x", id, retCode)
+	}
+	return nil
+}
+
 // cmdPacket creates and sends a request to the inverter.
 func (s *SMA) cmdPacket(cmd, first, last uint32) (*request, error) {
 	r := s.packet(9, 0xA0, 0)
@@ -0,0 +1,127 @@
+// Copyright 2026 Andrew McRae
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package smaem
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// Energy meter protocol ID.
+const protocolEmeter = 0x6069
+
+// Offset of the OBIS data in the datagram.
+const obisOffset = 28
+
+// OBIS measurement types.
+const (
+	T_ACTUAL  = 4 // Actual value, 4 bytes
+	T_COUNTER = 8 // Counter value, 8 bytes
+)
+
+// OBIS measurement indices.
+const (
+	O_POWER_IN   = 1  // Active power + (0.1W), energy + (Ws)
+	O_POWER_OUT  = 2  // Active power - (0.1W), energy - (Ws)
+	O_FREQ       = 14 // Grid frequency (0.001Hz)
+	O_L1_IN      = 21 // Phase 1 active power + (0.1W), energy + (Ws)
+	O_L1_OUT     = 22 // Phase 1 active power - (0.1W), energy - (Ws)
+	O_L1_CURRENT = 31 // Phase 1 current (mA)
+	O_L1_VOLTS   = 32 // Phase 1 voltage (mV)
+	phaseStride  = 20 // Offset between phases
+	O_VERSION    = 144
+)
+
+// Obis identifies a measurement in the datagram.
+type Obis struct {
+	Index byte // Measurement index
+	Type  byte // Measurement type (actual or counter)
+}
+
+// Frame is a decoded energy meter datagram.
+type Frame struct {
+	Susyid uint16          // System ID
+	Serial uint32          // Serial number
+	Ticker uint32          // Millisecond ticker
+	Values map[Obis]uint64 // Measurement values
+}
+
+// Decode decodes an energy meter datagram.
+func Decode(b []byte) (*Frame, error) {
+	if len(b) < obisOffset || !bytes.Equal(b[:4], []byte{'S', 'M', 'A', 0}) {
+		return nil, fmt.Errorf("not a SMA datagram")
+	}
+	length := int(binary.BigEndian.Uint16(b[12:]))
+	if proto := binary.BigEndian.Uint16(b[16:]); proto != protocolEmeter {
+		return nil, fmt.Errorf("unknown protocol 0x%04x", proto)
+	}
+	// The length covers the data from the tag at offset 14.
+	end := min(14+2+length, len(b))
+	f := &Frame{
+		Susyid: binary.BigEndian.Uint16(b[18:]),
+		Serial: binary.BigEndian.Uint32(b[20:]),
+		Ticker: binary.BigEndian.Uint32(b[24:]),
+		Values: make(map[Obis]uint64),
+	}
+	for i := obisOffset; i+4 <= end; {
+		index, typ := b[i+1], b[i+2]
+		i += 4
+		size := int(typ)
+		if index == O_VERSION {
+			size = 4 // Software version
+		} else if index == 0 && typ == 0 {
+			break // End of data
+		}
+		if size != T_ACTUAL && size != T_COUNTER {
+			return nil, fmt.Errorf("OBIS index %d: unknown type %d", index, typ)
+		}
+		if i+size > end {
+			return nil, fmt.Errorf("OBIS index %d: truncated datagram", index)
+		}
+		var v uint64
+		if size == T_ACTUAL {
+			v = uint64(binary.BigEndian.Uint32(b[i:]))
+		} else {
+			v = binary.BigEndian.Uint64(b[i:])
+		}
+		f.Values[Obis{index, typ}] = v
+		i += size
+	}
+	return f, nil
+}
+
+// Power returns the actual power value in kW.
+func (f *Frame) Power(index byte) (float64, bool) {
+	v, ok := f.Values[Obis{index, T_ACTUAL}]
+	return float64(v) / 10000, ok
+}
+
+// Energy returns the counter value in kWh.
+func (f *Frame) Energy(index byte) (float64, bool) {
+	v, ok := f.Values[Obis{index, T_COUNTER}]
+	return float64(v) / 3600000, ok
+}
+
+// Milli returns an actual value that is in thousandths of a unit.
+func (f *Frame) Milli(index byte) (float64, bool) {
+	v, ok := f.Values[Obis{index, T_ACTUAL}]
+	return float64(v) / 1000, ok
+}
+
+// Phase returns the measurement index for a phase (0 based).
+func Phase(index byte, phase int) byte {
+	return index + byte(phase*phaseStride)
+}
@@ -0,0 +1,118 @@
+// Copyright 2026 Andrew McRae
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package smaem
+
+import (
+	"encoding/binary"
+	"maps"
+
+	"testing"
+)
+
+// obis is a measurement in a test datagram.
+type obis struct {
+	index, typ byte
+	value      uint64
+}
+
+// datagram builds an energy meter datagram, with the length adjusted by extra bytes.
+func datagram(extra int, values ...obis) []byte {
+	b := []byte{'S', 'M', 'A', 0, 0, 4, 0x02, 0xA0, 0, 0, 0, 1, 0, 0, 0, 0x10}
+	b = binary.BigEndian.AppendUint16(b, protocolEmeter)
+	b = binary.BigEndian.AppendUint16(b, 349)
+	b = binary.BigEndian.AppendUint32(b, 3001234567)
+	b = binary.BigEndian.AppendUint32(b, 1000)
+	for _, v := range values {
+		b = append(b, 0, v.index, v.typ, 0)
+		if v.typ == T_COUNTER {
+			b = binary.BigEndian.AppendUint64(b, v.value)
+		} else {
+			b = binary.BigEndian.AppendUint32(b, uint32(v.value))
+		}
+	}
+	b = append(b, 0, 0, 0, 0)
+	binary.BigEndian.PutUint16(b[12:], uint16(len(b)-16+extra))
+	return b
+}
+
+func TestDecode(t *testing.T) {
+	notSMA := datagram(0)
+	notSMA[0] = 'X'
+	badProto := datagram(0)
+	badProto[17] = 0
+	for _, v := range []struct {
+		name string
+		b    []byte
+		want map[Obis]uint64
+		err  bool
+	}{
+		{"empty", datagram(0), map[Obis]uint64{}, false},
+		{"values", datagram(0, obis{O_POWER_IN, T_ACTUAL, 12345}, obis{O_POWER_IN, T_COUNTER, 36000000}, obis{O_VERSION, 0, 0x02001252}),
+			map[Obis]uint64{{O_POWER_IN, T_ACTUAL}: 12345, {O_POWER_IN, T_COUNTER}: 36000000, {O_VERSION, 0}: 0x02001252}, false},
+		// Data after the length is ignored.
+		{"short length", datagram(-16, obis{O_POWER_IN, T_ACTUAL, 1}, obis{O_POWER_OUT, T_COUNTER, 2}),
+			map[Obis]uint64{{O_POWER_IN, T_ACTUAL}: 1}, false},
+		{"truncated", datagram(0, obis{O_POWER_IN, T_COUNTER, 1})[:36], nil, true},
+		{"unknown type", datagram(0, obis{O_POWER_IN, 7, 1}), nil, true},
+		{"not SMA", notSMA, nil, true},
+		{"protocol", badProto, nil, true},
+		{"too short", datagram(0)[:20], nil, true},
+	} {
+		f, err := Decode(v.b)
+		if (err != nil) != v.err {
+			t.Errorf("%s: error %v", v.name, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if f.Susyid != 349 || f.Serial != 3001234567 || f.Ticker != 1000 {
+			t.Errorf("%s: susyid %d, serial %d, ticker %d", v.name, f.Susyid, f.Serial, f.Ticker)
+		}
+		if !maps.Equal(f.Values, v.want) {
+			t.Errorf("%s: got %v want %v", v.name, f.Values, v.want)
+		}
+	}
+}
+
+func TestScaling(t *testing.T) {
+	f, err := Decode(datagram(0,
+		obis{O_POWER_IN, T_ACTUAL, 12345},
+		obis{O_POWER_IN, T_COUNTER, 36000000},
+		obis{O_FREQ, T_ACTUAL, 50012},
+		obis{Phase(O_L1_VOLTS, 2), T_ACTUAL, 239875}))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	for _, v := range []struct {
+		name string
+		get  func(byte) (float64, bool)
+		obis byte
+		want float64
+		ok   bool
+	}{
+		{"power", f.Power, O_POWER_IN, 1.2345, true},
+		{"energy", f.Energy, O_POWER_IN, 10, true},
+		{"frequency", f.Milli, O_FREQ, 50.012, true},
+		{"L3 volts", f.Milli, O_L1_VOLTS + 2*phaseStride, 239.875, true},
+		{"missing", f.Power, O_POWER_OUT, 0, false},
+		{"missing counter", f.Energy, O_FREQ, 0, false},
+	} {
+		got, ok := v.get(v.obis)
+		if got != v.want || ok != v.ok {
+			t.Errorf("%s: got %g, %v want %g, %v", v.name, got, ok, v.want, v.ok)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+// Copyright 2026 Andrew McRae
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// package smaem receives the datagrams multicast by a SMA Energy Meter
+// or Sunny Home Manager on the Speedwire network.
+// The package is configured as a section in the YAML config file:
+//   smaem:
+//     serial: <serial number of meter>
+//     interface: <network interface name>
+//     phases: <number of phases>
+//
+// The values from the most recent datagram are used:
+// Active power (kW) -> G_IN_POWER, G_OUT_POWER
+// Energy (kWh) -> A_IN_TOTAL, A_OUT_TOTAL
+// Per-phase energy (kWh) -> A_IMPORT/n, A_EXPORT/n
+// Per-phase voltage (V) -> G_VOLTS (averaged)
+// Current (A) -> G_IN_CURRENT, G_OUT_CURRENT
+// Frequency (Hertz) -> G_FREQ
+
+package smaem
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aamcrae/MeterMan/core"
+)
+
+type Smaem struct {
+	Serial    uint32
+	Interface string
+	Phases    int
+}
+
+const moduleName = "smaem"
+
+// Speedwire multicast group and port.
+const multicastAddr = "239.12.255.254:9522"
+
+const maxPacketSize = 2048
+
+type emeter struct {
+	d       *core.DB
+	serial  uint32
+	ifname  string
+	phases  int
+	volts   []string     // Per-phase voltage sub-gauges
+	imports []string     // Per-phase import sub-accumulators
+	exports []string     // Per-phase export sub-accumulators
+	conn    *net.UDPConn // Multicast connection
+	mu      sync.Mutex
+	last    *Frame    // Most recent frame received
+	lastT   time.Time // Time the most recent frame was received
+	status  atomic.Value
+}
+
+func init() {
+	core.RegisterExternal(moduleName, emReader)
+}
+
+// Set up receiving the energy meter datagrams, if the config exists for it.
+func emReader(d *core.DB) error {
+	var conf Smaem
+	c, ok := d.Config[moduleName]
+	if !ok {
+		return nil
+	}
+	err := c.Decode(&conf)
+	if err != nil {
+		return err
+	}
+	phases := core.ConfigOrDefault(conf.Phases, 3) // Default of 3 phases
+	if phases < 1 || phases > 3 {
+		return fmt.Errorf("smaem: invalid number of phases %d", phases)
+	}
+	em := &emeter{d: d, serial: conf.Serial, ifname: conf.Interface, phases: phases}
+	em.status.Store("init")
+	if conf.Serial != 0 {
+		log.Printf("Registered SMA Energy Meter receiver for serial %d, %d phases", conf.Serial, phases)
+	} else {
+		log.Printf("Registered SMA Energy Meter receiver, %d phases", phases)
+	}
+	if !d.Dryrun {
+		d.AddGauge(core.G_IN_CURRENT)
+		d.AddGauge(core.G_OUT_CURRENT)
+		d.AddGauge(core.G_IN_POWER)
+		d.AddGauge(core.G_OUT_POWER)
+		d.AddAccum(core.A_IN_TOTAL, true)
+		d.AddAccum(core.A_OUT_TOTAL, true)
+		d.AddGauge(core.G_FREQ)
+		for range phases {
+			em.volts = append(em.volts, d.AddSubGauge(core.G_VOLTS, true))
+			em.imports = append(em.imports, d.AddSubAccum(core.A_IMPORT, true))
+			em.exports = append(em.exports, d.AddSubAccum(core.A_EXPORT, true))
+		}
+		d.AddPoll(em.poll)
+	}
+	return d.AddModule(moduleName, em)
+}
+
+// Start joins the multicast group and starts receiving the datagrams.
+func (em *emeter) Start(ctx context.Context) error {
+	conn, err := em.listen()
+	if err != nil {
+		return err
+	}
+	em.conn = conn
+	go em.receive(ctx, conn)
+	return nil
+}
+
+// Stop closes the connection, which terminates the receiver.
+func (em *emeter) Stop(ctx context.Context) error {
+	if em.conn == nil {
+		return nil
+	}
+	return em.conn.Close()
+}
+
+func (em *emeter) Status() string {
+	return em.status.Load().(string)
+}
+
+// listen joins the multicast group.
+func (em *emeter) listen() (*net.UDPConn, error) {
+	addr, err := net.ResolveUDPAddr("udp4", multicastAddr)
+	if err != nil {
+		return nil, err
+	}
+	var ifi *net.Interface
+	if len(em.ifname) != 0 {
+		ifi, err = net.InterfaceByName(em.ifname)
+		if err != nil {
+			return nil, fmt.Errorf("smaem: %s: %v", em.ifname, err)
+		}
+	}
+	conn, err := net.ListenMulticastUDP("udp4", ifi, addr)
+	if err != nil {
+		return nil, fmt.Errorf("smaem: %v", err)
+	}
+	return conn, nil
+}
+
+// receive reads the datagrams and saves the most recent frame from the meter.
+// The datagrams are sent frequently (e.g every second), so the values
+// are only input to the database when polled.
+func (em *emeter) receive(ctx context.Context, conn *net.UDPConn) {
+	b := make([]byte, maxPacketSize)
+	for {
+		n, from, err := conn.ReadFromUDP(b)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("smaem: read: %v", err)
+			if !core.Sleep(ctx, time.Second*5) {
+				return
+			}
+			continue
+		}
+		f, err := Decode(b[:n])
+		if err != nil {
+			// Other devices (e.g inverters) also use the multicast group.
+			if em.d.Trace {
+				log.Printf("smaem: %s: %v", from.String(), err)
+			}
+			continue
+		}
+		if em.serial != 0 && f.Serial != em.serial {
+			continue
+		}
+		em.mu.Lock()
+		if em.last == nil || em.last.Serial != f.Serial {
+			log.Printf("smaem: receiving from meter at %s, susyid %d, serial %d", from.IP.String(), f.Susyid, f.Serial)
+		}
+		em.last = f
+		em.lastT = em.d.Clock.Now()
+		em.mu.Unlock()
+	}
+}
+
+// poll inputs the values from the most recent frame.
+func (em *emeter) poll(ctx context.Context) {
+	em.mu.Lock()
+	f, lt := em.last, em.lastT
+	em.mu.Unlock()
+	var b strings.Builder
+	defer func() { em.status.Store(b.String()) }()
+	fmt.Fprintf(&b, "%s: ", time.Now().Format("2006-01-02 15:04"))
+	if f == nil {
+		fmt.Fprintf(&b, "No data received")
+		return
+	}
+	if age := em.d.Clock.Now().Sub(lt); age > time.Minute {
+		fmt.Fprintf(&b, "No data received for %s", age.Truncate(time.Second).String())
+		return
+	}
+	in, okIn := f.Power(O_POWER_IN)
+	out, okOut := f.Power(O_POWER_OUT)
+	inT, okInT := f.Energy(O_POWER_IN)
+	outT, okOutT := f.Energy(O_POWER_OUT)
+	if !(okIn && okOut && okInT && okOutT) {
+		fmt.Fprintf(&b, "Missing values")
+		log.Printf("smaem: serial %d: missing values", f.Serial)
+		return
+	}
+	fmt.Fprintf(&b, "OK - serial %d, in %s, out %s, imp %s, exp %s", f.Serial,
+		core.FmtFloat(in), core.FmtFloat(out), core.FmtFloat(inT), core.FmtFloat(outT))
+	if em.d.Trace {
+		log.Printf("smaem: In power %gkW, Out power %gkW, Import %gkWh, Export %gkWh", in, out, inT, outT)
+	}
+	em.d.Input(core.G_IN_POWER, in)
+	em.d.Input(core.G_OUT_POWER, out)
+	em.d.Input(core.A_IN_TOTAL, inT)
+	em.d.Input(core.A_OUT_TOTAL, outT)
+	var current float64
+	for p := range em.phases {
+		if v, ok := f.Energy(Phase(O_L1_IN, p)); ok {
+			em.d.Input(em.imports[p], v)
+		}
+		if v, ok := f.Energy(Phase(O_L1_OUT, p)); ok {
+			em.d.Input(em.exports[p], v)
+		}
+		if v, ok := f.Milli(Phase(O_L1_VOLTS, p)); ok && v != 0 {
+			em.d.Input(em.volts[p], v)
+			fmt.Fprintf(&b, ", L%d %sV", p+1, core.FmtFloat(v))
+		}
+		if c, ok := f.Milli(Phase(O_L1_CURRENT, p)); ok {
+			current += c
+		}
+	}
+	// The current is unsigned, so use the power to determine the direction.
+	if out > 0 {
+		em.d.Input(core.G_IN_CURRENT, 0.0)
+		em.d.Input(core.G_OUT_CURRENT, current)
+	} else {
+		em.d.Input(core.G_OUT_CURRENT, 0.0)
+		em.d.Input(core.G_IN_CURRENT, current)
+	}
+	if v, ok := f.Milli(O_FREQ); ok && v != 0 {
+		em.d.Input(core.G_FREQ, v)
+		fmt.Fprintf(&b, ", %sHz", core.FmtFloat(v))
+	}
+}
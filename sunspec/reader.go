@@ -0,0 +1,205 @@
+// Copyright 2026 Andrew McRae
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// package sunspec implements reading telemetry data from SunSpec compliant
+// inverters using Modbus TCP. The SunSpec models present on the device
+// are discovered, so that no device specific configuration is required.
+//
+// The package is configured as a section in the YAML config file:
+//  sunspec:
+//    - name: <inverter name>
+//      addr: <host:port>
+//      unit: <modbus unit id>
+//      base: <address of SunSpec marker>
+//      timeout: <timeout in seconds>
+//      trace: <true/false>
+//    ...
+
+package sunspec
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/aamcrae/MeterMan/core"
+)
+
+const retries = 3
+
+type Sunspec []struct {
+	Name    string
+	Addr    string
+	Unit    int
+	Base    int
+	Timeout int
+	Trace   bool
+}
+
+// SunspecReader polls a SunSpec inverter
+type SunspecReader struct {
+	d       *core.DB     // Database
+	name    string       // Inverter name
+	dev     *Device      // Device object
+	genP    string       // Sub-gauge for AC power (kW)
+	genT    string       // Accum for lifetime yield (KwH)
+	genDP   string       // Diff for average power
+	mptt    []string     // MPPT gauges, added when the models are discovered
+	battery bool         // Set if the battery gauge has been added
+	found   bool         // Set once the models have been discovered
+	status  atomic.Value // Current status
+}
+
+func init() {
+	core.RegisterExternal("sunspec", sunspecReader)
+}
+
+// Initialise SunSpec reader(s).
+func sunspecReader(d *core.DB) error {
+	var conf Sunspec
+	c, ok := d.Config["sunspec"]
+	if !ok {
+		return nil
+	}
+	err := c.Decode(&conf)
+	if err != nil {
+		return err
+	}
+	for _, e := range conf {
+		if len(e.Addr) == 0 {
+			return fmt.Errorf("sunspec: missing inverter address")
+		}
+		if e.Base < 0 || e.Base > math.MaxUint16 {
+			return fmt.Errorf("sunspec: %s: invalid base address %d", e.Addr, e.Base)
+		}
+		name := core.ConfigOrDefault(e.Name, strings.Split(e.Addr, ":")[0])
+		unit := uint8(core.ConfigOrDefault(e.Unit, 1)) // Default unit id of 1
+		dev := NewDevice(e.Addr, unit, uint16(e.Base))
+		dev.Timeout = core.ConfigOrDefault(time.Second*time.Duration(e.Timeout), dev.Timeout)
+		dev.Trace = e.Trace
+		s := &SunspecReader{d: d, name: name, dev: dev}
+		// Allocate gauges etc. for the inverter. The MPPT and battery
+		// gauges are added once the models present are known.
+		s.genP = d.AddSubGauge(core.G_GEN_P, false)
+		s.genT = d.AddSubAccum(core.A_GEN_TOTAL, false)
+		s.genDP = d.AddSubDiff(core.D_GEN_P, false)
+		s.status.Store("init")
+		d.AddStatusPrinter(fmt.Sprintf("SunSpec-%s", name), s.Status)
+		log.Printf("Registered SunSpec inverter reader for %s (timeout %s)\n", dev.Name(), dev.Timeout.String())
+		if !d.Dryrun {
+			d.AddPoll(s.cbPoll)
+		}
+	}
+	return nil
+}
+
+// Status returns a string status for this inverter
+func (s *SunspecReader) Status() string {
+	return s.status.Load().(string)
+}
+
+func (s *SunspecReader) cbPoll(ctx context.Context) {
+	var err error
+	for _ = range retries {
+		err = s.poll()
+		if err == nil || !core.Sleep(ctx, time.Second*5) {
+			return
+		}
+	}
+	log.Printf("Inverter poll error:%s - %v", s.dev.Name(), err)
+}
+
+func (s *SunspecReader) poll() error {
+	if s.d.Trace {
+		log.Printf("Polling SunSpec inverter %s", s.dev.Name())
+	}
+	var b strings.Builder
+	defer func() { s.status.Store(b.String()) }()
+	fmt.Fprintf(&b, "%s: ", time.Now().Format("2006-01-02 15:04"))
+	v, err := s.dev.Read()
+	if err != nil {
+		fmt.Fprintf(&b, "Error - %v", err)
+		return err
+	}
+	if !s.found {
+		s.discovered(v)
+	}
+	fmt.Fprintf(&b, "OK")
+	if len(s.dev.Model) != 0 {
+		fmt.Fprintf(&b, " (%s %s)", s.dev.Manufacturer, s.dev.Model)
+	}
+	if !math.IsNaN(v.Total) {
+		if s.d.Trace {
+			log.Printf("Tag %s Total yield = %g", s.genT, v.Total)
+		}
+		s.d.Input(s.genT, v.Total)
+		s.d.Input(s.genDP, v.Total)
+		fmt.Fprintf(&b, ", Total %s", core.FmtFloat(v.Total))
+	}
+	if !math.IsNaN(v.Power) {
+		if s.d.Trace {
+			log.Printf("Tag %s power = %g", s.genP, v.Power)
+		}
+		s.d.Input(s.genP, v.Power)
+		fmt.Fprintf(&b, ", Power %s", core.FmtFloat(v.Power))
+	}
+	for i, p := range v.Mppt {
+		if i >= len(s.mptt) || math.IsNaN(p) {
+			continue
+		}
+		if s.d.Trace {
+			log.Printf("Tag %s = %g", s.mptt[i], p)
+		}
+		s.d.Input(s.mptt[i], p)
+		fmt.Fprintf(&b, ", MPPT-%c %s", 'A'+i, core.FmtFloat(p))
+	}
+	if s.battery && !math.IsNaN(v.Battery) {
+		if s.d.Trace {
+			log.Printf("Tag %s = %g", core.G_BATT_PERCENT, v.Battery)
+		}
+		s.d.Input(core.G_BATT_PERCENT, v.Battery)
+		fmt.Fprintf(&b, ", Battery %s%%", core.FmtFloat(v.Battery))
+	}
+	return nil
+}
+
+// discovered adds the elements for the models found on the inverter.
+// The elements are added in the main thread.
+func (s *SunspecReader) discovered(v *Values) {
+	var ids []string
+	for _, m := range s.dev.Models {
+		ids = append(ids, fmt.Sprint(m.ID))
+	}
+	log.Printf("sunspec: %s: %s %s, serial %s, models %s", s.dev.Name(), s.dev.Manufacturer, s.dev.Model, s.dev.Serial, strings.Join(ids, ","))
+	var mptt []string
+	for i := range v.Mppt {
+		mptt = append(mptt, fmt.Sprintf("%s-%s-%c", core.G_MPTT, s.name, 'A'+i))
+	}
+	battery := s.dev.HasModel(M_BATTERY, M_STORAGE)
+	s.d.Execute(func() {
+		for _, tag := range mptt {
+			s.d.AddGauge(tag)
+		}
+		if battery {
+			s.d.AddGauge(core.G_BATT_PERCENT)
+		}
+	})
+	s.mptt = mptt
+	s.battery = battery
+	s.found = true
+}
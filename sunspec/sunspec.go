@@ -0,0 +1,337 @@
+// Copyright 2026 Andrew McRae
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sunspec
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aldas/go-modbus-client"
+)
+
+// SunSpec model IDs
+const (
+	M_COMMON    = 1   // Common model
+	M_INV_1PH   = 101 // Single phase inverter
+	M_INV_SPLIT = 102 // Split phase inverter
+	M_INV_3PH   = 103 // Three phase inverter
+	M_STORAGE   = 124 // Basic storage controls
+	M_MPPT      = 160 // Multiple MPPT inverter extension
+	M_BATTERY   = 802 // Battery base model
+	M_END       = 0xFFFF
+)
+
+// Offsets of points within the model data (after the ID and length).
+const (
+	// Inverter models 101 - 103
+	inv_W     = 12
+	inv_W_SF  = 13
+	inv_WH    = 22
+	inv_WH_SF = 24
+	inv_len   = 25
+	// MPPT model 160
+	mppt_DCW_SF   = 2
+	mppt_N        = 6
+	mppt_module   = 8  // Start of repeating modules
+	mppt_mod_len  = 20 // Length of each module
+	mppt_mod_DCW  = 11 // Offset of DC power within module
+	mppt_fixedlen = 8
+	// Storage model 124
+	stor_ChaState    = 6
+	stor_ChaState_SF = 20
+	stor_len         = 21
+	// Battery model 802
+	batt_SoC    = 9
+	batt_SoC_SF = 54
+	batt_len    = 55
+)
+
+// Maximum number of models in the chain.
+const maxModels = 100
+
+// Base addresses that are checked for the SunSpec marker.
+var baseAddresses = []uint16{40000, 0, 50000}
+
+// Model is a SunSpec model located on the device.
+type Model struct {
+	ID     uint16
+	Addr   uint16 // Address of the model data
+	Length uint16 // Length of the model data
+}
+
+// Values holds the values read from the device.
+// Values that are not available are set to NaN.
+type Values struct {
+	Power   float64   // AC power (kW)
+	Total   float64   // Lifetime energy (kWh)
+	Mppt    []float64 // DC power per MPPT (kW)
+	Battery float64   // Battery state of charge (%)
+}
+
+// Device is a SunSpec compliant Modbus TCP device.
+type Device struct {
+	Timeout time.Duration // Timeout
+	Trace   bool
+	addr    string
+	unit    uint8
+	base    uint16
+	client  *modbus.Client
+
+	Models       []Model // Models found, in chain order
+	Manufacturer string
+	Model        string
+	Serial       string
+}
+
+// NewDevice creates a new SunSpec device. If base is non-zero,
+// it is the address of the SunSpec marker, otherwise the
+// common base addresses are scanned for the marker.
+func NewDevice(addr string, unit uint8, base uint16) *Device {
+	return &Device{
+		Timeout: time.Second * 10,
+		addr:    addr,
+		unit:    unit,
+		base:    base,
+		client:  modbus.NewTCPClient(),
+	}
+}
+
+// Name returns the address of the device.
+func (dev *Device) Name() string {
+	return dev.addr
+}
+
+// Read retrieves the current values from the device.
+// If the models have not yet been discovered, the model chain is scanned first.
+func (dev *Device) Read() (*Values, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dev.Timeout)
+	defer cancel()
+	if err := dev.client.Connect(ctx, dev.addr); err != nil {
+		return nil, fmt.Errorf("connect to %s: %w", dev.addr, err)
+	}
+	defer dev.client.Close()
+	if len(dev.Models) == 0 {
+		if err := dev.scan(ctx); err != nil {
+			return nil, err
+		}
+	}
+	v := &Values{Power: math.NaN(), Total: math.NaN(), Battery: math.NaN()}
+	if m, ok := dev.find(M_INV_1PH, M_INV_SPLIT, M_INV_3PH); ok {
+		r, err := dev.readModel(ctx, m, inv_len)
+		if err != nil {
+			return nil, err
+		}
+		v.Power = scaled(int16Val(r[inv_W]), r[inv_W_SF]) / 1000
+		v.Total = scaled(acc32Val(r[inv_WH], r[inv_WH+1]), r[inv_WH_SF]) / 1000
+	}
+	if m, ok := dev.find(M_MPPT); ok {
+		r, err := dev.readModel(ctx, m, m.Length)
+		if err != nil {
+			return nil, err
+		}
+		n := min(int(r[mppt_N]), (len(r)-mppt_fixedlen)/mppt_mod_len)
+		for i := range n {
+			p := r[mppt_module+i*mppt_mod_len+mppt_mod_DCW]
+			v.Mppt = append(v.Mppt, scaled(uint16Val(p), r[mppt_DCW_SF])/1000)
+		}
+	}
+	if m, ok := dev.find(M_BATTERY); ok {
+		r, err := dev.readModel(ctx, m, batt_len)
+		if err != nil {
+			return nil, err
+		}
+		v.Battery = scaled(uint16Val(r[batt_SoC]), r[batt_SoC_SF])
+	} else if m, ok := dev.find(M_STORAGE); ok {
+		r, err := dev.readModel(ctx, m, stor_len)
+		if err != nil {
+			return nil, err
+		}
+		v.Battery = scaled(uint16Val(r[stor_ChaState]), r[stor_ChaState_SF])
+	}
+	return v, nil
+}
+
+// HasModel returns true if one of the models is present on the device.
+func (dev *Device) HasModel(ids ...uint16) bool {
+	_, ok := dev.find(ids...)
+	return ok
+}
+
+// find returns the first model matching one of the IDs.
+func (dev *Device) find(ids ...uint16) (Model, bool) {
+	for _, m := range dev.Models {
+		for _, id := range ids {
+			if m.ID == id {
+				return m, true
+			}
+		}
+	}
+	return Model{}, false
+}
+
+// scan locates the SunSpec marker and walks the model chain.
+func (dev *Device) scan(ctx context.Context) error {
+	return dev.scanRegs(func(start, n uint16) ([]uint16, error) {
+		return dev.read(ctx, start, n)
+	})
+}
+
+// scanRegs locates the models using the function to read the registers.
+func (dev *Device) scanRegs(read func(start, n uint16) ([]uint16, error)) error {
+	bases := baseAddresses
+	if dev.base != 0 {
+		bases = []uint16{dev.base}
+	}
+	var addr uint16
+	var found bool
+	for _, b := range bases {
+		r, err := read(b, 2)
+		if err != nil {
+			if dev.Trace {
+				log.Printf("sunspec: %s: base %d: %v", dev.addr, b, err)
+			}
+			continue
+		}
+		// Check for "SunS"
+		if r[0] == 0x5375 && r[1] == 0x6E53 {
+			addr = b + 2
+			found = true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("%s: SunSpec marker not found", dev.addr)
+	}
+	var models []Model
+	for range maxModels {
+		r, err := read(addr, 2)
+		if err != nil {
+			return fmt.Errorf("model header at %d: %w", addr, err)
+		}
+		if r[0] == M_END {
+			break
+		}
+		m := Model{ID: r[0], Addr: addr + 2, Length: r[1]}
+		if int(m.Addr)+int(m.Length) > math.MaxUint16 {
+			return fmt.Errorf("model %d at %d: invalid length %d", m.ID, addr, m.Length)
+		}
+		models = append(models, m)
+		if dev.Trace {
+			log.Printf("sunspec: %s: model %d at %d, length %d", dev.addr, m.ID, m.Addr, m.Length)
+		}
+		addr = m.Addr + m.Length
+	}
+	dev.Models = models
+	if m, ok := dev.find(M_COMMON); ok && m.Length >= 66 {
+		r, err := read(m.Addr, 66)
+		if err != nil {
+			return err
+		}
+		dev.Manufacturer = regString(r[0:16])
+		dev.Model = regString(r[16:32])
+		dev.Serial = regString(r[48:64])
+	}
+	return nil
+}
+
+// readModel reads the first n registers of the model data.
+func (dev *Device) readModel(ctx context.Context, m Model, n uint16) ([]uint16, error) {
+	if m.Length < n {
+		return nil, fmt.Errorf("model %d: length %d too short", m.ID, m.Length)
+	}
+	return dev.read(ctx, m.Addr, n)
+}
+
+// read reads a block of holding registers.
+func (dev *Device) read(ctx context.Context, start, n uint16) ([]uint16, error) {
+	b := modbus.NewRequestBuilder(dev.addr, dev.unit)
+	for i := range n {
+		b.AddField(modbus.Field{Name: strconv.Itoa(int(i)), Type: modbus.FieldTypeUint16, Address: start + i})
+	}
+	reqs, err := b.ReadHoldingRegistersTCP()
+	if err != nil {
+		return nil, err
+	}
+	regs := make([]uint16, n)
+	for _, req := range reqs {
+		resp, err := dev.client.Do(ctx, req)
+		if err != nil {
+			return nil, fmt.Errorf("req failed: %w", err)
+		}
+		results, err := req.ExtractFields(resp, true)
+		if err != nil {
+			return nil, fmt.Errorf("extract fields: %w", err)
+		}
+		for _, f := range results {
+			if f.Error != nil {
+				return nil, fmt.Errorf("register %d: %w", f.Field.Address, f.Error)
+			}
+			i, err := strconv.Atoi(f.Field.Name)
+			if err != nil || i < 0 || i >= len(regs) {
+				return nil, fmt.Errorf("unknown field name: %s", f.Field.Name)
+			}
+			regs[i] = f.Value.(uint16)
+		}
+	}
+	return regs, nil
+}
+
+// scaled applies the scale factor to the value.
+func scaled(v float64, sf uint16) float64 {
+	if sf == 0x8000 {
+		return math.NaN()
+	}
+	return v * math.Pow10(int(int16(sf)))
+}
+
+// int16Val returns a signed value, or NaN if not implemented.
+func int16Val(r uint16) float64 {
+	if r == 0x8000 {
+		return math.NaN()
+	}
+	return float64(int16(r))
+}
+
+// uint16Val returns an unsigned value, or NaN if not implemented.
+func uint16Val(r uint16) float64 {
+	if r == 0xFFFF {
+		return math.NaN()
+	}
+	return float64(r)
+}
+
+// acc32Val returns an accumulated value, or NaN if not implemented.
+func acc32Val(hi, lo uint16) float64 {
+	v := uint32(hi)<<16 | uint32(lo)
+	if v == 0 {
+		return math.NaN()
+	}
+	return float64(v)
+}
+
+// regString converts a set of registers to a string.
+func regString(r []uint16) string {
+	var b strings.Builder
+	for _, v := range r {
+		b.WriteByte(byte(v >> 8))
+		b.WriteByte(byte(v))
+	}
+	return strings.TrimSpace(strings.TrimRight(b.String(), "\x00"))
+}
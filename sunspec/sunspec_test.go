@@ -0,0 +1,135 @@
+// Copyright 2026 Andrew McRae
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sunspec
+
+import (
+	"fmt"
+	"slices"
+
+	"testing"
+)
+
+// regMap holds the values of the holding registers, by address.
+type regMap map[uint16]uint16
+
+func (rm regMap) read(start, n uint16) ([]uint16, error) {
+	var r []uint16
+	for i := range n {
+		v, ok := rm[start+i]
+		if !ok {
+			return nil, fmt.Errorf("register %d: illegal address", start+i)
+		}
+		r = append(r, v)
+	}
+	return r, nil
+}
+
+// chain returns the registers of a SunSpec marker at the base address, followed by
+// the models (each given as the ID and length) and the end marker.
+func chain(base uint16, models ...[2]uint16) regMap {
+	rm := regMap{base: 0x5375, base + 1: 0x6E53}
+	addr := base + 2
+	for _, m := range models {
+		rm[addr], rm[addr+1] = m[0], m[1]
+		for i := range m[1] {
+			rm[addr+2+i] = 0
+		}
+		addr += 2 + m[1]
+	}
+	rm[addr], rm[addr+1] = M_END, 0
+	return rm
+}
+
+// setString sets registers to a string, 2 characters per register.
+func (rm regMap) setString(addr uint16, s string) regMap {
+	for i := 0; i < len(s); i += 2 {
+		v := uint16(s[i]) << 8
+		if i+1 < len(s) {
+			v |= uint16(s[i+1])
+		}
+		rm[addr+uint16(i/2)] = v
+	}
+	return rm
+}
+
+func TestScan(t *testing.T) {
+	truncated := chain(40000, [2]uint16{103, 50})
+	delete(truncated, 40054)
+	for _, v := range []struct {
+		name   string
+		regs   regMap
+		base   uint16
+		want   []Model
+		err    bool
+		manuf  string
+		serial string
+	}{
+		{
+			name:   "inverter",
+			regs:   chain(40000, [2]uint16{M_COMMON, 66}, [2]uint16{M_INV_3PH, 50}, [2]uint16{M_MPPT, 48}).setString(40004, "Fronius").setString(40052, "1234567"),
+			want:   []Model{{M_COMMON, 40004, 66}, {M_INV_3PH, 40072, 50}, {M_MPPT, 40124, 48}},
+			manuf:  "Fronius",
+			serial: "1234567",
+		},
+		{
+			name: "base 0",
+			regs: chain(0, [2]uint16{M_INV_1PH, 50}),
+			want: []Model{{M_INV_1PH, 4, 50}},
+		},
+		{
+			name: "configured base",
+			regs: chain(50000, [2]uint16{M_COMMON, 10}, [2]uint16{M_BATTERY, 55}),
+			base: 50000,
+			want: []Model{{M_COMMON, 50004, 10}, {M_BATTERY, 50016, 55}},
+		},
+		{
+			name: "not at configured base",
+			regs: chain(40000, [2]uint16{M_INV_1PH, 50}),
+			base: 50000,
+			err:  true,
+		},
+		{
+			name: "no marker",
+			regs: regMap{40000: 0x5375, 40001: 0},
+			err:  true,
+		},
+		{
+			name: "invalid length",
+			regs: regMap{50000: 0x5375, 50001: 0x6E53, 50002: M_INV_1PH, 50003: 20000},
+			err:  true,
+		},
+		{
+			name: "truncated chain",
+			regs: truncated,
+			err:  true,
+		},
+	} {
+		dev := &Device{addr: v.name, base: v.base}
+		err := dev.scanRegs(v.regs.read)
+		if (err != nil) != v.err {
+			t.Errorf("%s: error %v", v.name, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if !slices.Equal(dev.Models, v.want) {
+			t.Errorf("%s: got %v want %v", v.name, dev.Models, v.want)
+		}
+		if dev.Manufacturer != v.manuf || dev.Serial != v.serial {
+			t.Errorf("%s: manufacturer %q, serial %q want %q, %q", v.name, dev.Manufacturer, dev.Serial, v.manuf, v.serial)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+// Copyright 2026 Andrew McRae
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tariff
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Period is the configuration of a rate that applies on selected
+// days between a start and end time.
+type Period struct {
+	Days  string  // Days the rate applies e.g "mon-fri" or "sat,sun", default all days
+	Start string  // Start time as HH:MM, default 00:00
+	End   string  // End time as HH:MM, default 24:00
+	Rate  float64 // Rate per KwH
+}
+
+// Season is the configuration of the rates applying between 2 dates.
+type Season struct {
+	Name   string
+	From   string   // Start date as MM-DD
+	To     string   // End date (inclusive) as MM-DD
+	Rates  []Period // Import rates
+	Feedin []Period // Feed-in rates
+}
+
+// rate is a parsed rate period.
+type rate struct {
+	days  [7]bool // Days of week the rate applies
+	start int     // Start time as minutes since midnight
+	end   int     // End time as minutes since midnight
+	rate  float64
+}
+
+// schedule is a parsed list of rate periods.
+type schedule []rate
+
+// season is a parsed season.
+type season struct {
+	name   string
+	from   int // Start date as month*100 + day
+	to     int // End date as month*100 + day
+	rates  schedule
+	feedin schedule
+}
+
+var dayNames = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// newSchedule parses a list of rate periods.
+func newSchedule(pl []Period) (schedule, error) {
+	var s schedule
+	for _, p := range pl {
+		var r rate
+		var err error
+		if r.days, err = parseDays(p.Days); err != nil {
+			return nil, err
+		}
+		if r.start, err = parseTime(p.Start, 0); err != nil {
+			return nil, err
+		}
+		if r.end, err = parseTime(p.End, 24*60); err != nil {
+			return nil, err
+		}
+		if r.start == r.end {
+			return nil, fmt.Errorf("%s-%s: empty period", p.Start, p.End)
+		}
+		r.rate = p.Rate
+		s = append(s, r)
+	}
+	return s, nil
+}
+
+// lookup returns the rate of the first period matching the time.
+func (s schedule) lookup(t time.Time) (float64, bool) {
+	m := t.Hour()*60 + t.Minute()
+	for _, r := range s {
+		if r.match(t.Weekday(), m) {
+			return r.rate, true
+		}
+	}
+	return 0, false
+}
+
+// match returns true if the rate applies at the day and time.
+// If the end time is before the start time, the period wraps past midnight,
+// and the part after midnight applies on the day after the selected days.
+func (r *rate) match(wd time.Weekday, m int) bool {
+	if r.start < r.end {
+		return r.days[wd] && m >= r.start && m < r.end
+	}
+	if m >= r.start {
+		return r.days[wd]
+	}
+	return m < r.end && r.days[(wd+6)%7]
+}
+
+// parseDays parses a list of days or day ranges e.g "mon-fri,sun".
+// An empty string selects all days.
+func parseDays(s string) ([7]bool, error) {
+	var days [7]bool
+	if len(s) == 0 {
+		for i := range days {
+			days[i] = true
+		}
+		return days, nil
+	}
+	for _, f := range strings.Split(strings.ToLower(s), ",") {
+		first, last, isRange := strings.Cut(strings.TrimSpace(f), "-")
+		if !isRange {
+			last = first
+		}
+		d1, ok1 := dayNames[strings.TrimSpace(first)]
+		d2, ok2 := dayNames[strings.TrimSpace(last)]
+		if !ok1 || !ok2 {
+			return days, fmt.Errorf("%s: unknown day", f)
+		}
+		for d := d1; ; d = (d + 1) % 7 {
+			days[d] = true
+			if d == d2 {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+// parseTime parses a time as HH:MM, returning the minutes since midnight.
+func parseTime(s string, def int) (int, error) {
+	if len(s) == 0 {
+		return def, nil
+	}
+	var h, m int
+	if n, err := fmt.Sscanf(s, "%d:%d", &h, &m); n != 2 || err != nil || h < 0 || m < 0 || m > 59 || h*60+m > 24*60 {
+		return 0, fmt.Errorf("%s: invalid time", s)
+	}
+	return h*60 + m, nil
+}
+
+// parseDate parses a date as MM-DD, returning month*100 + day.
+func parseDate(s string) (int, error) {
+	var m, d int
+	if n, err := fmt.Sscanf(s, "%d-%d", &m, &d); n != 2 || err != nil || m < 1 || m > 12 || d < 1 || d > 31 {
+		return 0, fmt.Errorf("%s: invalid date", s)
+	}
+	return m*100 + d, nil
+}
+
+// newSeason parses a season.
+func newSeason(c Season) (*season, error) {
+	s := &season{name: c.Name}
+	var err error
+	if s.from, err = parseDate(c.From); err != nil {
+		return nil, fmt.Errorf("season %s: %v", c.Name, err)
+	}
+	if s.to, err = parseDate(c.To); err != nil {
+		return nil, fmt.Errorf("season %s: %v", c.Name, err)
+	}
+	if s.rates, err = newSchedule(c.Rates); err != nil {
+		return nil, fmt.Errorf("season %s: %v", c.Name, err)
+	}
+	if s.feedin, err = newSchedule(c.Feedin); err != nil {
+		return nil, fmt.Errorf("season %s: %v", c.Name, err)
+	}
+	return s, nil
+}
+
+// active returns true if the date is within the season.
+// If the end date is before the start date, the season wraps past the end of the year.
+func (s *season) active(t time.Time) bool {
+	d := int(t.Month())*100 + t.Day()
+	if s.from <= s.to {
+		return d >= s.from && d <= s.to
+	}
+	return d >= s.from || d <= s.to
+}
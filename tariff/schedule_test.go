@@ -0,0 +1,119 @@
+// Copyright 2026 Andrew McRae
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tariff
+
+import (
+	"time"
+
+	"testing"
+)
+
+// weekdays returns the selection of the days.
+func weekdays(wd ...time.Weekday) [7]bool {
+	var days [7]bool
+	for _, d := range wd {
+		days[d] = true
+	}
+	return days
+}
+
+func TestParseDays(t *testing.T) {
+	all := weekdays(time.Sunday, time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday)
+	for _, v := range []struct {
+		days string
+		want [7]bool
+		err  bool
+	}{
+		{"", all, false},
+		{"mon", weekdays(time.Monday), false},
+		{"mon-fri", weekdays(time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday), false},
+		{"Sat, SUN", weekdays(time.Saturday, time.Sunday), false},
+		{"fri-mon", weekdays(time.Friday, time.Saturday, time.Sunday, time.Monday), false},
+		{"sun-sat", all, false},
+		{"wed-wed", weekdays(time.Wednesday), false},
+		{"mon-tue, thu - fri", weekdays(time.Monday, time.Tuesday, time.Thursday, time.Friday), false},
+		{"monday", [7]bool{}, true},
+		{"mon-", [7]bool{}, true},
+		{"mon,,tue", [7]bool{}, true},
+	} {
+		got, err := parseDays(v.days)
+		if (err != nil) != v.err || (err == nil && got != v.want) {
+			t.Errorf("parseDays(%q): got %v, %v want %v", v.days, got, err, v.want)
+		}
+	}
+}
+
+func TestRateMatch(t *testing.T) {
+	weekday := weekdays(time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday)
+	peak := rate{days: weekday, start: 7 * 60, end: 22 * 60}
+	// 22:00 to 07:00, starting on the weekdays.
+	night := rate{days: weekday, start: 22 * 60, end: 7 * 60}
+	for _, v := range []struct {
+		name string
+		r    rate
+		wd   time.Weekday
+		m    int
+		want bool
+	}{
+		{"peak start", peak, time.Monday, 7 * 60, true},
+		{"peak before start", peak, time.Monday, 7*60 - 1, false},
+		{"peak end", peak, time.Friday, 22 * 60, false},
+		{"peak weekend", peak, time.Saturday, 12 * 60, false},
+		{"night start", night, time.Monday, 22 * 60, true},
+		{"night before start", night, time.Monday, 22*60 - 1, false},
+		{"night after midnight", night, time.Tuesday, 0, true},
+		{"night end", night, time.Tuesday, 7 * 60, false},
+		{"night from friday", night, time.Saturday, 6 * 60, true},
+		{"night not started sunday", night, time.Monday, 6 * 60, false},
+		{"night saturday", night, time.Saturday, 23 * 60, false},
+		{"night sunday", night, time.Sunday, 23 * 60, false},
+	} {
+		if got := v.r.match(v.wd, v.m); got != v.want {
+			t.Errorf("%s: got %v want %v", v.name, got, v.want)
+		}
+	}
+}
+
+func TestSeasonActive(t *testing.T) {
+	// Southern hemisphere summer, wrapping past the end of the year.
+	summer := &season{name: "summer", from: 1201, to: 228}
+	winter := &season{name: "winter", from: 601, to: 831}
+	for _, v := range []struct {
+		s    *season
+		date string
+		want bool
+	}{
+		{summer, "2025-12-01", true},
+		{summer, "2025-11-30", false},
+		{summer, "2025-12-31", true},
+		{summer, "2026-01-01", true},
+		{summer, "2026-02-28", true},
+		{summer, "2026-03-01", false},
+		{summer, "2026-07-01", false},
+		{winter, "2026-06-01", true},
+		{winter, "2026-08-31", true},
+		{winter, "2026-05-31", false},
+		{winter, "2026-09-01", false},
+		{winter, "2026-01-15", false},
+	} {
+		d, err := time.ParseInLocation(time.DateOnly, v.date, time.Local)
+		if err != nil {
+			t.Fatalf("%s: %v", v.date, err)
+		}
+		if got := v.s.active(d); got != v.want {
+			t.Errorf("%s active(%s): got %v want %v", v.s.name, v.date, got, v.want)
+		}
+	}
+}
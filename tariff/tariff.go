@@ -0,0 +1,224 @@
+// Copyright 2026 Andrew McRae
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// package tariff calculates the cost of imported energy and the
+// credit for exported energy using time-of-use rates.
+// The package is configured as a section in the YAML config file:
+//   tariff:
+//     update: <update interval in seconds>
+//     supply: <daily supply charge>
+//     rates:
+//       - days: <days e.g mon-fri>
+//         start: <HH:MM>
+//         end: <HH:MM>
+//         rate: <rate per KwH>
+//       - ...
+//     feedin:
+//       - rate: <rate per KwH>
+//     seasons:
+//       - name: <season name>
+//         from: <MM-DD>
+//         to: <MM-DD>
+//         rates:
+//           - ...
+//         feedin:
+//           - ...
+//
+// The costs are accumulated into:
+// Import cost -> A_IMPORT_COST
+// Export credit -> A_EXPORT_CREDIT
+// Supply charges -> A_SUPPLY_COST
+// Net cost -> A_NET_COST (derived)
+// The current rates are stored in G_IMPORT_RATE and G_FEEDIN_RATE.
+
+package tariff
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/aamcrae/MeterMan/core"
+)
+
+type Tariff struct {
+	Update  int      // Update interval (seconds)
+	Supply  float64  // Daily supply charge
+	Rates   []Period // Default import rates
+	Feedin  []Period // Default feed-in rates
+	Seasons []Season // Seasonal rates
+}
+
+const moduleName = "tariff"
+
+type tariff struct {
+	d       *core.DB
+	supply  float64
+	rates   schedule
+	feedin  schedule
+	seasons []*season
+	last    time.Time // Time of last update
+	lastIn  float64   // Import total at last update
+	lastOut float64   // Export total at last update
+	inOK    bool      // lastIn is valid
+	outOK   bool      // lastOut is valid
+	status  atomic.Value
+}
+
+func init() {
+	core.RegisterModule(moduleName, tariffInit)
+}
+
+func tariffInit(d *core.DB) error {
+	var conf Tariff
+	dec, ok := d.Config[moduleName]
+	if !ok {
+		return nil
+	}
+	err := dec.Decode(&conf)
+	if err != nil {
+		return err
+	}
+	update := core.ConfigOrDefault(conf.Update, 60) // Default update of 60 seconds
+	t := &tariff{d: d, supply: conf.Supply}
+	if t.rates, err = newSchedule(conf.Rates); err != nil {
+		return fmt.Errorf("tariff: rates: %v", err)
+	}
+	if t.feedin, err = newSchedule(conf.Feedin); err != nil {
+		return fmt.Errorf("tariff: feedin: %v", err)
+	}
+	for _, sc := range conf.Seasons {
+		s, err := newSeason(sc)
+		if err != nil {
+			return fmt.Errorf("tariff: %v", err)
+		}
+		t.seasons = append(t.seasons, s)
+	}
+	t.status.Store("init")
+	d.AddAccum(core.A_IMPORT_COST, false)
+	d.AddAccum(core.A_EXPORT_CREDIT, false)
+	d.AddAccum(core.A_SUPPLY_COST, false)
+	net := core.NewSumAccum(core.A_NET_COST)
+	net.Add(d.GetAccum(core.A_IMPORT_COST), false, true)
+	net.Add(d.GetAccum(core.A_SUPPLY_COST), false, true)
+	net.Add(d.GetAccum(core.A_EXPORT_CREDIT), true, true)
+	d.AddElement(core.A_NET_COST, net)
+	d.AddGauge(core.G_IMPORT_RATE)
+	d.AddGauge(core.G_FEEDIN_RATE)
+	if !d.Dryrun {
+		d.AddCallback(time.Second*time.Duration(update), 0, t.update)
+	}
+	d.AddStatusPrinter(moduleName, t.Status)
+	log.Printf("Registered tariff (%d seasons, %d second updates)", len(t.seasons), update)
+	return nil
+}
+
+// Status returns the current status
+func (t *tariff) Status() string {
+	return t.status.Load().(string)
+}
+
+// update is called in the main thread to calculate the costs
+// from the energy imported and exported since the last update.
+// The rate applying at the start of the interval is used.
+func (t *tariff) update(now time.Time) {
+	var b strings.Builder
+	defer func() { t.status.Store(b.String()) }()
+	fmt.Fprintf(&b, "%s: ", now.Format("2006-01-02 15:04"))
+	start := t.last
+	if start.IsZero() {
+		start = now
+	}
+	name, rates, feedin := t.schedules(start)
+	impRate, impOK := rates.lookup(start)
+	expRate, _ := feedin.lookup(start)
+	t.set(core.G_IMPORT_RATE, impRate, now)
+	t.set(core.G_FEEDIN_RATE, expRate, now)
+	var imp, exp float64
+	if v, ok := t.delta(core.A_IN_TOTAL, &t.lastIn, &t.inOK); ok {
+		imp = v
+	}
+	if v, ok := t.delta(core.A_OUT_TOTAL, &t.lastOut, &t.outOK); ok {
+		exp = v
+	}
+	var supply float64
+	if !t.last.IsZero() {
+		supply = t.supply * now.Sub(t.last).Hours() / 24
+	}
+	t.last = now
+	t.add(core.A_IMPORT_COST, imp*impRate, now)
+	t.add(core.A_EXPORT_CREDIT, exp*expRate, now)
+	t.add(core.A_SUPPLY_COST, supply, now)
+	if t.d.Trace {
+		log.Printf("tariff: import %g KwH at %g, export %g KwH at %g, supply %g", imp, impRate, exp, expRate, supply)
+	}
+	net := t.d.GetAccum(core.A_NET_COST)
+	if impOK {
+		fmt.Fprintf(&b, "OK")
+	} else {
+		fmt.Fprintf(&b, "No import rate")
+	}
+	if len(name) != 0 {
+		fmt.Fprintf(&b, " - season %s", name)
+	}
+	fmt.Fprintf(&b, ", import rate %s, feed-in rate %s, daily net cost %s",
+		core.FmtFloat(impRate), core.FmtFloat(expRate), core.FmtFloat(net.Daily()))
+}
+
+// schedules returns the season name and rates applying at the time.
+// A season without import or feed-in rates uses the default rates.
+func (t *tariff) schedules(tm time.Time) (string, schedule, schedule) {
+	for _, s := range t.seasons {
+		if s.active(tm) {
+			rates, feedin := s.rates, s.feedin
+			if len(rates) == 0 {
+				rates = t.rates
+			}
+			if len(feedin) == 0 {
+				feedin = t.feedin
+			}
+			return s.name, rates, feedin
+		}
+	}
+	return "", t.rates, t.feedin
+}
+
+// delta returns the change in the accumulator since the last update.
+// The accumulator must be fresh. If the accumulator has been reset,
+// the change is ignored.
+func (t *tariff) delta(tag string, last *float64, valid *bool) (float64, bool) {
+	a := t.d.GetAccum(tag)
+	if a == nil || !a.Fresh() {
+		return 0, false
+	}
+	v := a.Get()
+	prev, ok := *last, *valid
+	*last, *valid = v, true
+	if !ok || v < prev {
+		return 0, false
+	}
+	return v - prev, true
+}
+
+// add adds the value to the accumulator.
+func (t *tariff) add(tag string, v float64, now time.Time) {
+	e := t.d.GetElement(tag)
+	e.Update(e.Get()+v, now)
+}
+
+func (t *tariff) set(tag string, v float64, now time.Time) {
+	t.d.GetElement(tag).Update(v, now)
+}
@@ -0,0 +1,306 @@
+// Copyright 2026 Andrew McRae
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// meterman-checkpoint displays and edits the MeterMan checkpoint file.
+// MeterMan should be stopped before the checkpoint is edited, otherwise
+// the changes will be overwritten when the checkpoint is next saved.
+//
+//	meterman-checkpoint -checkpoint <file> [list]
+//	meterman-checkpoint -checkpoint <file> set <tag> [gauge|diff|accum] <checkpoint values>
+//	meterman-checkpoint -checkpoint <file> rename <tag> <new tag>
+//	meterman-checkpoint -checkpoint <file> delete <tag> ...
+//	meterman-checkpoint -checkpoint <file> rebase <tag> <device value>
+//
+// The checkpoint values are those saved for each type of element:
+//
+//	gauge: <value> <timestamp>
+//	diff:  <value> <previous value> <previous timestamp>
+//	accum: <midnight value> <value> <timestamp> <month value> <year value> <billing value> [<offset>]
+//
+// with the timestamps in Unix seconds. A timestamp of "now" is replaced with the current time.
+// Version 1 checkpoints do not record the element type, so the type is inferred from
+// the number of values (and for 3 values, from the tag, since power tags end in "-P").
+// rebase sets the offset of an accumulator so that its value continues from the saved
+// value when the device is replaced, using the current value of the new device.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aamcrae/MeterMan/core"
+)
+
+var checkpoint = flag.String("checkpoint", "", "Checkpoint file")
+var generations = flag.Int("generations", 2, "Number of previous checkpoint files kept")
+var force = flag.Bool("force", false, "Allow rename to replace an existing tag")
+
+// The number of checkpoint values allowed for each element type.
+var valueCounts = map[string][]int{
+	core.CP_GAUGE: {2},
+	core.CP_DIFF:  {3},
+	core.CP_ACCUM: {3, 6, 7},
+}
+
+func main() {
+	flag.Parse()
+	if len(*checkpoint) == 0 {
+		log.Fatalf("No checkpoint file specified (--checkpoint)")
+	}
+	c, file, err := core.LoadCheckpoint(*checkpoint, *generations)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if c == nil {
+		log.Fatalf("%s: no checkpoint file", *checkpoint)
+	}
+	if file != *checkpoint {
+		log.Printf("%s is invalid, using %s", *checkpoint, file)
+	}
+	args := flag.Args()
+	if len(args) == 0 {
+		args = []string{"list"}
+	}
+	if args[0] == "list" {
+		list(os.Stdout, c, file)
+		return
+	}
+	if err := run(c, args, *force); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if err := core.WriteCheckpoint(*checkpoint, c, *generations); err != nil {
+		log.Fatalf("%s: %v", *checkpoint, err)
+	}
+	log.Printf("%s updated", *checkpoint)
+}
+
+// run runs a command that edits the checkpoint.
+func run(c *core.CheckpointData, args []string, force bool) error {
+	switch args[0] {
+	case "set":
+		return set(c, args[1:])
+	case "rename":
+		return rename(c, args[1:], force)
+	case "delete":
+		return del(c, args[1:])
+	case "rebase":
+		return rebase(c, args[1:])
+	}
+	return fmt.Errorf("%s: unknown command", args[0])
+}
+
+// list displays the checkpoint entries, decoded according to the element type.
+func list(out io.Writer, c *core.CheckpointData, file string) {
+	now := time.Now()
+	fmt.Fprintf(out, "File %s, version %d, host %s, saved %s (%s ago)\n", file, c.Version, c.Host,
+		c.Time.Format(time.UnixDate), age(now, c.Time))
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Tag\tType\tValue\tDaily\tMonthly\tYearly\tBilling\tOffset\tTimestamp\tAge\t\n")
+	tags := make([]string, 0, len(c.Entries))
+	for t := range c.Entries {
+		tags = append(tags, t)
+	}
+	sort.Strings(tags)
+	for _, t := range tags {
+		e := c.Entries[t]
+		if e.Type == core.CP_STATE {
+			// Feature state is not an element, so it is shown as saved.
+			fmt.Fprintf(w, "%s\t%s\t%s\t\t\t\t\t\t\t\t\n", t, e.Type, e.Value)
+			continue
+		}
+		typ := entryType(t, e)
+		if typ != e.Type {
+			// Show that the type has been inferred.
+			typ = "(" + typ + ")"
+		}
+		el, err := core.RestoreElement(entryType(t, e), e.Value)
+		if err != nil {
+			fmt.Fprintf(w, "%s\t%s\t%s\t\t\t\t\t\t\t\t\n", t, typ, e.Value)
+			log.Printf("%s: invalid entry: %v", t, err)
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t", t, typ, core.FmtFloat(el.Get()))
+		if a, ok := el.(*core.Accum); ok {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t", core.FmtFloat(a.Daily()), core.FmtFloat(a.Monthly()),
+				core.FmtFloat(a.Yearly()), core.FmtFloat(a.Billing()), core.FmtFloat(a.Offset()))
+		} else {
+			fmt.Fprintf(w, "\t\t\t\t\t")
+		}
+		if ts := el.Timestamp(); !ts.IsZero() {
+			fmt.Fprintf(w, "%s\t%s\t\n", ts.Format(time.DateTime), age(now, ts))
+		} else {
+			fmt.Fprintf(w, "\t\t\n")
+		}
+	}
+	w.Flush()
+}
+
+// entryType returns the element type of the entry. Version 1 entries do not have a type,
+// so the type is inferred from the number of values. A gauge has 2 values, and an accumulator
+// has 3, 6 or 7 values. A diff has 3 values, and is used for the power tags (ending in "-P").
+func entryType(tag string, e core.CheckpointEntry) string {
+	if len(e.Type) != 0 {
+		return e.Type
+	}
+	switch len(strings.Fields(e.Value)) {
+	case 2:
+		return core.CP_GAUGE
+	case 3:
+		base, _, _ := strings.Cut(tag, "/")
+		if strings.HasSuffix(base, "-P") {
+			return core.CP_DIFF
+		}
+		return core.CP_ACCUM
+	case 6, 7:
+		return core.CP_ACCUM
+	}
+	return ""
+}
+
+// age returns the time since the timestamp.
+func age(now, ts time.Time) string {
+	return now.Sub(ts).Truncate(time.Second).String()
+}
+
+// set sets the checkpoint values of a tag, validating the values against the element type.
+// The type is only required if the tag is new, or the type is being changed.
+func set(c *core.CheckpointData, args []string) error {
+	if len(args) < 2 {
+		return errors.New("usage: set <tag> [gauge|diff|accum] <checkpoint values>")
+	}
+	tag := args[0]
+	if strings.ContainsAny(tag, ": \t\n") {
+		return fmt.Errorf("%s: invalid tag", tag)
+	}
+	e := c.Entries[tag]
+	old := e
+	e.Type = entryType(tag, e)
+	switch args[1] {
+	case core.CP_GAUGE, core.CP_DIFF, core.CP_ACCUM:
+		e.Type = args[1]
+		args = args[1:]
+	}
+	if e.Type == core.CP_STATE {
+		return fmt.Errorf("%s: feature state cannot be set", tag)
+	}
+	if len(e.Type) == 0 {
+		return fmt.Errorf("%s: element type required", tag)
+	}
+	vals := args[1:]
+	if !slices.Contains(valueCounts[e.Type], len(vals)) {
+		return fmt.Errorf("%s: %d values given, %s requires %s", tag, len(vals), e.Type, countList(valueCounts[e.Type]))
+	}
+	for i, v := range vals {
+		if v == "now" {
+			vals[i] = fmt.Sprint(time.Now().Unix())
+		}
+	}
+	el, err := core.RestoreElement(e.Type, strings.Join(vals, " "))
+	if err != nil {
+		return fmt.Errorf("%s: invalid %s values '%s': %v", tag, e.Type, strings.Join(vals, " "), err)
+	}
+	// Save the values as they are restored.
+	e.Value = el.(core.Checkpoint).Checkpoint()
+	log.Printf("%s: %s '%s' -> %s '%s'", tag, old.Type, old.Value, e.Type, e.Value)
+	c.Entries[tag] = e
+	return nil
+}
+
+// countList formats the allowed numbers of values.
+func countList(counts []int) string {
+	var s []string
+	for _, n := range counts {
+		s = append(s, strconv.Itoa(n))
+	}
+	return strings.Join(s, " or ")
+}
+
+// rename moves the checkpoint values from one tag to another.
+// An existing tag is only replaced if force is set.
+func rename(c *core.CheckpointData, args []string, force bool) error {
+	if len(args) != 2 {
+		return errors.New("usage: rename <tag> <new tag>")
+	}
+	e, ok := c.Entries[args[0]]
+	if !ok {
+		return fmt.Errorf("%s: not found", args[0])
+	}
+	if _, ok := c.Entries[args[1]]; ok && !force {
+		return fmt.Errorf("%s: already exists (use --force to replace)", args[1])
+	}
+	if strings.ContainsAny(args[1], ": \t\n") {
+		return fmt.Errorf("%s: invalid tag", args[1])
+	}
+	delete(c.Entries, args[0])
+	c.Entries[args[1]] = e
+	log.Printf("%s renamed to %s", args[0], args[1])
+	return nil
+}
+
+// rebase sets the offset of an accumulator from the current value of the new device.
+func rebase(c *core.CheckpointData, args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: rebase <tag> <device value>")
+	}
+	e, ok := c.Entries[args[0]]
+	if !ok {
+		return fmt.Errorf("%s: not found", args[0])
+	}
+	el, err := core.RestoreElement(entryType(args[0], e), e.Value)
+	if err != nil {
+		return fmt.Errorf("%s: %v", args[0], err)
+	}
+	a, ok := el.(*core.Accum)
+	if !ok {
+		return fmt.Errorf("%s: not an accumulator", args[0])
+	}
+	v, err := strconv.ParseFloat(args[1], 64)
+	if err != nil {
+		return fmt.Errorf("%s: %v", args[1], err)
+	}
+	a.Rebase(v)
+	e.Type = core.CP_ACCUM
+	e.Value = a.Checkpoint()
+	c.Entries[args[0]] = e
+	log.Printf("%s: value %g, offset %g", args[0], a.Get(), a.Offset())
+	return nil
+}
+
+// del removes the tags from the checkpoint.
+// The tags are checked before any are removed.
+func del(c *core.CheckpointData, tags []string) error {
+	if len(tags) == 0 {
+		return errors.New("usage: delete <tag> ...")
+	}
+	for _, t := range tags {
+		if _, ok := c.Entries[t]; !ok {
+			return fmt.Errorf("%s: not found", t)
+		}
+	}
+	for _, t := range tags {
+		delete(c.Entries, t)
+		log.Printf("%s deleted", t)
+	}
+	return nil
+}
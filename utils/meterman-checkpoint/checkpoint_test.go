@@ -0,0 +1,167 @@
+// Copyright 2026 Andrew McRae
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"testing"
+
+	"github.com/aamcrae/MeterMan/core"
+)
+
+// writeTest writes a checkpoint to a temporary directory, returning the file name.
+func writeTest(t *testing.T) string {
+	fn := filepath.Join(t.TempDir(), "checkpoint")
+	c := &core.CheckpointData{Host: "host", Time: time.Unix(1715659200, 0), Entries: map[string]core.CheckpointEntry{
+		"IN":   {Type: core.CP_ACCUM, Value: "90 100 1715659200 80 70 60"},
+		"IN-P": {Type: core.CP_GAUGE, Value: "1.5 1715659200"},
+		"S":    {Type: core.CP_STATE, Value: "12 34"},
+	}}
+	if err := core.WriteCheckpoint(fn, c, 2); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	return fn
+}
+
+func TestCommands(t *testing.T) {
+	for _, v := range []struct {
+		args  []string
+		force bool
+		err   bool
+		want  map[string]string // Type and value of tags, or empty if removed
+	}{
+		{[]string{"set", "IN-P", "2", "1715659300"}, false, false, map[string]string{"IN-P": "gauge 2 1715659300"}},
+		{[]string{"set", "IN-P", "diff", "2", "1", "1715659300"}, false, false, map[string]string{"IN-P": "diff 2 1 1715659300"}},
+		{[]string{"set", "NEW", "accum", "90", "100", "1715659200", "80", "70", "60", "5"}, false, false, map[string]string{"NEW": "accum 90 100 1715659200 80 70 60 5"}},
+		{[]string{"set", "NEW", "1", "2"}, false, true, map[string]string{"NEW": ""}},
+		{[]string{"set", "IN-P", "2"}, false, true, map[string]string{"IN-P": "gauge 1.5 1715659200"}},
+		{[]string{"set", "IN-P", "x", "1715659300"}, false, true, nil},
+		{[]string{"set", "S", "1", "2"}, false, true, map[string]string{"S": "state 12 34"}},
+		{[]string{"set", "A:B", "gauge", "1", "2"}, false, true, nil},
+		{[]string{"set", "IN-P"}, false, true, nil},
+		{[]string{"rename", "IN", "IMP"}, false, false, map[string]string{"IN": "", "IMP": "accum 90 100 1715659200 80 70 60"}},
+		{[]string{"rename", "IN", "IN-P"}, false, true, map[string]string{"IN-P": "gauge 1.5 1715659200"}},
+		{[]string{"rename", "IN", "IN-P"}, true, false, map[string]string{"IN": "", "IN-P": "accum 90 100 1715659200 80 70 60"}},
+		{[]string{"rename", "OUT", "IMP"}, false, true, nil},
+		{[]string{"rename", "IN", "A B"}, false, true, nil},
+		{[]string{"delete", "IN", "IN-P"}, false, false, map[string]string{"IN": "", "IN-P": "", "S": "state 12 34"}},
+		{[]string{"delete", "IN", "OUT"}, false, true, map[string]string{"IN": "accum 90 100 1715659200 80 70 60"}},
+		{[]string{"delete"}, false, true, nil},
+		{[]string{"rebase", "IN", "10"}, false, false, map[string]string{"IN": "accum 90 100 1715659200 80 70 60 90"}},
+		{[]string{"rebase", "IN-P", "10"}, false, true, nil},
+		{[]string{"rebase", "IN", "x"}, false, true, nil},
+		{[]string{"rebase", "OUT", "10"}, false, true, nil},
+		{[]string{"unknown"}, false, true, nil},
+	} {
+		name := strings.Join(v.args, " ")
+		fn := writeTest(t)
+		c, err := core.ReadCheckpoint(fn)
+		if err != nil {
+			t.Fatalf("%s: read: %v", name, err)
+		}
+		err = run(c, v.args, v.force)
+		if (err != nil) != v.err {
+			t.Errorf("%s: got error %v want error %v", name, err, v.err)
+			continue
+		}
+		if err == nil {
+			if err := core.WriteCheckpoint(fn, c, 2); err != nil {
+				t.Fatalf("%s: write: %v", name, err)
+			}
+			if c, err = core.ReadCheckpoint(fn); err != nil {
+				t.Fatalf("%s: reread: %v", name, err)
+			}
+		}
+		for tag, want := range v.want {
+			var got string
+			if e, ok := c.Entries[tag]; ok {
+				got = e.Type + " " + e.Value
+			}
+			if got != want {
+				t.Errorf("%s: %s: got %q want %q", name, tag, got, want)
+			}
+		}
+	}
+}
+
+func TestEntryType(t *testing.T) {
+	for _, v := range []struct {
+		tag   string
+		entry core.CheckpointEntry
+		want  string
+	}{
+		{"G", core.CheckpointEntry{Value: "1.5 1715659200"}, core.CP_GAUGE},
+		{"IN-P", core.CheckpointEntry{Value: "2 1 1715659200"}, core.CP_DIFF},
+		{"GEN-P/0", core.CheckpointEntry{Value: "2 1 1715659200"}, core.CP_DIFF},
+		{"IN", core.CheckpointEntry{Value: "90 100 1715659200"}, core.CP_ACCUM},
+		{"IN/0-P", core.CheckpointEntry{Value: "90 100 1715659200"}, core.CP_ACCUM},
+		{"IN", core.CheckpointEntry{Value: "90 100 1715659200 80 70 60"}, core.CP_ACCUM},
+		{"IN", core.CheckpointEntry{Value: "90 100 1715659200 80 70 60 5"}, core.CP_ACCUM},
+		{"X", core.CheckpointEntry{Value: "1"}, ""},
+		{"X", core.CheckpointEntry{Value: "1 2 3 4"}, ""},
+		{"IN-P", core.CheckpointEntry{Type: core.CP_GAUGE, Value: "2 1 1715659200"}, core.CP_GAUGE},
+		{"S", core.CheckpointEntry{Type: core.CP_STATE, Value: "12 34"}, core.CP_STATE},
+	} {
+		if got := entryType(v.tag, v.entry); got != v.want {
+			t.Errorf("entryType(%s, %q): got %q want %q", v.tag, v.entry.Value, got, v.want)
+		}
+	}
+}
+
+// TestV1 checks that a version 1 checkpoint is listed and edited using
+// the inferred types, and is written in the current format.
+func TestV1(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "checkpoint")
+	v1 := "IN:90 100 1715659200\nIN-P:2 1 1715659200\nG:1.5 1715659200\ntime:1715659200\n"
+	if err := os.WriteFile(fn, []byte(v1), 0644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	c, _, err := core.LoadCheckpoint(fn, 2)
+	if err != nil || c == nil || c.Version != 1 {
+		t.Fatalf("load: %v %v", c, err)
+	}
+	var b bytes.Buffer
+	list(&b, c, fn)
+	for _, want := range []string{"IN    (accum)", "IN-P  (diff)", "G     (gauge)"} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("list: missing %q in:\n%s", want, b.String())
+		}
+	}
+	if err := run(c, []string{"set", "IN-P", "3", "2", "1715659300"}, false); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if err := run(c, []string{"rebase", "IN", "10"}, false); err != nil {
+		t.Fatalf("rebase: %v", err)
+	}
+	if err := core.WriteCheckpoint(fn, c, 2); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if c, err = core.ReadCheckpoint(fn); err != nil || c.Version != core.CheckpointVersion {
+		t.Fatalf("reread: %v %v", c, err)
+	}
+	for tag, want := range map[string]string{
+		"IN-P": "diff 3 2 1715659300",
+		"IN":   "accum 90 100 1715659200 90 90 90 90",
+	} {
+		if e := c.Entries[tag]; e.Type+" "+e.Value != want {
+			t.Errorf("%s: got %q want %q", tag, e.Type+" "+e.Value, want)
+		}
+	}
+}
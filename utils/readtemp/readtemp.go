@@ -15,6 +15,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
 
@@ -29,7 +30,7 @@ func init() {
 
 func main() {
 
-	t, err := weather.BOM(*url)
+	t, err := weather.BOM(context.Background(), *url)
 	if err != nil {
 		log.Fatalf("%s: %v", *url, err)
 	}
@@ -17,6 +17,9 @@ package main
 import (
 	"flag"
 	"log"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/aamcrae/MeterMan/sma"
 )
@@ -24,6 +27,10 @@ import (
 var inverter = flag.String("inverter", "inverter:9522", "Inverter address and port")
 var password = flag.String("password", "", "Inverter password")
 var getall = flag.Bool("all", false, "Get all records")
+var installer = flag.Bool("installer", false, "Logon as installer")
+var discover = flag.Bool("discover", false, "Discover inverters on the local network")
+var events = flag.Bool("events", false, "Display the event log")
+var since = flag.Duration("since", time.Hour*24, "Display events since this duration ago")
 
 func init() {
 	flag.Parse()
@@ -31,6 +38,16 @@ func init() {
 
 func main() {
 
+	if *discover {
+		devs, err := sma.DiscoverInverters(*password, time.Second*5)
+		if err != nil {
+			log.Fatalf("Discover: %v", err)
+		}
+		for _, d := range devs {
+			log.Printf("Inverter %s, ID = %d, serial number = %d\n", d.Addr, d.Susyid, d.Serial)
+		}
+		return
+	}
 	sma, err := sma.NewSMA(*inverter, *password)
 	if err != nil {
 		log.Fatalf("%s: %v", *inverter, err)
@@ -38,12 +55,26 @@ func main() {
 	if *getall {
 		sma.Trace = true
 	}
+	sma.Installer = *installer
 	id, serial, err := sma.Logon()
 	if err != nil {
 		log.Fatalf("Logon: %v", err)
 	}
 	defer sma.Logoff()
 	log.Printf("ID = %d, serial number = %d\n", id, serial)
+	if *events {
+		showEvents(sma, time.Now().Add(-*since))
+		return
+	}
+	if flag.NArg() > 0 {
+		switch flag.Arg(0) {
+		case "limit":
+			limit(sma, flag.Args()[1:])
+		default:
+			log.Fatalf("%s: unknown command", flag.Arg(0))
+		}
+		return
+	}
 	stat, err := sma.DeviceStatus()
 	if err != nil {
 		log.Fatalf("DeviceStatus: %v", err)
@@ -73,7 +104,104 @@ func main() {
 		log.Fatalf("MPTT: %v", err)
 	}
 	log.Printf("mptt_a = %g, mptt_b = %g\n", vs[0], vs[1])
+	for _, v := range []struct {
+		name string
+		get  func() ([]float64, error)
+	}{
+		{"phase power", sma.PhasePower},
+		{"phase current", sma.PhaseCurrent},
+		{"phase voltage", sma.PhaseVoltage},
+		{"DC voltage", sma.DCVoltage},
+		{"DC current", sma.DCCurrent},
+	} {
+		vals, err := v.get()
+		if err != nil {
+			log.Printf("%s: %v", v.name, err)
+		} else {
+			log.Printf("%s = %v\n", v.name, vals)
+		}
+	}
+	for _, v := range []struct {
+		name string
+		get  func() (float64, error)
+	}{
+		{"frequency", sma.Frequency},
+		{"temperature", sma.Temperature},
+		{"insulation resistance", sma.Insulation},
+	} {
+		val, err := v.get()
+		if err != nil {
+			log.Printf("%s: %v", v.name, err)
+		} else {
+			log.Printf("%s = %g\n", v.name, val)
+		}
+	}
 	if *getall {
 		sma.GetAll()
 	}
 }
+
+// limit displays or sets the active power limit.
+// The limit is specified in watts, or as a percentage
+// of the nominal power e.g "limit 3000" or "limit 50%".
+func limit(s *sma.SMA, args []string) {
+	if len(args) > 1 {
+		log.Fatalf("usage: limit [watts | percent%%]")
+	}
+	if len(args) == 1 {
+		var err error
+		if pct, ok := strings.CutSuffix(args[0], "%"); ok {
+			var v float64
+			v, err = strconv.ParseFloat(pct, 64)
+			if err != nil {
+				log.Fatalf("%s: %v", args[0], err)
+			}
+			err = s.SetPowerLimitPercent(v)
+		} else {
+			var v float64
+			v, err = strconv.ParseFloat(strings.TrimSuffix(args[0], "W"), 64)
+			if err != nil {
+				log.Fatalf("%s: %v", args[0], err)
+			}
+			err = s.SetPowerLimit(v)
+		}
+		if err != nil {
+			log.Fatalf("Limit: %v", err)
+		}
+	}
+	nominal, err := s.NominalPower()
+	if err != nil {
+		log.Fatalf("Nominal power: %v", err)
+	}
+	l, err := s.PowerLimit()
+	if err != nil {
+		log.Fatalf("Power limit: %v", err)
+	}
+	log.Printf("nominal power = %g W, power limit = %g W\n", nominal, l)
+}
+
+// showEvents displays the event log entries since the time given.
+func showEvents(s *sma.SMA, t time.Time) {
+	evs, err := s.Events(t)
+	if err != nil {
+		log.Fatalf("Events: %v", err)
+	}
+	for _, e := range evs {
+		level := "info"
+		if e.Fault() {
+			level = "fault"
+		} else if e.Warning() {
+			level = "warning"
+		}
+		var cleared string
+		if e.Cleared() {
+			cleared = " (cleared)"
+		}
+		log.Printf("%s: entry %d, event %d, %s%s, group 0x%x, tag %d, counter %d\n",
+			e.Time.Format(time.RFC822), e.Entry, e.Code, level, cleared, e.Group, e.Tag, e.Counter)
+		if e.Parameter != 0 {
+			log.Printf("    parameter 0x%08x, old value %d, new value %d\n", e.Parameter, e.OldValue, e.NewValue)
+		}
+	}
+	log.Printf("%d events since %s\n", len(evs), t.Format(time.RFC822))
+}
@@ -30,11 +30,13 @@
 package weather
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"sync/atomic"
 	"time"
 
 	"github.com/aamcrae/MeterMan/core"
@@ -54,8 +56,16 @@ type Weather struct {
 	Darkskylong string
 }
 
+// tempReader polls the weather service for the current temperature.
+type tempReader struct {
+	d      *core.DB
+	poll   time.Duration                          // Poll interval
+	get    func(context.Context) (float64, error) // Service access function
+	status atomic.Value
+}
+
 func init() {
-	core.RegisterInit(weatherReader)
+	core.RegisterExternal("weather", weatherReader)
 }
 
 func weatherReader(d *core.DB) error {
@@ -69,49 +79,69 @@ func weatherReader(d *core.DB) error {
 		return err
 	}
 	poll := core.ConfigOrDefault(conf.Poll, 120) // Default poll interval of 120 seconds
-	var get func() (float64, error)
+	r := &tempReader{d: d, poll: time.Duration(poll) * time.Second}
 	switch conf.Tempservice {
 	default:
 		return fmt.Errorf("%s: Unknown weather service", conf.Tempservice)
 	case "bom":
-		get = func() (float64, error) {
-			return BOM(conf.Bom)
+		r.get = func(ctx context.Context) (float64, error) {
+			return BOM(ctx, conf.Bom)
 		}
 	case "openweather":
 		url := fmt.Sprintf(weatherUrl, conf.Tempid, conf.Tempkey)
-		get = func() (float64, error) {
-			return OpenWeather(url)
+		r.get = func(ctx context.Context) (float64, error) {
+			return OpenWeather(ctx, url)
 		}
 	case "darksky":
 		url := fmt.Sprintf(darkskyUrl, conf.Darkskykey, conf.Darkskylat, conf.Darkskylong)
-		get = func() (float64, error) {
-			return Darksky(url)
+		r.get = func(ctx context.Context) (float64, error) {
+			return Darksky(ctx, url)
 		}
 	}
+	r.status.Store("init")
 	log.Printf("Registered temperature reader using service %s, polling every %d seconds\n", conf.Tempservice, poll)
 	d.AddGauge(core.G_TEMP)
-	if !d.Dryrun {
-		go reader(d, poll, get)
-	}
+	return d.AddModule("weather", r)
+}
+
+func (r *tempReader) Start(ctx context.Context) error {
+	go r.reader(ctx)
+	return nil
+}
+
+func (r *tempReader) Stop(ctx context.Context) error {
 	return nil
 }
 
-func reader(d *core.DB, poll int, get func() (float64, error)) {
+func (r *tempReader) Status() string {
+	return r.status.Load().(string)
+}
+
+// reader polls the service until the context is cancelled.
+func (r *tempReader) reader(ctx context.Context) {
 	for {
-		t, err := get()
+		t, err := r.get(ctx)
+		ts := time.Now().Format("2006-01-02 15:04")
 		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
 			log.Printf("Getting temperature: %v\n", err)
+			r.status.Store(fmt.Sprintf("%s: Error - %v", ts, err))
 		} else {
-			if d.Trace {
+			if r.d.Trace {
 				log.Printf("Current temperature: %g\n", t)
 			}
-			d.Input(core.G_TEMP, t)
+			r.d.Input(core.G_TEMP, t)
+			r.status.Store(fmt.Sprintf("%s: OK - %s", ts, core.FmtFloat(t)))
+		}
+		if !core.Sleep(ctx, r.poll) {
+			return
 		}
-		time.Sleep(time.Duration(poll) * time.Second)
 	}
 }
 
-func OpenWeather(url string) (float64, error) {
+func OpenWeather(ctx context.Context, url string) (float64, error) {
 	type Main struct {
 		Temp float64
 	}
@@ -121,7 +151,7 @@ func OpenWeather(url string) (float64, error) {
 		Message string
 	}
 	var m resp
-	err := fetch(url, &m)
+	err := fetch(ctx, url, &m)
 	if err != nil {
 		return 0, err
 	}
@@ -131,7 +161,7 @@ func OpenWeather(url string) (float64, error) {
 	return m.Main.Temp, nil
 }
 
-func Darksky(url string) (float64, error) {
+func Darksky(ctx context.Context, url string) (float64, error) {
 	type Currently struct {
 		Temp    float64 `json:"temperature"`
 		Aparent float64 `json:"apparentTemperature"`
@@ -140,14 +170,14 @@ func Darksky(url string) (float64, error) {
 		Currently Currently
 	}
 	var m resp
-	err := fetch(url, &m)
+	err := fetch(ctx, url, &m)
 	if err != nil {
 		return 0, err
 	}
 	return m.Currently.Temp, nil
 }
 
-func BOM(url string) (float64, error) {
+func BOM(ctx context.Context, url string) (float64, error) {
 	type Data struct {
 		Apparant float64 `json:"apparent_t"`
 		Air      float64 `json:"air_temp"`
@@ -159,7 +189,7 @@ func BOM(url string) (float64, error) {
 		Observations *Ob
 	}
 	var m resp
-	err := fetch(url, &m)
+	err := fetch(ctx, url, &m)
 	if err != nil {
 		return 0, err
 	}
@@ -169,8 +199,12 @@ func BOM(url string) (float64, error) {
 	return m.Observations.Data[0].Air, nil
 }
 
-func fetch(url string, m any) error {
-	resp, err := http.Get(url)
+func fetch(ctx context.Context, url string, m any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return err
 	}